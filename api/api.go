@@ -6,6 +6,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"raha-xray/api/bot"
 	"raha-xray/api/handlers"
 	"raha-xray/api/job"
 	"raha-xray/api/network"
@@ -27,19 +28,30 @@ type Server struct {
 	httpServer *http.Server
 	listener   net.Listener
 
-	Inbound  *handlers.InboundHandler
-	Config   *handlers.ConfigHandler
-	Client   *handlers.ClientHandler
-	Outbound *handlers.OutboundHandler
-	Rule     *handlers.RuleHandler
-	Server   *handlers.ServerHandler
-	Setting  *handlers.SettingsHandler
+	Inbound     *handlers.InboundHandler
+	Config      *handlers.ConfigHandler
+	Client      *handlers.ClientHandler
+	Outbound    *handlers.OutboundHandler
+	Rule        *handlers.RuleHandler
+	Balancer    *handlers.BalancerHandler
+	Observatory *handlers.ObservatoryHandler
+	Dns         *handlers.DnsHandler
+	Reverse     *handlers.ReverseHandler
+	Server      *handlers.ServerHandler
+	Setting     *handlers.SettingsHandler
+	Webhook     *handlers.WebhookHandler
+	Geo         *handlers.GeoHandler
+	Node        *handlers.NodeHandler
+	Agent       *handlers.AgentHandler
+	Quota       *handlers.QuotaHandler
+	Doc         *handlers.DocHandler
 
 	SettingService services.SettingService
 	XrayService    services.XrayService
 
 	appSettings *config.Setting
 	cron        *cron.Cron
+	bot         *bot.Bot
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -71,8 +83,24 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	s.Client = handlers.NewClientHandler(g)
 	s.Outbound = handlers.NewOutboundHandler(g)
 	s.Rule = handlers.NewRuleHandler(g)
+	s.Balancer = handlers.NewBalancerHandler(g)
+	s.Observatory = handlers.NewObservatoryHandler(g)
+	s.Dns = handlers.NewDnsHandler(g)
+	s.Reverse = handlers.NewReverseHandler(g)
 	s.Server = handlers.NewServerHandler(g)
 	s.Setting = handlers.NewSettingsHandler(g)
+	s.Webhook = handlers.NewWebhookHandler(g)
+	s.Geo = handlers.NewGeoHandler(g)
+	s.Node = handlers.NewNodeHandler(g)
+	s.Quota = handlers.NewQuotaHandler(g)
+	if s.appSettings.AgentMode {
+		s.Agent = handlers.NewAgentHandler(g)
+	}
+	s.Doc = handlers.NewDocHandler(g)
+
+	for _, route := range handlers.UndocumentedRoutes(engine.Routes(), s.appSettings.BasePath) {
+		logger.Warning("route is missing in openapi.json:", route)
+	}
 
 	return engine, nil
 }
@@ -94,6 +122,32 @@ func (s *Server) startTask() {
 			// Daily deleting old traffics
 			s.cron.AddJob("@daily", job.NewDelTrafficJob())
 		}
+
+		if len(appConfig.Webhooks) > 0 {
+			// Daily deleting old webhook delivery logs
+			s.cron.AddJob("@daily", job.NewDelWebhookLogJob())
+		}
+
+		if appConfig.HealthCheckInterval > 0 {
+			// Outbound health checks
+			s.cron.AddJob("@every "+strconv.Itoa(appConfig.HealthCheckInterval)+"s", job.NewHealthCheckJob())
+		}
+
+		if appConfig.OutboundSubUpdate != "" {
+			// Scheduled outbound subscription updates
+			_, err := s.cron.AddJob(appConfig.OutboundSubUpdate, job.NewSubscriptionUpdateJob())
+			if err != nil {
+				logger.Warning("schedule outbound subscription update failed:", err)
+			}
+		}
+
+		if appConfig.GeoUpdate != "" {
+			// Scheduled geoip and geosite updates
+			_, err := s.cron.AddJob(appConfig.GeoUpdate, job.NewGeoUpdateJob())
+			if err != nil {
+				logger.Warning("schedule geo update failed:", err)
+			}
+		}
 	}()
 }
 
@@ -157,6 +211,11 @@ func (s *Server) Start() error {
 
 	s.startTask()
 
+	if bot.IsEnabled() {
+		s.bot = bot.NewBot()
+		s.bot.Start(s.ctx)
+	}
+
 	s.httpServer = &http.Server{
 		Handler: engine,
 	}
@@ -170,6 +229,9 @@ func (s *Server) Start() error {
 
 func (s *Server) Stop() error {
 	s.cancel()
+	if s.bot != nil {
+		s.bot.Stop()
+	}
 	if s.cron != nil {
 		s.cron.Stop()
 	}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"raha-xray/api/handlers"
+	"raha-xray/config"
+	"testing"
+)
+
+func TestRoutesAreDocumented(t *testing.T) {
+	settings := *config.GetDefaultSettings()
+	settings.AgentMode = true
+	config.SetSettings(&settings)
+	s := NewServer()
+	s.appSettings = &settings
+	engine, err := s.initRouter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(engine.Routes()) == 0 {
+		t.Fatal("no routes are registered")
+	}
+	for _, route := range handlers.UndocumentedRoutes(engine.Routes(), settings.BasePath) {
+		t.Error("route is missing in openapi.json:", route)
+	}
+}
@@ -0,0 +1,230 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"raha-xray/api/services"
+	"raha-xray/config"
+	"raha-xray/database/model"
+	"raha-xray/logger"
+	"strings"
+	"time"
+)
+
+type Bot struct {
+	services.ServerService
+	services.ClientService
+
+	api    *TelegramAPI
+	admins map[int64]bool
+	cancel context.CancelFunc
+}
+
+func NewBot() *Bot {
+	appConfig := config.GetSettings()
+	b := &Bot{
+		api:    NewTelegramAPI(appConfig.TgApiUrl, appConfig.TgToken),
+		admins: make(map[int64]bool),
+	}
+	for _, admin := range appConfig.TgAdmins {
+		b.admins[admin] = true
+	}
+	return b
+}
+
+func IsEnabled() bool {
+	return config.GetSettings().TgToken != ""
+}
+
+func (b *Bot) Start(ctx context.Context) {
+	ctx, b.cancel = context.WithCancel(ctx)
+	services.SetEventListener("telegram", b.onEvent)
+	go b.poll(ctx)
+	logger.Info("telegram bot started")
+}
+
+func (b *Bot) Stop() {
+	services.SetEventListener("telegram", nil)
+	if b.cancel != nil {
+		b.cancel()
+	}
+}
+
+func (b *Bot) poll(ctx context.Context) {
+	var offset int64
+	for {
+		updates, err := b.api.GetUpdates(ctx, offset, 30)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Debug("telegram get updates failed:", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for _, update := range updates {
+			offset = update.UpdateId + 1
+			if update.Message != nil && update.Message.Text != "" {
+				b.handle(update.Message)
+			}
+		}
+	}
+}
+
+func (b *Bot) reply(chatId int64, text string) {
+	err := b.api.SendMessage(chatId, text)
+	if err != nil {
+		logger.Debug("telegram send message failed:", err)
+	}
+}
+
+func (b *Bot) handle(msg *Message) {
+	fields := strings.Fields(msg.Text)
+	if len(fields) == 0 {
+		return
+	}
+	command := strings.Split(fields[0], "@")[0]
+	args := fields[1:]
+	chatId := msg.Chat.Id
+
+	if b.admins[msg.From.Id] {
+		switch command {
+		case "/start", "/help":
+			b.reply(chatId, "/status - server status\n/onlines - online clients\n/client <name> - client info\n/enable <name>\n/disable <name>")
+			return
+		case "/status":
+			b.reply(chatId, b.status())
+			return
+		case "/onlines":
+			onlines := b.ClientService.GetOnlineClinets()
+			b.reply(chatId, fmt.Sprintf("Online clients (%d):\n%s", len(onlines), strings.Join(onlines, "\n")))
+			return
+		case "/client", "/enable", "/disable":
+			if len(args) != 1 {
+				b.reply(chatId, "Usage: "+command+" <name>")
+				return
+			}
+			client, err := b.ClientService.GetByName(args[0])
+			if err != nil {
+				b.reply(chatId, "Client not found")
+				return
+			}
+			if command != "/client" {
+				err = b.setEnable(client, command == "/enable")
+				if err != nil {
+					b.reply(chatId, "Failed: "+err.Error())
+					return
+				}
+			}
+			b.reply(chatId, clientInfo(client))
+			return
+		}
+	}
+
+	switch command {
+	case "/start", "/help":
+		b.reply(chatId, "/link <name> <id or password> - link your account\n/usage - your usage\n/sub - your subscription link")
+	case "/link":
+		if len(args) != 2 {
+			b.reply(chatId, "Usage: /link <name> <id or password>")
+			return
+		}
+		client, err := b.ClientService.Link(args[0], args[1], msg.From.Id)
+		if err != nil {
+			b.reply(chatId, "Client not found")
+			return
+		}
+		b.reply(chatId, "Linked to "+client.Name)
+	case "/usage", "/sub":
+		client, err := b.ClientService.GetByTgId(msg.From.Id)
+		if err != nil {
+			b.reply(chatId, "No linked account, use /link first")
+			return
+		}
+		if command == "/usage" {
+			b.reply(chatId, clientInfo(client))
+			return
+		}
+		subUrl := config.GetSettings().SubUrl
+		if subUrl == "" {
+			b.reply(chatId, "Subscription is not available")
+			return
+		}
+		b.reply(chatId, strings.TrimSuffix(subUrl, "/")+"/"+client.Name)
+	default:
+		b.reply(chatId, "Unknown command, send /help")
+	}
+}
+
+func (b *Bot) setEnable(client *model.Client, enable bool) error {
+	err, needRestart := b.ClientService.Update(map[string]interface{}{
+		"id":     client.Id,
+		"enable": enable,
+	})
+	if err != nil {
+		return err
+	}
+	b.ServerService.XrayService.WriteConfigFile(needRestart)
+	client.Enable = enable
+	return nil
+}
+
+func (b *Bot) status() string {
+	status := b.ServerService.GetStatus(nil)
+	return fmt.Sprintf("Xray: %s %s\nCPU: %.1f%% of %d cores\nMemory: %s / %s\nDisk: %s / %s\nLoad: %v\nTCP/UDP: %d/%d\nUptime: %s",
+		status.Xray.State, status.Xray.Version,
+		status.Cpu, status.CpuCount,
+		formatTraffic(status.Mem.Current), formatTraffic(status.Mem.Total),
+		formatTraffic(status.Disk.Current), formatTraffic(status.Disk.Total),
+		status.Loads,
+		status.TcpCount, status.UdpCount,
+		time.Duration(status.Uptime)*time.Second)
+}
+
+func (b *Bot) onEvent(event *services.Event) {
+	var text string
+	switch event.Type {
+	case services.EventQuota:
+		text = fmt.Sprintf("%s used %d%% of quota", event.Client.Name, event.Level)
+	case services.EventExpiry:
+		text = fmt.Sprintf("%s expires in less than %d days", event.Client.Name, event.Level)
+	case services.EventDisabled:
+		text = fmt.Sprintf("%s is disabled", event.Client.Name)
+	case services.EventReset:
+		text = fmt.Sprintf("%s usage is reset", event.Client.Name)
+	default:
+		text = "Event: " + event.Type
+	}
+	for admin := range b.admins {
+		b.reply(admin, text)
+	}
+	if event.Client != nil && event.Client.TgId != 0 && !b.admins[event.Client.TgId] {
+		b.reply(event.Client.TgId, text)
+	}
+}
+
+func clientInfo(client *model.Client) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Name: %s\nEnable: %v\nUsage: %s", client.Name, client.Enable, formatTraffic(client.Up+client.Down))
+	if client.Quota > 0 {
+		fmt.Fprintf(&sb, " / %s", formatTraffic(client.Quota))
+	}
+	if client.Expiry > 0 {
+		fmt.Fprintf(&sb, "\nExpiry: %s", time.UnixMilli(int64(client.Expiry)).Format("2006-01-02 15:04"))
+	}
+	if client.Once > 0 {
+		fmt.Fprintf(&sb, "\nDays after first use: %d", client.Once)
+	}
+	return sb.String()
+}
+
+func formatTraffic(bytes uint64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(bytes)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.2f %s", value, units[i])
+}
@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"raha-xray/api/services"
+	"raha-xray/config"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type sentMessage struct {
+	ChatId int64  `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+// fakeBotAPI serves queued updates and records sent messages like the Bot API
+type fakeBotAPI struct {
+	lock    sync.Mutex
+	updates []*Update
+	sent    chan sentMessage
+}
+
+func (f *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var result interface{} = true
+	switch r.URL.Path {
+	case "/bottest-token/getUpdates":
+		var params struct {
+			Offset int64 `json:"offset"`
+		}
+		json.NewDecoder(r.Body).Decode(&params)
+		f.lock.Lock()
+		updates := []*Update{}
+		for _, update := range f.updates {
+			if update.UpdateId >= params.Offset {
+				updates = append(updates, update)
+			}
+		}
+		f.lock.Unlock()
+		if len(updates) == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		result = updates
+	case "/bottest-token/sendMessage":
+		var message sentMessage
+		json.NewDecoder(r.Body).Decode(&message)
+		f.sent <- message
+	default:
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "description": "Not Found"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func (f *fakeBotAPI) push(updateId int64, from int64, text string) {
+	update := &Update{UpdateId: updateId, Message: &Message{Text: text}}
+	update.Message.Chat.Id = from
+	update.Message.From.Id = from
+	f.lock.Lock()
+	f.updates = append(f.updates, update)
+	f.lock.Unlock()
+}
+
+func (f *fakeBotAPI) expect(t *testing.T, chatId int64, text string) {
+	t.Helper()
+	select {
+	case message := <-f.sent:
+		if message.ChatId != chatId || !strings.Contains(message.Text, text) {
+			t.Errorf("sent %q to %d, want %q to %d", message.Text, message.ChatId, text, chatId)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message %q sent to %d", text, chatId)
+	}
+}
+
+func initTestBot(t *testing.T) *fakeBotAPI {
+	t.Helper()
+	api := &fakeBotAPI{sent: make(chan sentMessage, 10)}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	settings := *config.GetDefaultSettings()
+	settings.DbAddr = t.TempDir()
+	settings.TgToken = "test-token"
+	settings.TgApiUrl = server.URL
+	settings.TgAdmins = []int64{1}
+	config.SetSettings(&settings)
+	err := database.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, err := database.GetDB().DB()
+		if err == nil {
+			sqlDB.Close()
+		}
+	})
+	return api
+}
+
+func TestBot(t *testing.T) {
+	api := initTestBot(t)
+	client := &model.Client{
+		Name:           "alice",
+		Enable:         true,
+		Quota:          1024,
+		ClientInbounds: []model.ClientInbound{{InboundId: 1, Config: `{"id":"alice-uuid"}`}},
+	}
+	err := database.GetDB().Create(client).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBot()
+	b.Start(context.Background())
+	t.Cleanup(b.Stop)
+
+	api.push(1, 7, "/usage")
+	api.expect(t, 7, "No linked account")
+	api.push(2, 7, "/link alice wrong-uuid")
+	api.expect(t, 7, "Client not found")
+	api.push(3, 7, "/link alice alice-uuid")
+	api.expect(t, 7, "Linked to alice")
+	api.push(4, 7, "/usage")
+	api.expect(t, 7, "Name: alice")
+	api.push(5, 7, "/client alice")
+	api.expect(t, 7, "Unknown command")
+	api.push(6, 1, "/client@raha_bot alice")
+	api.expect(t, 1, "Usage: 0.00 B / 1.00 KB")
+
+	// events go to admins and to the linked client
+	client.TgId = 7
+	b.onEvent(services.NewEvent(services.EventQuota, client, 80))
+	api.expect(t, 1, "alice used 80% of quota")
+	api.expect(t, 7, "alice used 80% of quota")
+}
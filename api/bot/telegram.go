@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"raha-xray/util/common"
+	"strings"
+	"time"
+)
+
+type Update struct {
+	UpdateId int64    `json:"update_id"`
+	Message  *Message `json:"message"`
+}
+
+type Message struct {
+	MessageId int64  `json:"message_id"`
+	Text      string `json:"text"`
+	Chat      struct {
+		Id int64 `json:"id"`
+	} `json:"chat"`
+	From struct {
+		Id       int64  `json:"id"`
+		Username string `json:"username"`
+	} `json:"from"`
+}
+
+type apiResponse struct {
+	Ok          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// TelegramAPI is a minimal Bot API client, apiUrl can point to any compatible server
+type TelegramAPI struct {
+	apiUrl string
+	token  string
+	client *http.Client
+}
+
+func NewTelegramAPI(apiUrl string, token string) *TelegramAPI {
+	return &TelegramAPI{
+		apiUrl: strings.TrimSuffix(apiUrl, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (t *TelegramAPI) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/bot%s/%s", t.apiUrl, url.PathEscape(t.token), method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var apiResp apiResponse
+	err = json.NewDecoder(resp.Body).Decode(&apiResp)
+	if err != nil {
+		return err
+	}
+	if !apiResp.Ok {
+		return common.NewErrorf("telegram %s failed: %s", method, apiResp.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(apiResp.Result, result)
+	}
+	return nil
+}
+
+func (t *TelegramAPI) GetUpdates(ctx context.Context, offset int64, timeout int) ([]*Update, error) {
+	var updates []*Update
+	err := t.call(ctx, "getUpdates", map[string]interface{}{
+		"offset":          offset,
+		"timeout":         timeout,
+		"allowed_updates": []string{"message"},
+	}, &updates)
+	return updates, err
+}
+
+func (t *TelegramAPI) SendMessage(chatId int64, text string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return t.call(ctx, "sendMessage", map[string]interface{}{
+		"chat_id": chatId,
+		"text":    text,
+	}, nil)
+}
@@ -1,7 +1,19 @@
 package entity
 
+// error codes of failed responses, they are stable unlike messages
+const (
+	CodeNotFound         = "not_found"
+	CodeValidationFailed = "validation_failed"
+	CodeConflict         = "conflict"
+	CodeXrayUnavailable  = "xray_unavailable"
+	CodeUnauthorized     = "unauthorized"
+	CodeFailed           = "failed"
+)
+
 type Msg struct {
 	Success bool        `json:"success"`
 	Msg     string      `json:"msg"`
+	Code    string      `json:"code,omitempty"`
 	Obj     interface{} `json:"obj"`
+	Total   *int64      `json:"total,omitempty"`
 }
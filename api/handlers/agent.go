@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"raha-xray/api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AgentHandler is registered in agent mode, the primary uses it with the token of this instance
+type AgentHandler struct {
+	BaseHandlers
+	services.AgentService
+	services.XrayService
+}
+
+func NewAgentHandler(g *gin.RouterGroup) *AgentHandler {
+	a := &AgentHandler{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *AgentHandler) initRouter(gr *gin.RouterGroup) {
+	g := gr.Group("/agent")
+	g.Use(a.checkLogin)
+
+	g.POST("/sync", a.sync)
+	g.GET("/report", a.report)
+}
+
+func (a *AgentHandler) sync(c *gin.Context) {
+	snapshot := &services.NodeSnapshot{}
+	err := c.ShouldBindJSON(snapshot)
+	if err != nil {
+		jsonMsg(c, "Error in applying snapshot:", err)
+		return
+	}
+	err = a.AgentService.Apply(snapshot)
+	if err != nil {
+		jsonMsg(c, "Error in applying snapshot:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(true)
+	jsonMsg(c, "Apply snapshot", nil)
+}
+
+func (a *AgentHandler) report(c *gin.Context) {
+	report, err := a.AgentService.Report()
+	if err != nil {
+		jsonMsg(c, "Error in getting report:", err)
+		return
+	}
+	jsonObj(c, report, nil)
+}
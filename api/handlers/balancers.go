@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"raha-xray/api/services"
+	"raha-xray/database/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BalancerHandler struct {
+	BaseHandlers
+	services.BalancerService
+	services.XrayService
+}
+
+func NewBalancerHandler(g *gin.RouterGroup) *BalancerHandler {
+	a := &BalancerHandler{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *BalancerHandler) initRouter(gr *gin.RouterGroup) {
+	g := gr.Group("/balancers")
+	g.Use(a.checkLogin)
+
+	g.GET("/", a.getAll)
+	g.GET("/get/:id", a.get)
+	g.POST("/save", a.save)
+	g.POST("/del/:id", a.del)
+}
+
+func (a *BalancerHandler) getAll(c *gin.Context) {
+	var query services.ListQuery
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		jsonMsg(c, "Error in getting all balancers:", err)
+		return
+	}
+	balancers, total, err := a.BalancerService.List(&query)
+	if err != nil {
+		jsonMsg(c, "Error in getting all balancers:", err)
+		return
+	}
+	jsonList(c, balancers, total, nil)
+}
+
+func (a *BalancerHandler) get(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in getting balancer:", err)
+		return
+	}
+	balancer, err := a.BalancerService.Get(id)
+	if err != nil {
+		jsonMsg(c, "Error in finding balancer:", err)
+		return
+	}
+	jsonObj(c, balancer, nil)
+}
+
+func (a *BalancerHandler) save(c *gin.Context) {
+	balancer := &model.Balancer{}
+	err := c.ShouldBind(balancer)
+	if err != nil {
+		jsonMsg(c, "Error in saving balancer:", err)
+		return
+	}
+	err = a.BalancerService.Save(balancer)
+	if err != nil {
+		jsonMsg(c, "Error in saving balancer:", err)
+		return
+	}
+	a.XrayService.UpdateRouting()
+}
+
+func (a *BalancerHandler) del(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting balancer:", err)
+		return
+	}
+	err = a.BalancerService.Del(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in deleting balancer:", err)
+		return
+	}
+	a.XrayService.UpdateRouting()
+}
@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"net/http"
+	"raha-xray/api/entity"
 	"raha-xray/database"
 	"raha-xray/database/model"
+	"raha-xray/logger"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,8 +21,16 @@ func (a *BaseHandlers) checkLogin(c *gin.Context) {
 	}
 	db := database.GetDB()
 	var count int64
-	result := db.Model(&model.User{}).Where("`key` = ?", apikey).Count(&count)
-	if result.Error != nil || count == 0 {
+	// key is reserved in mysql, so the column is quoted by the dialect
+	err := db.Model(&model.User{}).Where(map[string]interface{}{"key": apikey}).Count(&count).Error
+	if err != nil {
+		// the key can not be checked, which is not a failure of the client
+		logger.Warning("Unable to check API key:", err)
+		pureJsonError(c, entity.CodeFailed, http.StatusInternalServerError, "Unable to check API key")
+		c.Abort()
+		return
+	}
+	if count == 0 {
 		a.abort(c)
 		return
 	}
@@ -28,6 +39,6 @@ func (a *BaseHandlers) checkLogin(c *gin.Context) {
 }
 
 func (a *BaseHandlers) abort(c *gin.Context) {
-	pureJsonMsg(c, false, "Invalid API key")
+	pureJsonError(c, entity.CodeUnauthorized, http.StatusUnauthorized, "Invalid API key")
 	c.Abort()
 }
@@ -21,27 +21,49 @@ func NewClientHandler(g *gin.RouterGroup) *ClientHandler {
 	return a
 }
 
-func (a *ClientHandler) initRouter(g *gin.RouterGroup) {
-	g = g.Group("/clients")
+func (a *ClientHandler) initRouter(gr *gin.RouterGroup) {
+	g := gr.Group("/clients")
 	g.Use(a.checkLogin)
 
 	g.GET("/", a.getAll)
 	g.GET("/get/:id", a.get)
+	g.GET("/inactive/:days", a.inactive)
 	g.POST("/add", a.add)
 	g.POST("/update", a.update)
 	g.POST("/inbounds/:id", a.inbounds)
 	g.POST("/del/:id", a.del)
 	g.POST("/onlines", a.onlines)
 	g.GET("/traffics/:tag", a.traffics)
+	g.POST("/reset/:id", a.reset)
+	g.GET("/history/:id", a.history)
+
+	r := gr.Group("/v2/clients")
+	r.Use(useHttpStatus, a.checkLogin)
+
+	r.GET("", a.getAll)
+	r.POST("", a.create)
+	r.GET("/:id", a.get)
+	r.PUT("/:id", a.replace)
+	r.PATCH("/:id", a.patch)
+	r.DELETE("/:id", a.remove)
+	r.PUT("/:id/inbounds", a.replaceInbounds)
+	r.POST("/:id/reset", a.reset)
+	r.GET("/:id/history", a.history)
 }
 
 func (a *ClientHandler) getAll(c *gin.Context) {
-	clients, err := a.ClientService.GetAll()
+	var query services.ListQuery
+	err := c.ShouldBindQuery(&query)
 	if err != nil {
 		jsonMsg(c, "Error in getting all clients:", err)
 		return
 	}
-	jsonObj(c, clients, nil)
+	clients, total, err := a.ClientService.List(&query)
+	if err != nil {
+		jsonMsg(c, "Error in getting all clients:", err)
+		return
+	}
+	jsonList(c, clients, total, nil)
 }
 
 func (a *ClientHandler) get(c *gin.Context) {
@@ -58,6 +80,20 @@ func (a *ClientHandler) get(c *gin.Context) {
 	jsonObj(c, client, nil)
 }
 
+func (a *ClientHandler) inactive(c *gin.Context) {
+	days, err := strconv.Atoi(c.Param("days"))
+	if err != nil {
+		jsonMsg(c, "Error in getting days:", err)
+		return
+	}
+	clients, err := a.ClientService.GetInactive(days)
+	if err != nil {
+		jsonMsg(c, "Error in getting inactive clients:", err)
+		return
+	}
+	jsonObj(c, clients, nil)
+}
+
 func (a *ClientHandler) add(c *gin.Context) {
 	var clients []*model.Client
 	err := c.ShouldBind(&clients)
@@ -87,7 +123,74 @@ func (a *ClientHandler) update(c *gin.Context) {
 		jsonMsg(c, "Error in updating client:", err)
 		return
 	}
+	a.reload(needRestart)
+}
+
+func (a *ClientHandler) create(c *gin.Context) {
+	client := &model.Client{Enable: true}
+	err := c.ShouldBind(client)
+	if err != nil {
+		jsonMsg(c, "Error in create client:", err)
+		return
+	}
+	err, needRestart := a.ClientService.Create(client)
+	if err != nil {
+		jsonMsg(c, "Error in adding client:", err)
+		return
+	}
 	a.XrayService.WriteConfigFile(needRestart)
+	client, err = a.ClientService.Get(client.Id)
+	if err != nil {
+		jsonMsg(c, "Error in finding client:", err)
+		return
+	}
+	jsonCreated(c, client)
+}
+
+// replace sets all fields of a client, usage and inbounds are kept
+func (a *ClientHandler) replace(c *gin.Context) {
+	client := &model.Client{Enable: true}
+	err := c.ShouldBind(client)
+	if err != nil {
+		jsonMsg(c, "Error in fetch client data:", err)
+		return
+	}
+	a.patchClient(c, services.NewClientPatch(client))
+}
+
+func (a *ClientHandler) patch(c *gin.Context) {
+	var patch services.ClientPatch
+	err := c.ShouldBind(&patch)
+	if err != nil {
+		jsonMsg(c, "Error in fetch client data:", err)
+		return
+	}
+	a.patchClient(c, &patch)
+}
+
+func (a *ClientHandler) patchClient(c *gin.Context, patch *services.ClientPatch) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in getting client id:", err)
+		return
+	}
+	err, needRestart := a.ClientService.Patch(uint(id), patch)
+	if err != nil {
+		jsonMsg(c, "Error in updating client:", err)
+		return
+	}
+	a.reload(needRestart)
+	client, err := a.ClientService.Get(uint(id))
+	jsonObj(c, client, err)
+}
+
+// reload applies changes of clients to xray, client rules may have changed too
+func (a *ClientHandler) reload(needRestart bool) {
+	if needRestart {
+		a.XrayService.WriteConfigFile(true)
+	} else {
+		a.XrayService.UpdateRouting()
+	}
 }
 
 func (a *ClientHandler) inbounds(c *gin.Context) {
@@ -122,7 +225,54 @@ func (a *ClientHandler) del(c *gin.Context) {
 		jsonMsg(c, "Error in deleting client:", err)
 		return
 	}
+	a.reload(needRestart)
+}
+
+func (a *ClientHandler) replaceInbounds(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in getting client id:", err)
+		return
+	}
+	var clientInbounds []*model.ClientInbound
+	err = c.ShouldBind(&clientInbounds)
+	if err != nil {
+		jsonMsg(c, "Error in updating client:", err)
+		return
+	}
+	_, err = a.ClientService.Get(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in finding client:", err)
+		return
+	}
+	err, needRestart := a.ClientService.Inbounds(id, clientInbounds)
+	if err != nil {
+		jsonMsg(c, "Error in updating client:", err)
+		return
+	}
 	a.XrayService.WriteConfigFile(needRestart)
+	client, err := a.ClientService.Get(uint(id))
+	jsonObj(c, client, err)
+}
+
+func (a *ClientHandler) remove(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting client:", err)
+		return
+	}
+	_, err = a.ClientService.Get(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in deleting client:", err)
+		return
+	}
+	err, needRestart := a.ClientService.Del(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in deleting client:", err)
+		return
+	}
+	a.reload(needRestart)
+	jsonMsg(c, "Delete client", nil)
 }
 
 func (a *ClientHandler) onlines(c *gin.Context) {
@@ -137,3 +287,27 @@ func (a *ClientHandler) traffics(c *gin.Context) {
 	}
 	jsonObj(c, traffics, nil)
 }
+
+func (a *ClientHandler) reset(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in getting client id:", err)
+		return
+	}
+	err = a.ClientService.Reset(uint(id))
+	jsonMsg(c, "Reset client usage", err)
+}
+
+func (a *ClientHandler) history(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in getting client id:", err)
+		return
+	}
+	histories, err := a.ClientService.History(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in getting client history:", err)
+		return
+	}
+	jsonObj(c, histories, nil)
+}
@@ -28,15 +28,31 @@ func (a *ConfigHandler) initRouter(gr *gin.RouterGroup) {
 	g.GET("/get/:id", a.get)
 	g.POST("/save", a.save)
 	g.POST("/del/:id", a.del)
+
+	r := gr.Group("/v2/configs")
+	r.Use(useHttpStatus, a.checkLogin)
+
+	r.GET("", a.getAll)
+	r.POST("", a.create)
+	r.GET("/:id", a.get)
+	r.PUT("/:id", a.replace)
+	r.PATCH("/:id", a.patch)
+	r.DELETE("/:id", a.remove)
 }
 
 func (a *ConfigHandler) getAll(c *gin.Context) {
-	configs, err := a.ConfigService.GetAll()
+	var query services.ListQuery
+	err := c.ShouldBindQuery(&query)
 	if err != nil {
 		jsonMsg(c, "Error in getting all inboudnds:", err)
 		return
 	}
-	jsonObj(c, configs, nil)
+	configs, total, err := a.ConfigService.List(&query)
+	if err != nil {
+		jsonMsg(c, "Error in getting all inboudnds:", err)
+		return
+	}
+	jsonList(c, configs, total, nil)
 }
 
 func (a *ConfigHandler) get(c *gin.Context) {
@@ -68,6 +84,87 @@ func (a *ConfigHandler) save(c *gin.Context) {
 	a.XrayService.WriteConfigFile(needRestart)
 }
 
+func (a *ConfigHandler) create(c *gin.Context) {
+	config := &model.Config{}
+	err := c.ShouldBind(config)
+	if err != nil {
+		jsonMsg(c, "Error in saving config:", err)
+		return
+	}
+	config.Id = 0
+	err, needRestart := a.ConfigService.Save(config)
+	if err != nil {
+		jsonMsg(c, "Error in saving config:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+	jsonCreated(c, config)
+}
+
+func (a *ConfigHandler) replace(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in saving config:", err)
+		return
+	}
+	config := &model.Config{}
+	err = c.ShouldBind(config)
+	if err != nil {
+		jsonMsg(c, "Error in saving config:", err)
+		return
+	}
+	_, err = a.ConfigService.Get(id)
+	if err != nil {
+		jsonMsg(c, "Error in finding config:", err)
+		return
+	}
+	config.Id = uint(id)
+	err, needRestart := a.ConfigService.Save(config)
+	if err != nil {
+		jsonMsg(c, "Error in saving config:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+	jsonObj(c, config, nil)
+}
+
+func (a *ConfigHandler) patch(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in saving config:", err)
+		return
+	}
+	var patch services.ConfigPatch
+	err = c.ShouldBind(&patch)
+	if err != nil {
+		jsonMsg(c, "Error in saving config:", err)
+		return
+	}
+	err, needRestart := a.ConfigService.Patch(uint(id), &patch)
+	if err != nil {
+		jsonMsg(c, "Error in saving config:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+	config, err := a.ConfigService.Get(id)
+	jsonObj(c, config, err)
+}
+
+func (a *ConfigHandler) remove(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting config:", err)
+		return
+	}
+	_, err = a.ConfigService.Get(id)
+	if err != nil {
+		jsonMsg(c, "Error in deleting config:", err)
+		return
+	}
+	err = a.ConfigService.Del(uint(id))
+	jsonMsg(c, "Delete config", err)
+}
+
 func (a *ConfigHandler) del(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
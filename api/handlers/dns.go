@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"raha-xray/api/services"
+	"raha-xray/database/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DnsHandler struct {
+	BaseHandlers
+	services.DnsService
+	services.XrayService
+}
+
+func NewDnsHandler(g *gin.RouterGroup) *DnsHandler {
+	a := &DnsHandler{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *DnsHandler) initRouter(gr *gin.RouterGroup) {
+	g := gr.Group("/dns")
+	g.Use(a.checkLogin)
+
+	g.GET("/servers", a.getServers)
+	g.POST("/servers/save", a.saveServer)
+	g.POST("/servers/del/:id", a.delServer)
+	g.GET("/hosts", a.getHosts)
+	g.POST("/hosts/save", a.saveHost)
+	g.POST("/hosts/del/:id", a.delHost)
+	g.GET("/fakedns", a.getFakeDnsPools)
+	g.POST("/fakedns/save", a.saveFakeDnsPool)
+	g.POST("/fakedns/del/:id", a.delFakeDnsPool)
+}
+
+func (a *DnsHandler) getServers(c *gin.Context) {
+	servers, err := a.DnsService.GetServers()
+	if err != nil {
+		jsonMsg(c, "Error in getting dns servers:", err)
+		return
+	}
+	jsonObj(c, servers, nil)
+}
+
+func (a *DnsHandler) saveServer(c *gin.Context) {
+	server := &model.DnsServer{}
+	err := c.ShouldBind(server)
+	if err != nil {
+		jsonMsg(c, "Error in saving dns server:", err)
+		return
+	}
+	err = a.DnsService.SaveServer(server)
+	if err != nil {
+		jsonMsg(c, "Error in saving dns server:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(true)
+}
+
+func (a *DnsHandler) delServer(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting dns server:", err)
+		return
+	}
+	err = a.DnsService.DelServer(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in deleting dns server:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(true)
+}
+
+func (a *DnsHandler) getHosts(c *gin.Context) {
+	hosts, err := a.DnsService.GetHosts()
+	if err != nil {
+		jsonMsg(c, "Error in getting dns hosts:", err)
+		return
+	}
+	jsonObj(c, hosts, nil)
+}
+
+func (a *DnsHandler) saveHost(c *gin.Context) {
+	host := &model.DnsHost{}
+	err := c.ShouldBind(host)
+	if err != nil {
+		jsonMsg(c, "Error in saving dns host:", err)
+		return
+	}
+	err = a.DnsService.SaveHost(host)
+	if err != nil {
+		jsonMsg(c, "Error in saving dns host:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(true)
+}
+
+func (a *DnsHandler) delHost(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting dns host:", err)
+		return
+	}
+	err = a.DnsService.DelHost(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in deleting dns host:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(true)
+}
+
+func (a *DnsHandler) getFakeDnsPools(c *gin.Context) {
+	pools, err := a.DnsService.GetFakeDnsPools()
+	if err != nil {
+		jsonMsg(c, "Error in getting fakedns pools:", err)
+		return
+	}
+	jsonObj(c, pools, nil)
+}
+
+func (a *DnsHandler) saveFakeDnsPool(c *gin.Context) {
+	pool := &model.FakeDnsPool{}
+	err := c.ShouldBind(pool)
+	if err != nil {
+		jsonMsg(c, "Error in saving fakedns pool:", err)
+		return
+	}
+	err = a.DnsService.SaveFakeDnsPool(pool)
+	if err != nil {
+		jsonMsg(c, "Error in saving fakedns pool:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(true)
+}
+
+func (a *DnsHandler) delFakeDnsPool(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting fakedns pool:", err)
+		return
+	}
+	err = a.DnsService.DelFakeDnsPool(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in deleting fakedns pool:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(true)
+}
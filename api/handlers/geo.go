@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"raha-xray/api/services"
+	"raha-xray/database/model"
+	"raha-xray/util/common"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GeoHandler struct {
+	BaseHandlers
+	services.GeoService
+}
+
+func NewGeoHandler(g *gin.RouterGroup) *GeoHandler {
+	a := &GeoHandler{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *GeoHandler) initRouter(gr *gin.RouterGroup) {
+	g := gr.Group("/geo")
+	g.Use(a.checkLogin)
+
+	g.GET("/lists", a.getLists)
+	g.POST("/lists/save", a.saveList)
+	g.POST("/lists/del/:id", a.delList)
+	g.POST("/update", a.update)
+	g.POST("/upload/:name", a.upload)
+}
+
+func (a *GeoHandler) getLists(c *gin.Context) {
+	lists, err := a.GeoService.GetLists()
+	if err != nil {
+		jsonMsg(c, "Error in getting geo lists:", err)
+		return
+	}
+	jsonObj(c, lists, nil)
+}
+
+func (a *GeoHandler) saveList(c *gin.Context) {
+	list := &model.GeoList{}
+	err := c.ShouldBind(list)
+	if err != nil {
+		jsonMsg(c, "Error in saving geo list:", err)
+		return
+	}
+	err = a.GeoService.SaveList(list)
+	if err != nil {
+		jsonMsg(c, "Error in saving geo list:", err)
+		return
+	}
+	a.reload(c, "Save geo list")
+}
+
+func (a *GeoHandler) delList(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting geo list:", err)
+		return
+	}
+	err = a.GeoService.DelList(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in deleting geo list:", err)
+		return
+	}
+	a.reload(c, "Delete geo list")
+}
+
+func (a *GeoHandler) update(c *gin.Context) {
+	err := a.GeoService.UpdateAssets()
+	jsonMsg(c, "Update geo assets", err)
+}
+
+func (a *GeoHandler) upload(c *gin.Context) {
+	var file string
+	switch c.Param("name") {
+	case "geoip":
+		file = services.GeoIpFile
+	case "geosite":
+		file = services.GeoSiteFile
+	default:
+		jsonMsg(c, "Error in uploading geo asset:", common.NewValidationError("name should be geoip or geosite"))
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxGeoAssetSize)
+	formFile, err := c.FormFile("file")
+	if err != nil {
+		jsonMsg(c, "Error in uploading geo asset:", err)
+		return
+	}
+	reader, err := formFile.Open()
+	if err != nil {
+		jsonMsg(c, "Error in uploading geo asset:", err)
+		return
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		jsonMsg(c, "Error in uploading geo asset:", err)
+		return
+	}
+	err = a.GeoService.SaveAsset(file, data, c.PostForm("sha256"))
+	if err != nil {
+		jsonMsg(c, "Error in uploading geo asset:", err)
+		return
+	}
+	a.reload(c, "Upload geo asset")
+}
+
+// reload restarts xray, because geo files are loaded only on start
+func (a *GeoHandler) reload(c *gin.Context, msg string) {
+	var err error
+	if a.GeoService.IsXrayRunning() {
+		err = a.GeoService.RestartXray()
+	}
+	jsonMsg(c, msg, err)
+}
@@ -30,15 +30,31 @@ func (a *InboundHandler) initRouter(gr *gin.RouterGroup) {
 	g.POST("/save", a.save)
 	g.POST("/del/:id", a.del)
 	g.GET("/traffics/:tag", a.traffics)
+
+	r := gr.Group("/v2/inbounds")
+	r.Use(useHttpStatus, a.checkLogin)
+
+	r.GET("", a.getAll)
+	r.POST("", a.create)
+	r.GET("/:id", a.get)
+	r.PUT("/:id", a.replace)
+	r.PATCH("/:id", a.patch)
+	r.DELETE("/:id", a.remove)
 }
 
 func (a *InboundHandler) getAll(c *gin.Context) {
-	inbounds, err := a.InboundService.GetAll()
+	var query services.ListQuery
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		jsonMsg(c, "Error in getting all inboudnds:", err)
+		return
+	}
+	inbounds, total, err := a.InboundService.List(&query)
 	if err != nil {
 		jsonMsg(c, "Error in getting all inboudnds:", err)
 		return
 	}
-	jsonObj(c, inbounds, nil)
+	jsonList(c, inbounds, total, nil)
 }
 
 func (a *InboundHandler) get(c *gin.Context) {
@@ -70,6 +86,97 @@ func (a *InboundHandler) save(c *gin.Context) {
 	a.XrayService.WriteConfigFile(needRestart)
 }
 
+func (a *InboundHandler) create(c *gin.Context) {
+	inbound := &model.Inbound{Enable: true}
+	err := c.ShouldBind(inbound)
+	if err != nil {
+		jsonMsg(c, "Error in saving inbound:", err)
+		return
+	}
+	err, needRestart := a.InboundService.Create(inbound)
+	if err != nil {
+		jsonMsg(c, "Error in saving inbound:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+	inbound, err = a.InboundService.Get(int(inbound.Id))
+	if err != nil {
+		jsonMsg(c, "Error in finding inbound:", err)
+		return
+	}
+	jsonCreated(c, inbound)
+}
+
+func (a *InboundHandler) replace(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in saving inbound:", err)
+		return
+	}
+	inbound := &model.Inbound{}
+	err = c.ShouldBind(inbound)
+	if err != nil {
+		jsonMsg(c, "Error in saving inbound:", err)
+		return
+	}
+	_, err = a.InboundService.Get(id)
+	if err != nil {
+		jsonMsg(c, "Error in finding inbound:", err)
+		return
+	}
+	inbound.Id = uint(id)
+	err, needRestart := a.InboundService.Save(inbound)
+	if err != nil {
+		jsonMsg(c, "Error in saving inbound:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+	inbound, err = a.InboundService.Get(id)
+	jsonObj(c, inbound, err)
+}
+
+func (a *InboundHandler) patch(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in saving inbound:", err)
+		return
+	}
+	var patch services.InboundPatch
+	err = c.ShouldBind(&patch)
+	if err != nil {
+		jsonMsg(c, "Error in saving inbound:", err)
+		return
+	}
+	err, needRestart := a.InboundService.Patch(uint(id), &patch)
+	if err != nil {
+		jsonMsg(c, "Error in saving inbound:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+	inbound, err := a.InboundService.Get(id)
+	jsonObj(c, inbound, err)
+}
+
+func (a *InboundHandler) remove(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting inbound:", err)
+		return
+	}
+	_, err = a.InboundService.Get(id)
+	if err != nil {
+		jsonMsg(c, "Error in deleting inbound:", err)
+		return
+	}
+	err, needRestart := a.InboundService.Del(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in deleting inbound:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+	jsonMsg(c, "Delete inbound", nil)
+}
+
 func (a *InboundHandler) del(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
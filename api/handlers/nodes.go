@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"raha-xray/api/services"
+	"raha-xray/database/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type NodeHandler struct {
+	BaseHandlers
+	services.NodeService
+}
+
+func NewNodeHandler(g *gin.RouterGroup) *NodeHandler {
+	a := &NodeHandler{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *NodeHandler) initRouter(gr *gin.RouterGroup) {
+	g := gr.Group("/nodes")
+	g.Use(a.checkLogin)
+
+	g.GET("/", a.getAll)
+	g.GET("/get/:id", a.get)
+	g.POST("/save", a.save)
+	g.POST("/del/:id", a.del)
+	g.POST("/sync/:id", a.sync)
+	g.GET("/snapshot/:id", a.snapshot)
+}
+
+func (a *NodeHandler) getAll(c *gin.Context) {
+	var query services.ListQuery
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		jsonMsg(c, "Error in getting all nodes:", err)
+		return
+	}
+	nodes, total, err := a.NodeService.List(&query)
+	if err != nil {
+		jsonMsg(c, "Error in getting all nodes:", err)
+		return
+	}
+	jsonList(c, nodes, total, nil)
+}
+
+func (a *NodeHandler) get(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in getting node:", err)
+		return
+	}
+	node, err := a.NodeService.Get(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in finding node:", err)
+		return
+	}
+	jsonObj(c, node, nil)
+}
+
+func (a *NodeHandler) save(c *gin.Context) {
+	node := &model.Node{Enable: true}
+	err := c.ShouldBind(node)
+	if err != nil {
+		jsonMsg(c, "Error in saving node:", err)
+		return
+	}
+	err = a.NodeService.Save(node)
+	if err != nil {
+		jsonMsg(c, "Error in saving node:", err)
+		return
+	}
+	node.Token = ""
+	jsonObj(c, node, nil)
+}
+
+func (a *NodeHandler) del(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting node:", err)
+		return
+	}
+	err = a.NodeService.Del(uint(id))
+	jsonMsg(c, "Delete node", err)
+}
+
+func (a *NodeHandler) sync(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in syncing node:", err)
+		return
+	}
+	err = a.NodeService.Sync(uint(id))
+	jsonMsg(c, "Sync node", err)
+}
+
+func (a *NodeHandler) snapshot(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in getting node snapshot:", err)
+		return
+	}
+	snapshot, err := a.NodeService.GetSnapshot(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in getting node snapshot:", err)
+		return
+	}
+	jsonObj(c, snapshot, nil)
+}
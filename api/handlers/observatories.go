@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"raha-xray/api/services"
+	"raha-xray/database/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ObservatoryHandler struct {
+	BaseHandlers
+	services.ObservatoryService
+	services.XrayService
+}
+
+func NewObservatoryHandler(g *gin.RouterGroup) *ObservatoryHandler {
+	a := &ObservatoryHandler{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *ObservatoryHandler) initRouter(gr *gin.RouterGroup) {
+	g := gr.Group("/observatories")
+	g.Use(a.checkLogin)
+
+	g.GET("/", a.getAll)
+	g.GET("/get/:id", a.get)
+	g.POST("/save", a.save)
+	g.POST("/del/:id", a.del)
+	g.GET("/status", a.status)
+}
+
+func (a *ObservatoryHandler) getAll(c *gin.Context) {
+	var query services.ListQuery
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		jsonMsg(c, "Error in getting all observatories:", err)
+		return
+	}
+	observatories, total, err := a.ObservatoryService.List(&query)
+	if err != nil {
+		jsonMsg(c, "Error in getting all observatories:", err)
+		return
+	}
+	jsonList(c, observatories, total, nil)
+}
+
+func (a *ObservatoryHandler) get(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in getting observatory:", err)
+		return
+	}
+	observatory, err := a.ObservatoryService.Get(id)
+	if err != nil {
+		jsonMsg(c, "Error in finding observatory:", err)
+		return
+	}
+	jsonObj(c, observatory, nil)
+}
+
+func (a *ObservatoryHandler) save(c *gin.Context) {
+	observatory := &model.Observatory{}
+	err := c.ShouldBind(observatory)
+	if err != nil {
+		jsonMsg(c, "Error in saving observatory:", err)
+		return
+	}
+	err = a.ObservatoryService.Save(observatory)
+	if err != nil {
+		jsonMsg(c, "Error in saving observatory:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(true)
+}
+
+func (a *ObservatoryHandler) del(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting observatory:", err)
+		return
+	}
+	err = a.ObservatoryService.Del(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in deleting observatory:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(true)
+}
+
+func (a *ObservatoryHandler) status(c *gin.Context) {
+	status, err := a.ObservatoryService.GetStatus()
+	if err != nil {
+		jsonMsg(c, "Error in getting outbounds status:", err)
+		return
+	}
+	jsonObj(c, status, nil)
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"net/http"
+	"raha-xray/api/entity"
+	"raha-xray/api/openapi"
+	"raha-xray/api/services"
+	"raha-xray/config"
+	"raha-xray/database/model"
+	"raha-xray/xray"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xtls/xray-core/app/observatory"
+)
+
+type x25519Cert struct {
+	PrivateKey string `json:"privateKey"`
+	PublicKey  string `json:"publicKey"`
+}
+
+// apiOperations documents every route, routes which are missing here are logged on start
+var apiOperations = []*openapi.Operation{
+	{Method: "GET", Path: "/openapi.json", Tag: "docs", Summary: "OpenAPI specification of this API", Public: true},
+
+	{Method: "GET", Path: "/inbounds/", Tag: "inbounds", Summary: "List inbounds", Query: services.ListQuery{}, Response: []*model.Inbound{}, List: true},
+	{Method: "GET", Path: "/inbounds/get/:id", Tag: "inbounds", Summary: "Get an inbound", Response: model.Inbound{}},
+	{Method: "POST", Path: "/inbounds/save", Tag: "inbounds", Summary: "Create or update an inbound", Body: model.Inbound{}},
+	{Method: "POST", Path: "/inbounds/del/:id", Tag: "inbounds", Summary: "Delete an inbound"},
+	{Method: "GET", Path: "/inbounds/traffics/:tag", Tag: "inbounds", Summary: "Traffic records of an inbound", Response: []*model.Traffic{}},
+
+	{Method: "GET", Path: "/configs/", Tag: "configs", Summary: "List configs", Query: services.ListQuery{}, Response: []*model.Config{}, List: true},
+	{Method: "GET", Path: "/configs/get/:id", Tag: "configs", Summary: "Get a config", Response: model.Config{}},
+	{Method: "POST", Path: "/configs/save", Tag: "configs", Summary: "Create or update a config", Body: model.Config{}},
+	{Method: "POST", Path: "/configs/del/:id", Tag: "configs", Summary: "Delete a config"},
+
+	{Method: "GET", Path: "/clients/", Tag: "clients", Summary: "List clients", Query: services.ListQuery{}, Response: []*model.Client{}, List: true},
+	{Method: "GET", Path: "/clients/get/:id", Tag: "clients", Summary: "Get a client", Response: model.Client{}},
+	{Method: "GET", Path: "/clients/inactive/:days", Tag: "clients", Summary: "Clients which are not online in the last days", Response: []*model.Client{}},
+	{Method: "POST", Path: "/clients/add", Tag: "clients", Summary: "Create clients", Body: []*model.Client{}},
+	{Method: "POST", Path: "/clients/update", Tag: "clients", Summary: "Update fields of a client by id", Body: map[string]interface{}{}},
+	{Method: "POST", Path: "/clients/inbounds/:id", Tag: "clients", Summary: "Replace inbounds of a client", Body: []*model.ClientInbound{}},
+	{Method: "POST", Path: "/clients/del/:id", Tag: "clients", Summary: "Delete a client"},
+	{Method: "POST", Path: "/clients/onlines", Tag: "clients", Summary: "Names of online clients", Response: []string{}},
+	{Method: "GET", Path: "/clients/traffics/:tag", Tag: "clients", Summary: "Traffic records of a client", Response: []*model.Traffic{}},
+	{Method: "POST", Path: "/clients/reset/:id", Tag: "clients", Summary: "Reset usage of a client"},
+	{Method: "GET", Path: "/clients/history/:id", Tag: "clients", Summary: "Usage periods of a client", Response: []*model.ClientHistory{}},
+
+	{Method: "GET", Path: "/outbounds/", Tag: "outbounds", Summary: "List outbounds, with their last health check if health is set", Query: services.OutboundListQuery{}, Response: []*model.Outbound{}, List: true},
+	{Method: "GET", Path: "/outbounds/get/:id", Tag: "outbounds", Summary: "Get an outbound", Response: model.Outbound{}},
+	{Method: "POST", Path: "/outbounds/save", Tag: "outbounds", Summary: "Create or update an outbound", Body: model.Outbound{}},
+	{Method: "POST", Path: "/outbounds/del/:id", Tag: "outbounds", Summary: "Delete an outbound"},
+	{Method: "GET", Path: "/outbounds/traffics/:tag", Tag: "outbounds", Summary: "Traffic records of an outbound", Response: []*model.Traffic{}},
+	{Method: "GET", Path: "/outbounds/health/:tag/:count", Tag: "outbounds", Summary: "Last health checks of an outbound", Response: []*model.OutboundCheck{}},
+	{Method: "POST", Path: "/outbounds/test", Tag: "outbounds", Summary: "Check health of all enabled outbounds", Response: []*model.OutboundCheck{}},
+	{Method: "POST", Path: "/outbounds/test/:id", Tag: "outbounds", Summary: "Check health of an outbound", Response: []*model.OutboundCheck{}},
+	{Method: "POST", Path: "/outbounds/import", Tag: "outbounds", Summary: "Create outbounds from share links", Body: importLinksRequest{}, Response: services.ImportResult{}},
+	{Method: "GET", Path: "/outbounds/subscriptions", Tag: "outbounds", Summary: "List outbound subscriptions", Query: services.ListQuery{}, Response: []*model.Subscription{}, List: true},
+	{Method: "POST", Path: "/outbounds/subscriptions/save", Tag: "outbounds", Summary: "Save a subscription and import its outbounds", Body: model.Subscription{}, Response: services.ImportResult{}},
+	{Method: "POST", Path: "/outbounds/subscriptions/update/:id", Tag: "outbounds", Summary: "Update outbounds of a subscription", Response: services.ImportResult{}},
+	{Method: "POST", Path: "/outbounds/subscriptions/del/:id", Tag: "outbounds", Summary: "Delete a subscription and keep its outbounds"},
+
+	{Method: "GET", Path: "/rules/", Tag: "rules", Summary: "List routing rules", Query: services.ListQuery{}, Response: []*model.Rule{}, List: true},
+	{Method: "GET", Path: "/rules/get/:id", Tag: "rules", Summary: "Get a routing rule", Response: model.Rule{}},
+	{Method: "POST", Path: "/rules/save", Tag: "rules", Summary: "Create or update a routing rule, obj has field errors on failure", Body: model.Rule{}, Response: services.FieldErrors{}},
+	{Method: "POST", Path: "/rules/del/:id", Tag: "rules", Summary: "Delete a routing rule"},
+	{Method: "POST", Path: "/rules/reorder", Tag: "rules", Summary: "Set priorities of rules by the order of ids", Body: []uint{}},
+
+	{Method: "GET", Path: "/v2/clients", Tag: "v2 clients", Summary: "List clients", Query: services.ListQuery{}, Response: []*model.Client{}, List: true, HttpStatus: true},
+	{Method: "POST", Path: "/v2/clients", Tag: "v2 clients", Summary: "Create a client with its inbounds", Body: model.Client{}, Response: model.Client{}, HttpStatus: true, Created: true},
+	{Method: "GET", Path: "/v2/clients/:id", Tag: "v2 clients", Summary: "Get a client", Response: model.Client{}, HttpStatus: true},
+	{Method: "PUT", Path: "/v2/clients/:id", Tag: "v2 clients", Summary: "Replace fields of a client, usage and inbounds are kept", Body: model.Client{}, Response: model.Client{}, HttpStatus: true},
+	{Method: "PATCH", Path: "/v2/clients/:id", Tag: "v2 clients", Summary: "Change the given fields of a client", Body: services.ClientPatch{}, Response: model.Client{}, HttpStatus: true},
+	{Method: "DELETE", Path: "/v2/clients/:id", Tag: "v2 clients", Summary: "Delete a client", HttpStatus: true},
+	{Method: "PUT", Path: "/v2/clients/:id/inbounds", Tag: "v2 clients", Summary: "Replace inbounds of a client", Body: []*model.ClientInbound{}, Response: model.Client{}, HttpStatus: true},
+	{Method: "POST", Path: "/v2/clients/:id/reset", Tag: "v2 clients", Summary: "Reset usage of a client", HttpStatus: true},
+	{Method: "GET", Path: "/v2/clients/:id/history", Tag: "v2 clients", Summary: "Usage periods of a client", Response: []*model.ClientHistory{}, HttpStatus: true},
+
+	{Method: "GET", Path: "/v2/inbounds", Tag: "v2 inbounds", Summary: "List inbounds", Query: services.ListQuery{}, Response: []*model.Inbound{}, List: true, HttpStatus: true},
+	{Method: "POST", Path: "/v2/inbounds", Tag: "v2 inbounds", Summary: "Create an inbound", Body: model.Inbound{}, Response: model.Inbound{}, HttpStatus: true, Created: true},
+	{Method: "GET", Path: "/v2/inbounds/:id", Tag: "v2 inbounds", Summary: "Get an inbound", Response: model.Inbound{}, HttpStatus: true},
+	{Method: "PUT", Path: "/v2/inbounds/:id", Tag: "v2 inbounds", Summary: "Replace an inbound", Body: model.Inbound{}, Response: model.Inbound{}, HttpStatus: true},
+	{Method: "PATCH", Path: "/v2/inbounds/:id", Tag: "v2 inbounds", Summary: "Change the given fields of an inbound", Body: services.InboundPatch{}, Response: model.Inbound{}, HttpStatus: true},
+	{Method: "DELETE", Path: "/v2/inbounds/:id", Tag: "v2 inbounds", Summary: "Delete an inbound", HttpStatus: true},
+
+	{Method: "GET", Path: "/v2/configs", Tag: "v2 configs", Summary: "List configs", Query: services.ListQuery{}, Response: []*model.Config{}, List: true, HttpStatus: true},
+	{Method: "POST", Path: "/v2/configs", Tag: "v2 configs", Summary: "Create a config", Body: model.Config{}, Response: model.Config{}, HttpStatus: true, Created: true},
+	{Method: "GET", Path: "/v2/configs/:id", Tag: "v2 configs", Summary: "Get a config", Response: model.Config{}, HttpStatus: true},
+	{Method: "PUT", Path: "/v2/configs/:id", Tag: "v2 configs", Summary: "Replace a config", Body: model.Config{}, Response: model.Config{}, HttpStatus: true},
+	{Method: "PATCH", Path: "/v2/configs/:id", Tag: "v2 configs", Summary: "Change the given fields of a config", Body: services.ConfigPatch{}, Response: model.Config{}, HttpStatus: true},
+	{Method: "DELETE", Path: "/v2/configs/:id", Tag: "v2 configs", Summary: "Delete a config which is not used by inbounds", HttpStatus: true},
+
+	{Method: "GET", Path: "/v2/outbounds", Tag: "v2 outbounds", Summary: "List outbounds, with their last health check if health is set", Query: services.OutboundListQuery{}, Response: []*model.Outbound{}, List: true, HttpStatus: true},
+	{Method: "POST", Path: "/v2/outbounds", Tag: "v2 outbounds", Summary: "Create an outbound", Body: model.Outbound{}, Response: model.Outbound{}, HttpStatus: true, Created: true},
+	{Method: "GET", Path: "/v2/outbounds/:id", Tag: "v2 outbounds", Summary: "Get an outbound", Response: model.Outbound{}, HttpStatus: true},
+	{Method: "PUT", Path: "/v2/outbounds/:id", Tag: "v2 outbounds", Summary: "Replace an outbound", Body: model.Outbound{}, Response: model.Outbound{}, HttpStatus: true},
+	{Method: "PATCH", Path: "/v2/outbounds/:id", Tag: "v2 outbounds", Summary: "Change the given fields of an outbound", Body: services.OutboundPatch{}, Response: model.Outbound{}, HttpStatus: true},
+	{Method: "DELETE", Path: "/v2/outbounds/:id", Tag: "v2 outbounds", Summary: "Delete an outbound", HttpStatus: true},
+
+	{Method: "GET", Path: "/v2/rules", Tag: "v2 rules", Summary: "List routing rules", Query: services.ListQuery{}, Response: []*model.Rule{}, List: true, HttpStatus: true},
+	{Method: "POST", Path: "/v2/rules", Tag: "v2 rules", Summary: "Create a routing rule, obj has field errors on failure", Body: model.Rule{}, Response: model.Rule{}, HttpStatus: true, Created: true},
+	{Method: "GET", Path: "/v2/rules/:id", Tag: "v2 rules", Summary: "Get a routing rule", Response: model.Rule{}, HttpStatus: true},
+	{Method: "PUT", Path: "/v2/rules/:id", Tag: "v2 rules", Summary: "Replace a routing rule, obj has field errors on failure", Body: model.Rule{}, Response: model.Rule{}, HttpStatus: true},
+	{Method: "PATCH", Path: "/v2/rules/:id", Tag: "v2 rules", Summary: "Change the given fields of a routing rule, obj has field errors on failure", Body: services.RulePatch{}, Response: model.Rule{}, HttpStatus: true},
+	{Method: "DELETE", Path: "/v2/rules/:id", Tag: "v2 rules", Summary: "Delete a routing rule", HttpStatus: true},
+
+	{Method: "GET", Path: "/balancers/", Tag: "balancers", Summary: "List balancers", Query: services.ListQuery{}, Response: []*model.Balancer{}, List: true},
+	{Method: "GET", Path: "/balancers/get/:id", Tag: "balancers", Summary: "Get a balancer", Response: model.Balancer{}},
+	{Method: "POST", Path: "/balancers/save", Tag: "balancers", Summary: "Create or update a balancer", Body: model.Balancer{}},
+	{Method: "POST", Path: "/balancers/del/:id", Tag: "balancers", Summary: "Delete a balancer"},
+
+	{Method: "GET", Path: "/observatories/", Tag: "observatories", Summary: "List observatories", Query: services.ListQuery{}, Response: []*model.Observatory{}, List: true},
+	{Method: "GET", Path: "/observatories/get/:id", Tag: "observatories", Summary: "Get an observatory", Response: model.Observatory{}},
+	{Method: "POST", Path: "/observatories/save", Tag: "observatories", Summary: "Create or update an observatory", Body: model.Observatory{}},
+	{Method: "POST", Path: "/observatories/del/:id", Tag: "observatories", Summary: "Delete an observatory"},
+	{Method: "GET", Path: "/observatories/status", Tag: "observatories", Summary: "Outbound status of xray observatory", Response: []*observatory.OutboundStatus{}},
+
+	{Method: "GET", Path: "/dns/servers", Tag: "dns", Summary: "List dns servers", Response: []*model.DnsServer{}},
+	{Method: "POST", Path: "/dns/servers/save", Tag: "dns", Summary: "Create or update a dns server", Body: model.DnsServer{}},
+	{Method: "POST", Path: "/dns/servers/del/:id", Tag: "dns", Summary: "Delete a dns server"},
+	{Method: "GET", Path: "/dns/hosts", Tag: "dns", Summary: "List dns hosts", Response: []*model.DnsHost{}},
+	{Method: "POST", Path: "/dns/hosts/save", Tag: "dns", Summary: "Create or update a dns host", Body: model.DnsHost{}},
+	{Method: "POST", Path: "/dns/hosts/del/:id", Tag: "dns", Summary: "Delete a dns host"},
+	{Method: "GET", Path: "/dns/fakedns", Tag: "dns", Summary: "List fake dns pools", Response: []*model.FakeDnsPool{}},
+	{Method: "POST", Path: "/dns/fakedns/save", Tag: "dns", Summary: "Create or update a fake dns pool", Body: model.FakeDnsPool{}},
+	{Method: "POST", Path: "/dns/fakedns/del/:id", Tag: "dns", Summary: "Delete a fake dns pool"},
+
+	{Method: "GET", Path: "/reverse/bridges", Tag: "reverse", Summary: "List reverse bridges", Response: []*model.ReverseBridge{}},
+	{Method: "POST", Path: "/reverse/bridges/save", Tag: "reverse", Summary: "Create or update a reverse bridge", Body: model.ReverseBridge{}},
+	{Method: "POST", Path: "/reverse/bridges/del/:id", Tag: "reverse", Summary: "Delete a reverse bridge"},
+	{Method: "GET", Path: "/reverse/portals", Tag: "reverse", Summary: "List reverse portals", Response: []*model.ReversePortal{}},
+	{Method: "POST", Path: "/reverse/portals/save", Tag: "reverse", Summary: "Create or update a reverse portal", Body: model.ReversePortal{}},
+	{Method: "POST", Path: "/reverse/portals/del/:id", Tag: "reverse", Summary: "Delete a reverse portal"},
+
+	{Method: "POST", Path: "/server/status", Tag: "server", Summary: "Server and xray status", Response: services.Status{}},
+	{Method: "POST", Path: "/server/getXrayVersion", Tag: "server", Summary: "Available xray versions", Response: []string{}},
+	{Method: "POST", Path: "/server/setXrayVersion/:version", Tag: "server", Summary: "Install an xray version"},
+	{Method: "POST", Path: "/server/stopXrayService", Tag: "server", Summary: "Stop xray"},
+	{Method: "POST", Path: "/server/restartXrayService", Tag: "server", Summary: "Restart xray"},
+	{Method: "POST", Path: "/server/getConfigJson", Tag: "server", Summary: "Generated xray config", Response: map[string]interface{}{}},
+	{Method: "POST", Path: "/server/logs/:app/:count", Tag: "server", Summary: "Last log lines of the app or xray", Response: []string{}},
+	{Method: "POST", Path: "/server/getNewX25519Cert", Tag: "server", Summary: "Generate a x25519 key pair", Response: x25519Cert{}},
+	{Method: "GET", Path: "/server/stream", Tag: "server", Summary: "Live events of status, traffic and clients", Stream: true},
+
+	{Method: "POST", Path: "/settings/getXrayDefault", Tag: "settings", Summary: "Default xray config", Response: xray.Config{}},
+	{Method: "POST", Path: "/settings/setXrayDefault", Tag: "settings", Summary: "Save the default xray config and restart", Body: xray.Config{}},
+	{Method: "POST", Path: "/settings/getSettings", Tag: "settings", Summary: "App settings", Response: config.Setting{}},
+	{Method: "POST", Path: "/settings/setSettings", Tag: "settings", Summary: "Save app settings", Body: config.Setting{}},
+	{Method: "POST", Path: "/settings/restartApp", Tag: "settings", Summary: "Restart the app"},
+
+	{Method: "GET", Path: "/webhooks/logs/:count", Tag: "webhooks", Summary: "Last webhook deliveries", Response: []*model.WebhookLog{}},
+	{Method: "POST", Path: "/webhooks/test", Tag: "webhooks", Summary: "Send a test event to webhooks"},
+
+	{Method: "GET", Path: "/geo/lists", Tag: "geo", Summary: "List custom geo lists", Response: []*model.GeoList{}},
+	{Method: "POST", Path: "/geo/lists/save", Tag: "geo", Summary: "Create or update a custom geo list", Body: model.GeoList{}},
+	{Method: "POST", Path: "/geo/lists/del/:id", Tag: "geo", Summary: "Delete a custom geo list"},
+	{Method: "POST", Path: "/geo/update", Tag: "geo", Summary: "Download geoip and geosite files"},
+	{Method: "POST", Path: "/geo/upload/:name", Tag: "geo", Summary: "Upload geoip or geosite file", Upload: []string{"file", "sha256"}},
+
+	{Method: "GET", Path: "/nodes/", Tag: "nodes", Summary: "List nodes", Query: services.ListQuery{}, Response: []*model.Node{}, List: true},
+	{Method: "GET", Path: "/nodes/get/:id", Tag: "nodes", Summary: "Get a node", Response: model.Node{}},
+	{Method: "POST", Path: "/nodes/save", Tag: "nodes", Summary: "Create or update a node", Body: model.Node{}, Response: model.Node{}},
+	{Method: "POST", Path: "/nodes/del/:id", Tag: "nodes", Summary: "Delete a node without inbounds"},
+	{Method: "POST", Path: "/nodes/sync/:id", Tag: "nodes", Summary: "Push the state to a node"},
+	{Method: "GET", Path: "/nodes/snapshot/:id", Tag: "nodes", Summary: "State which is pushed to a node", Response: services.NodeSnapshot{}},
+
+	{Method: "POST", Path: "/agent/sync", Tag: "agent", Summary: "Apply the state of the primary, only in agent mode", Body: services.NodeSnapshot{}},
+	{Method: "GET", Path: "/agent/report", Tag: "agent", Summary: "Client counters and status for the primary, only in agent mode", Response: services.AgentReport{}},
+
+	{Method: "POST", Path: "/quota/report", Tag: "quota", Summary: "Report usage deltas to the central instance", Body: services.UsageReport{}, Response: []*services.ClientUsage{}},
+}
+
+var apiSpec map[string]interface{}
+var apiSpecOnce sync.Once
+
+type DocHandler struct {
+}
+
+func NewDocHandler(g *gin.RouterGroup) *DocHandler {
+	a := &DocHandler{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *DocHandler) initRouter(g *gin.RouterGroup) {
+	g.GET("/openapi.json", a.spec)
+}
+
+func (a *DocHandler) spec(c *gin.Context) {
+	apiSpecOnce.Do(func() {
+		apiSpec = openapi.Build(config.GetName(), config.GetVersion(), config.GetSettings().BasePath, entity.Msg{}, apiOperations)
+	})
+	c.JSON(http.StatusOK, apiSpec)
+}
+
+// UndocumentedRoutes returns routes of the engine which are missing in the OpenAPI specification
+func UndocumentedRoutes(routes gin.RoutesInfo, basePath string) []string {
+	documented := make(map[string]bool, len(apiOperations))
+	for _, operation := range apiOperations {
+		documented[operation.Method+" "+operation.Path] = true
+	}
+	var missing []string
+	for _, route := range routes {
+		path := strings.TrimPrefix(route.Path, basePath)
+		if !documented[route.Method+" "+path] {
+			missing = append(missing, route.Method+" "+route.Path)
+		}
+	}
+	return missing
+}
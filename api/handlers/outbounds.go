@@ -13,6 +13,8 @@ type OutboundHandler struct {
 	services.OutboundService
 	services.XrayService
 	services.TrafficService
+	services.HealthService
+	services.SubscriptionService
 }
 
 func NewOutboundHandler(g *gin.RouterGroup) *OutboundHandler {
@@ -30,16 +32,45 @@ func (a *OutboundHandler) initRouter(gr *gin.RouterGroup) {
 	g.POST("/save", a.save)
 	g.POST("/del/:id", a.del)
 	g.GET("/traffics/:tag", a.traffics)
+	g.GET("/health/:tag/:count", a.health)
+	g.POST("/test", a.test)
+	g.POST("/test/:id", a.test)
+	g.POST("/import", a.importLinks)
+	g.GET("/subscriptions", a.getSubscriptions)
+	g.POST("/subscriptions/save", a.saveSubscription)
+	g.POST("/subscriptions/update/:id", a.updateSubscription)
+	g.POST("/subscriptions/del/:id", a.delSubscription)
 
+	r := gr.Group("/v2/outbounds")
+	r.Use(useHttpStatus, a.checkLogin)
+
+	r.GET("", a.getAll)
+	r.POST("", a.create)
+	r.GET("/:id", a.get)
+	r.PUT("/:id", a.replace)
+	r.PATCH("/:id", a.patch)
+	r.DELETE("/:id", a.remove)
 }
 
 func (a *OutboundHandler) getAll(c *gin.Context) {
-	outbounds, err := a.OutboundService.GetAll()
+	var query services.OutboundListQuery
+	err := c.ShouldBindQuery(&query)
 	if err != nil {
 		jsonMsg(c, "Error in getting all inboudnds:", err)
 		return
 	}
-	jsonObj(c, outbounds, nil)
+	var outbounds []*model.Outbound
+	var total int64
+	if query.Health {
+		outbounds, total, err = a.HealthService.ListWithHealth(&query.ListQuery)
+	} else {
+		outbounds, total, err = a.OutboundService.List(&query.ListQuery)
+	}
+	if err != nil {
+		jsonMsg(c, "Error in getting all inboudnds:", err)
+		return
+	}
+	jsonList(c, outbounds, total, nil)
 }
 
 func (a *OutboundHandler) get(c *gin.Context) {
@@ -57,18 +88,110 @@ func (a *OutboundHandler) get(c *gin.Context) {
 }
 
 func (a *OutboundHandler) save(c *gin.Context) {
-	outbound := &model.Outbound{}
+	outbound := &model.Outbound{Enable: true}
+	err := c.ShouldBind(outbound)
+	if err != nil {
+		jsonMsg(c, "Error in saving outbound:", err)
+		return
+	}
+	err, needRestart := a.OutboundService.Save(outbound)
+	if err != nil {
+		jsonMsg(c, "Error in saving outbound:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+}
+
+func (a *OutboundHandler) create(c *gin.Context) {
+	outbound := &model.Outbound{Enable: true}
 	err := c.ShouldBind(outbound)
 	if err != nil {
 		jsonMsg(c, "Error in saving outbound:", err)
 		return
 	}
+	outbound.Id = 0
+	err, needRestart := a.OutboundService.Save(outbound)
+	if err != nil {
+		jsonMsg(c, "Error in saving outbound:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+	outbound, err = a.OutboundService.Get(int(outbound.Id))
+	if err != nil {
+		jsonMsg(c, "Error in finding outbound:", err)
+		return
+	}
+	jsonCreated(c, outbound)
+}
+
+func (a *OutboundHandler) replace(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in saving outbound:", err)
+		return
+	}
+	outbound := &model.Outbound{Enable: true}
+	err = c.ShouldBind(outbound)
+	if err != nil {
+		jsonMsg(c, "Error in saving outbound:", err)
+		return
+	}
+	_, err = a.OutboundService.Get(id)
+	if err != nil {
+		jsonMsg(c, "Error in finding outbound:", err)
+		return
+	}
+	outbound.Id = uint(id)
 	err, needRestart := a.OutboundService.Save(outbound)
 	if err != nil {
 		jsonMsg(c, "Error in saving outbound:", err)
 		return
 	}
 	a.XrayService.WriteConfigFile(needRestart)
+	outbound, err = a.OutboundService.Get(id)
+	jsonObj(c, outbound, err)
+}
+
+func (a *OutboundHandler) patch(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in saving outbound:", err)
+		return
+	}
+	var patch services.OutboundPatch
+	err = c.ShouldBind(&patch)
+	if err != nil {
+		jsonMsg(c, "Error in saving outbound:", err)
+		return
+	}
+	err, needRestart := a.OutboundService.Patch(uint(id), &patch)
+	if err != nil {
+		jsonMsg(c, "Error in saving outbound:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+	outbound, err := a.OutboundService.Get(id)
+	jsonObj(c, outbound, err)
+}
+
+func (a *OutboundHandler) remove(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting outbound:", err)
+		return
+	}
+	_, err = a.OutboundService.Get(id)
+	if err != nil {
+		jsonMsg(c, "Error in deleting outbound:", err)
+		return
+	}
+	err, needRestart := a.OutboundService.Del(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in deleting outbound:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+	jsonMsg(c, "Delete outbound", nil)
 }
 
 func (a *OutboundHandler) del(c *gin.Context) {
@@ -93,3 +216,112 @@ func (a *OutboundHandler) traffics(c *gin.Context) {
 	}
 	jsonObj(c, traffics, nil)
 }
+
+func (a *OutboundHandler) health(c *gin.Context) {
+	count, err := strconv.Atoi(c.Param("count"))
+	if err != nil {
+		jsonMsg(c, "Error in getting count:", err)
+		return
+	}
+	checks, err := a.HealthService.GetHistory(c.Param("tag"), count)
+	if err != nil {
+		jsonMsg(c, "Error in getting outbound health:", err)
+		return
+	}
+	jsonObj(c, checks, nil)
+}
+
+func (a *OutboundHandler) test(c *gin.Context) {
+	var ids []uint
+	if c.Param("id") != "" {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			jsonMsg(c, "Error in testing outbound:", err)
+			return
+		}
+		ids = append(ids, uint(id))
+	}
+	checks, err := a.HealthService.Check(ids...)
+	if err != nil {
+		jsonMsg(c, "Error in testing outbounds:", err)
+		return
+	}
+	jsonObj(c, checks, nil)
+}
+
+type importLinksRequest struct {
+	Links  string `json:"links" form:"links"`
+	Prefix string `json:"prefix" form:"prefix"`
+}
+
+func (a *OutboundHandler) importLinks(c *gin.Context) {
+	var data importLinksRequest
+	err := c.ShouldBind(&data)
+	if err != nil {
+		jsonMsg(c, "Error in importing outbounds:", err)
+		return
+	}
+	result, err, needRestart := a.SubscriptionService.Import(data.Links, data.Prefix, 0)
+	if err != nil {
+		jsonMsg(c, "Error in importing outbounds:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+	jsonObj(c, result, nil)
+}
+
+func (a *OutboundHandler) getSubscriptions(c *gin.Context) {
+	var query services.ListQuery
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		jsonMsg(c, "Error in getting subscriptions:", err)
+		return
+	}
+	subscriptions, total, err := a.SubscriptionService.List(&query)
+	if err != nil {
+		jsonMsg(c, "Error in getting subscriptions:", err)
+		return
+	}
+	jsonList(c, subscriptions, total, nil)
+}
+
+func (a *OutboundHandler) saveSubscription(c *gin.Context) {
+	subscription := &model.Subscription{}
+	err := c.ShouldBind(subscription)
+	if err != nil {
+		jsonMsg(c, "Error in saving subscription:", err)
+		return
+	}
+	result, err, needRestart := a.SubscriptionService.Save(subscription)
+	if err != nil {
+		jsonMsg(c, "Error in saving subscription:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+	jsonObj(c, result, nil)
+}
+
+func (a *OutboundHandler) updateSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in updating subscription:", err)
+		return
+	}
+	result, err, needRestart := a.SubscriptionService.Update(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in updating subscription:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(needRestart)
+	jsonObj(c, result, nil)
+}
+
+func (a *OutboundHandler) delSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting subscription:", err)
+		return
+	}
+	err = a.SubscriptionService.Del(uint(id))
+	jsonMsg(c, "Delete subscription", err)
+}
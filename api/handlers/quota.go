@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"raha-xray/api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QuotaHandler lets other servers share client quota with this instance as the central one
+type QuotaHandler struct {
+	BaseHandlers
+	services.TrafficService
+}
+
+func NewQuotaHandler(g *gin.RouterGroup) *QuotaHandler {
+	a := &QuotaHandler{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *QuotaHandler) initRouter(gr *gin.RouterGroup) {
+	g := gr.Group("/quota")
+	g.Use(a.checkLogin)
+
+	g.POST("/report", a.report)
+}
+
+func (a *QuotaHandler) report(c *gin.Context) {
+	var report services.UsageReport
+	err := c.ShouldBindJSON(&report)
+	if err != nil {
+		jsonMsg(c, "Error in reporting usage:", err)
+		return
+	}
+	usages, err := a.TrafficService.Account(&report)
+	if err != nil {
+		jsonMsg(c, "Error in reporting usage:", err)
+		return
+	}
+	jsonObj(c, usages, nil)
+}
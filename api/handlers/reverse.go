@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"raha-xray/api/services"
+	"raha-xray/database/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReverseHandler struct {
+	BaseHandlers
+	services.ReverseService
+	services.XrayService
+}
+
+func NewReverseHandler(g *gin.RouterGroup) *ReverseHandler {
+	a := &ReverseHandler{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *ReverseHandler) initRouter(gr *gin.RouterGroup) {
+	g := gr.Group("/reverse")
+	g.Use(a.checkLogin)
+
+	g.GET("/bridges", a.getBridges)
+	g.POST("/bridges/save", a.saveBridge)
+	g.POST("/bridges/del/:id", a.delBridge)
+	g.GET("/portals", a.getPortals)
+	g.POST("/portals/save", a.savePortal)
+	g.POST("/portals/del/:id", a.delPortal)
+}
+
+func (a *ReverseHandler) getBridges(c *gin.Context) {
+	bridges, err := a.ReverseService.GetBridges()
+	if err != nil {
+		jsonMsg(c, "Error in getting bridges:", err)
+		return
+	}
+	jsonObj(c, bridges, nil)
+}
+
+func (a *ReverseHandler) saveBridge(c *gin.Context) {
+	bridge := &model.ReverseBridge{}
+	err := c.ShouldBind(bridge)
+	if err != nil {
+		jsonMsg(c, "Error in saving bridge:", err)
+		return
+	}
+	err = a.ReverseService.SaveBridge(bridge)
+	if err != nil {
+		jsonMsg(c, "Error in saving bridge:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(true)
+}
+
+func (a *ReverseHandler) delBridge(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting bridge:", err)
+		return
+	}
+	err = a.ReverseService.DelBridge(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in deleting bridge:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(true)
+}
+
+func (a *ReverseHandler) getPortals(c *gin.Context) {
+	portals, err := a.ReverseService.GetPortals()
+	if err != nil {
+		jsonMsg(c, "Error in getting portals:", err)
+		return
+	}
+	jsonObj(c, portals, nil)
+}
+
+func (a *ReverseHandler) savePortal(c *gin.Context) {
+	portal := &model.ReversePortal{}
+	err := c.ShouldBind(portal)
+	if err != nil {
+		jsonMsg(c, "Error in saving portal:", err)
+		return
+	}
+	err = a.ReverseService.SavePortal(portal)
+	if err != nil {
+		jsonMsg(c, "Error in saving portal:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(true)
+}
+
+func (a *ReverseHandler) delPortal(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting portal:", err)
+		return
+	}
+	err = a.ReverseService.DelPortal(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in deleting portal:", err)
+		return
+	}
+	a.XrayService.WriteConfigFile(true)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"raha-xray/api/services"
 	"raha-xray/database/model"
 	"strconv"
@@ -28,15 +29,32 @@ func (a *RuleHandler) initRouter(gr *gin.RouterGroup) {
 	g.GET("/get/:id", a.get)
 	g.POST("/save", a.save)
 	g.POST("/del/:id", a.del)
+	g.POST("/reorder", a.reorder)
+
+	r := gr.Group("/v2/rules")
+	r.Use(useHttpStatus, a.checkLogin)
+
+	r.GET("", a.getAll)
+	r.POST("", a.create)
+	r.GET("/:id", a.get)
+	r.PUT("/:id", a.replace)
+	r.PATCH("/:id", a.patch)
+	r.DELETE("/:id", a.remove)
 }
 
 func (a *RuleHandler) getAll(c *gin.Context) {
-	rules, err := a.RuleService.GetAll()
+	var query services.ListQuery
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		jsonMsg(c, "Error in getting all inboudnds:", err)
+		return
+	}
+	rules, total, err := a.RuleService.List(&query)
 	if err != nil {
 		jsonMsg(c, "Error in getting all inboudnds:", err)
 		return
 	}
-	jsonObj(c, rules, nil)
+	jsonList(c, rules, total, nil)
 }
 
 func (a *RuleHandler) get(c *gin.Context) {
@@ -54,18 +72,120 @@ func (a *RuleHandler) get(c *gin.Context) {
 }
 
 func (a *RuleHandler) save(c *gin.Context) {
-	rule := &model.Rule{}
+	rule := &model.Rule{Enable: true}
 	err := c.ShouldBind(rule)
 	if err != nil {
 		jsonMsg(c, "Error in saving rule:", err)
 		return
 	}
 	err = a.RuleService.Save(rule)
+	if err != nil {
+		a.saveFailed(c, err)
+		return
+	}
+	a.XrayService.UpdateRouting()
+}
+
+func (a *RuleHandler) create(c *gin.Context) {
+	rule := &model.Rule{Enable: true}
+	err := c.ShouldBind(rule)
+	if err != nil {
+		jsonMsg(c, "Error in saving rule:", err)
+		return
+	}
+	rule.Id = 0
+	err = a.RuleService.Save(rule)
+	if err != nil {
+		a.saveFailed(c, err)
+		return
+	}
+	a.XrayService.UpdateRouting()
+	rule, err = a.RuleService.Get(int(rule.Id))
+	if err != nil {
+		jsonMsg(c, "Error in finding rule:", err)
+		return
+	}
+	jsonCreated(c, rule)
+}
+
+func (a *RuleHandler) replace(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in saving rule:", err)
+		return
+	}
+	rule := &model.Rule{Enable: true}
+	err = c.ShouldBind(rule)
+	if err != nil {
+		jsonMsg(c, "Error in saving rule:", err)
+		return
+	}
+	_, err = a.RuleService.Get(id)
+	if err != nil {
+		jsonMsg(c, "Error in finding rule:", err)
+		return
+	}
+	rule.Id = uint(id)
+	err = a.RuleService.Save(rule)
+	if err != nil {
+		a.saveFailed(c, err)
+		return
+	}
+	a.XrayService.UpdateRouting()
+	rule, err = a.RuleService.Get(id)
+	jsonObj(c, rule, err)
+}
+
+func (a *RuleHandler) patch(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		jsonMsg(c, "Error in saving rule:", err)
 		return
 	}
-	a.XrayService.WriteConfigFile(true)
+	var patch services.RulePatch
+	err = c.ShouldBind(&patch)
+	if err != nil {
+		jsonMsg(c, "Error in saving rule:", err)
+		return
+	}
+	err = a.RuleService.Patch(uint(id), &patch)
+	if err != nil {
+		a.saveFailed(c, err)
+		return
+	}
+	a.XrayService.UpdateRouting()
+	rule, err := a.RuleService.Get(id)
+	jsonObj(c, rule, err)
+}
+
+// saveFailed sends field errors of an invalid rule in obj
+func (a *RuleHandler) saveFailed(c *gin.Context, err error) {
+	var fieldErrors services.FieldErrors
+	if errors.As(err, &fieldErrors) {
+		jsonMsgObj(c, "Error in saving rule:", fieldErrors, err)
+		return
+	}
+	jsonMsg(c, "Error in saving rule:", err)
+}
+
+func (a *RuleHandler) remove(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "Error in deleting rule:", err)
+		return
+	}
+	_, err = a.RuleService.Get(id)
+	if err != nil {
+		jsonMsg(c, "Error in deleting rule:", err)
+		return
+	}
+	err = a.RuleService.Del(uint(id))
+	if err != nil {
+		jsonMsg(c, "Error in deleting rule:", err)
+		return
+	}
+	a.XrayService.UpdateRouting()
+	jsonMsg(c, "Delete rule", nil)
 }
 
 func (a *RuleHandler) del(c *gin.Context) {
@@ -79,5 +199,20 @@ func (a *RuleHandler) del(c *gin.Context) {
 		jsonMsg(c, "Error in deleting rule:", err)
 		return
 	}
-	a.XrayService.WriteConfigFile(true)
+	a.XrayService.UpdateRouting()
+}
+
+func (a *RuleHandler) reorder(c *gin.Context) {
+	var ids []uint
+	err := c.ShouldBind(&ids)
+	if err != nil {
+		jsonMsg(c, "Error in reordering rules:", err)
+		return
+	}
+	err = a.RuleService.Reorder(ids)
+	if err != nil {
+		jsonMsg(c, "Error in reordering rules:", err)
+		return
+	}
+	a.XrayService.UpdateRouting()
 }
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"io"
 	"raha-xray/api/services"
 	"time"
 
@@ -12,6 +13,7 @@ type ServerHandler struct {
 
 	services.ServerService
 	services.XrayService
+	services.StreamService
 
 	lastStatus        *services.Status
 	lastGetStatusTime time.Time
@@ -40,6 +42,7 @@ func (a *ServerHandler) initRouter(g *gin.RouterGroup) {
 	g.POST("/getConfigJson", a.getConfigJson)
 	g.POST("/logs/:app/:count", a.getLogs)
 	g.POST("/getNewX25519Cert", a.getNewX25519Cert)
+	g.GET("/stream", a.stream)
 }
 
 func (a *ServerHandler) status(c *gin.Context) {
@@ -115,3 +118,20 @@ func (a *ServerHandler) getNewX25519Cert(c *gin.Context) {
 	}
 	jsonObj(c, cert, nil)
 }
+
+func (a *ServerHandler) stream(c *gin.Context) {
+	ch := a.StreamService.Subscribe()
+	defer a.StreamService.Unsubscribe(ch)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event := <-ch:
+			c.SSEvent(event.Type, event.Data)
+			return true
+		}
+	})
+}
@@ -1,11 +1,22 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"raha-xray/api/entity"
+	"raha-xray/api/services"
+	"raha-xray/database"
 	"raha-xray/logger"
+	"raha-xray/util/common"
+	"raha-xray/xray"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	"gorm.io/gorm"
 )
 
 // func getRemoteIp(c *gin.Context) string {
@@ -28,10 +39,24 @@ func jsonObj(c *gin.Context, obj interface{}, err error) {
 	jsonMsgObj(c, "", obj, err)
 }
 
+// jsonList sends a page of a list with the count of all matching items
+func jsonList(c *gin.Context, obj interface{}, total int64, err error) {
+	if err != nil {
+		jsonMsgObj(c, "", nil, err)
+		return
+	}
+	c.JSON(http.StatusOK, entity.Msg{
+		Success: true,
+		Obj:     obj,
+		Total:   &total,
+	})
+}
+
 func jsonMsgObj(c *gin.Context, msg string, obj interface{}, err error) {
 	m := entity.Msg{
 		Obj: obj,
 	}
+	status := http.StatusOK
 	if err == nil {
 		m.Success = true
 		if msg != "" {
@@ -40,9 +65,10 @@ func jsonMsgObj(c *gin.Context, msg string, obj interface{}, err error) {
 	} else {
 		m.Success = false
 		m.Msg = msg + " failed: " + err.Error()
+		m.Code, status = errorCode(err)
 		logger.Warning("failure: ", err)
 	}
-	c.JSON(http.StatusOK, m)
+	c.JSON(httpStatus(c, status), m)
 }
 
 func pureJsonMsg(c *gin.Context, success bool, msg string) {
@@ -58,3 +84,62 @@ func pureJsonMsg(c *gin.Context, success bool, msg string) {
 		})
 	}
 }
+
+// pureJsonError sends a failure with its code
+func pureJsonError(c *gin.Context, code string, status int, msg string) {
+	c.JSON(httpStatus(c, status), entity.Msg{
+		Success: false,
+		Msg:     msg,
+		Code:    code,
+	})
+}
+
+// jsonCreated sends a created resource
+func jsonCreated(c *gin.Context, obj interface{}) {
+	c.JSON(http.StatusCreated, entity.Msg{
+		Success: true,
+		Obj:     obj,
+	})
+}
+
+// httpStatusKey is set by route groups which always send HTTP status codes of failures
+const httpStatusKey = "httpStatus"
+
+// useHttpStatus is the middleware of route groups which always send HTTP status codes of failures
+func useHttpStatus(c *gin.Context) {
+	c.Set(httpStatusKey, true)
+}
+
+// httpStatus returns the status of a failure if the client opted in by the X-Http-Status header,
+// otherwise failures are sent with 200 like before
+func httpStatus(c *gin.Context, status int) int {
+	if c.GetBool(httpStatusKey) || c.GetHeader("X-Http-Status") == "true" {
+		return status
+	}
+	return http.StatusOK
+}
+
+// errorCode classifies an error by a stable code and its HTTP status
+func errorCode(err error) (string, int) {
+	var fieldErrors services.FieldErrors
+	var validationError *common.ValidationError
+	var numError *strconv.NumError
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return entity.CodeNotFound, http.StatusNotFound
+	case database.IsDuplicated(err):
+		return entity.CodeConflict, http.StatusConflict
+	case errors.As(err, &fieldErrors), errors.As(err, &validationError), errors.As(err, &numError),
+		errors.As(err, &syntaxError), errors.As(err, &typeError), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return entity.CodeValidationFailed, http.StatusBadRequest
+	case errors.As(err, &maxBytesError):
+		return entity.CodeValidationFailed, http.StatusRequestEntityTooLarge
+	case errors.Is(err, xray.ErrNotRunning), grpcStatus.Code(err) == codes.Unavailable:
+		return entity.CodeXrayUnavailable, http.StatusServiceUnavailable
+	}
+	// unclassified errors are failures of the server, like database or xray errors
+	return entity.CodeFailed, http.StatusInternalServerError
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"raha-xray/api/entity"
+	"raha-xray/api/services"
+	"raha-xray/config"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookHandler struct {
+	BaseHandlers
+	services.NotificationService
+}
+
+func NewWebhookHandler(g *gin.RouterGroup) *WebhookHandler {
+	a := &WebhookHandler{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *WebhookHandler) initRouter(gr *gin.RouterGroup) {
+	g := gr.Group("/webhooks")
+	g.Use(a.checkLogin)
+
+	g.GET("/logs/:count", a.logs)
+	g.POST("/test", a.test)
+}
+
+func (a *WebhookHandler) logs(c *gin.Context) {
+	count, err := strconv.Atoi(c.Param("count"))
+	if err != nil {
+		jsonMsg(c, "Error in getting count:", err)
+		return
+	}
+	logs, err := a.NotificationService.GetLogs(count)
+	if err != nil {
+		jsonMsg(c, "Error in getting webhook logs:", err)
+		return
+	}
+	jsonObj(c, logs, nil)
+}
+
+func (a *WebhookHandler) test(c *gin.Context) {
+	if len(config.GetSettings().Webhooks) == 0 {
+		pureJsonError(c, entity.CodeValidationFailed, http.StatusBadRequest, "No webhook is configured")
+		return
+	}
+	a.NotificationService.Send(services.NewEvent(services.EventTest, nil, 0))
+	jsonMsg(c, "Test event queued", nil)
+}
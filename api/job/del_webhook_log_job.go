@@ -0,0 +1,19 @@
+package job
+
+import (
+	"raha-xray/api/services"
+	"raha-xray/logger"
+)
+
+type DelWebhookLogJob struct {
+	services.NotificationService
+}
+
+func NewDelWebhookLogJob() *DelWebhookLogJob {
+	return new(DelWebhookLogJob)
+}
+
+func (j *DelWebhookLogJob) Run() {
+	result := j.NotificationService.DelOldLogs(7)
+	logger.Debug("Deleted old webhook logs:", result)
+}
@@ -0,0 +1,21 @@
+package job
+
+import (
+	"raha-xray/api/services"
+	"raha-xray/logger"
+)
+
+type GeoUpdateJob struct {
+	services.GeoService
+}
+
+func NewGeoUpdateJob() *GeoUpdateJob {
+	return new(GeoUpdateJob)
+}
+
+func (j *GeoUpdateJob) Run() {
+	err := j.GeoService.UpdateAssets()
+	if err != nil {
+		logger.Warning("update geo assets failed:", err)
+	}
+}
@@ -0,0 +1,27 @@
+package job
+
+import (
+	"raha-xray/api/services"
+	"raha-xray/config"
+	"raha-xray/logger"
+)
+
+type HealthCheckJob struct {
+	services.HealthService
+}
+
+func NewHealthCheckJob() *HealthCheckJob {
+	return new(HealthCheckJob)
+}
+
+func (j *HealthCheckJob) Run() {
+	_, err := j.HealthService.Check()
+	if err != nil {
+		logger.Warning("outbound health check failed:", err)
+	}
+	days := config.GetSettings().HealthCheckDays
+	if days > 0 {
+		result := j.HealthService.DelOldChecks(days)
+		logger.Debug("Deleted old outbound checks:", result)
+	}
+}
@@ -0,0 +1,19 @@
+package job
+
+import (
+	"raha-xray/api/services"
+)
+
+type SubscriptionUpdateJob struct {
+	services.SubscriptionService
+	services.XrayService
+}
+
+func NewSubscriptionUpdateJob() *SubscriptionUpdateJob {
+	return new(SubscriptionUpdateJob)
+}
+
+func (j *SubscriptionUpdateJob) Run() {
+	needRestart := j.SubscriptionService.UpdateAll()
+	j.XrayService.WriteConfigFile(needRestart)
+}
@@ -2,12 +2,15 @@ package job
 
 import (
 	"raha-xray/api/services"
+	"raha-xray/database/model"
 	"raha-xray/logger"
 )
 
 type XrayTrafficJob struct {
 	services.XrayService
 	services.TrafficService
+	services.NotificationService
+	services.NodeService
 }
 
 func NewXrayTrafficJob() *XrayTrafficJob {
@@ -15,16 +18,44 @@ func NewXrayTrafficJob() *XrayTrafficJob {
 }
 
 func (j *XrayTrafficJob) Run() {
+	j.addTraffics()
+	// Expiry warnings do not depend on traffic, so they are checked even if no traffic is read
+	err := j.NotificationService.CheckAlerts()
+	if err != nil {
+		logger.Warning("check alerts failed:", err)
+	}
+	// Push changes like disabled or reset clients to nodes
+	j.NodeService.SyncAll()
+}
+
+func (j *XrayTrafficJob) addTraffics() {
 	traffics, err := j.XrayService.GetXrayTraffic()
 	if err != nil {
 		logger.Warning("get xray traffic failed:", err)
+	}
+	// Client traffics of nodes are accounted here, so quotas are shared between them
+	nodeTraffics, nodeCounters := j.NodeService.PullTraffics()
+	if err != nil && len(nodeTraffics) == 0 && len(nodeCounters) == 0 {
 		return
 	}
-	err, needRestart := j.TrafficService.AddTraffic(traffics)
+	traffics = append(traffics, nodeTraffics...)
+	var clientTraffics []*model.Traffic
+	for _, traffic := range traffics {
+		if traffic.Resource == "user" {
+			clientTraffics = append(clientTraffics, traffic)
+		}
+	}
+	if len(clientTraffics) > 0 {
+		services.Publish(services.StreamTraffic, clientTraffics)
+	}
+
+	err, needRestart := j.TrafficService.AddTraffic(traffics, nodeCounters)
 	if err != nil {
 		logger.Warning("add traffic failed:", err)
 	}
 	if needRestart {
+		// Rebuild the config first, as clients may be disabled or enabled without api
+		j.XrayService.WriteConfigFile(false)
 		j.XrayService.RestartXray()
 	}
 }
@@ -0,0 +1,51 @@
+package job
+
+import (
+	"raha-xray/api/services"
+	"raha-xray/config"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"testing"
+	"time"
+)
+
+func initTestDB(t *testing.T) {
+	t.Helper()
+	settings := *config.GetDefaultSettings()
+	settings.DbAddr = t.TempDir()
+	config.SetSettings(&settings)
+	err := database.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, err := database.GetDB().DB()
+		if err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+func TestXrayTrafficJobAlerts(t *testing.T) {
+	initTestDB(t)
+	events := make(chan *services.Event, 10)
+	services.SetEventListener("test", func(event *services.Event) { events <- event })
+	t.Cleanup(func() { services.SetEventListener("test", nil) })
+
+	expiry := uint64(time.Now().Add(24 * time.Hour).UnixMilli())
+	err := database.GetDB().Create(&model.Client{Name: "expiry", Enable: true, Expiry: expiry}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// xray is not running, expiry warnings are still checked
+	NewXrayTrafficJob().Run()
+	select {
+	case event := <-events:
+		if event.Type != services.EventExpiry || event.Client.Name != "expiry" {
+			t.Errorf("event %s of %s", event.Type, event.Client.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no expiry warning")
+	}
+}
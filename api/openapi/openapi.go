@@ -0,0 +1,287 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Operation documents a route, types are described by reflection of their json tags
+type Operation struct {
+	Method  string
+	Path    string // gin path like /clients/get/:id
+	Tag     string
+	Summary string
+
+	Query    interface{} // struct of query parameters with form tags
+	Body     interface{} // json request body
+	Upload   []string    // multipart form fields, file is a binary field
+	Response interface{} // obj of the response envelope
+
+	List       bool // the envelope has the total count
+	Stream     bool // server sent events instead of the envelope
+	Public     bool // no X-Token is needed
+	HttpStatus bool // failures always have their HTTP status
+	Created    bool // success is sent as 201 created
+}
+
+// Spec builds OpenAPI 3 documents
+type Spec struct {
+	schemas map[string]interface{}
+	names   map[reflect.Type]string
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// Build returns the OpenAPI document of operations, responses are wrapped by the envelope type
+func Build(title string, version string, basePath string, envelope interface{}, operations []*Operation) map[string]interface{} {
+	s := &Spec{
+		schemas: make(map[string]interface{}),
+		names:   make(map[reflect.Type]string),
+	}
+	envelopeRef := s.schema(reflect.TypeOf(envelope))
+
+	paths := make(map[string]interface{})
+	for _, operation := range operations {
+		path := PathOf(operation.Path)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(operation.Method)] = s.operation(operation, envelopeRef)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": version,
+		},
+		"servers": []interface{}{map[string]interface{}{"url": basePath}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": s.schemas,
+			"securitySchemes": map[string]interface{}{
+				"token": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-Token"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"token": []string{}}},
+	}
+}
+
+// PathOf converts a gin path to an OpenAPI path
+func PathOf(ginPath string) string {
+	parts := strings.Split(ginPath, "/")
+	for index, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			parts[index] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func (s *Spec) operation(operation *Operation, envelopeRef map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{
+		"tags":        []string{operation.Tag},
+		"summary":     operation.Summary,
+		"operationId": operationId(operation),
+	}
+	if operation.Public {
+		result["security"] = []interface{}{}
+	}
+
+	var parameters []interface{}
+	for _, part := range strings.Split(operation.Path, "/") {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			name := part[1:]
+			schema := map[string]interface{}{"type": "string"}
+			if name == "id" || name == "count" || name == "days" {
+				schema = map[string]interface{}{"type": "integer"}
+			}
+			parameters = append(parameters, map[string]interface{}{
+				"name": name, "in": "path", "required": true, "schema": schema,
+			})
+		}
+	}
+	if operation.Query != nil {
+		queryType := reflect.Indirect(reflect.ValueOf(operation.Query)).Type()
+		for _, field := range reflect.VisibleFields(queryType) {
+			name := strings.Split(field.Tag.Get("form"), ",")[0]
+			if name == "" || name == "-" || !field.IsExported() {
+				continue
+			}
+			parameters = append(parameters, map[string]interface{}{
+				"name": name, "in": "query", "schema": s.schema(field.Type),
+			})
+		}
+	}
+	if len(parameters) > 0 {
+		result["parameters"] = parameters
+	}
+
+	if operation.Body != nil {
+		result["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": s.schema(reflect.TypeOf(operation.Body))},
+			},
+		}
+	} else if len(operation.Upload) > 0 {
+		properties := make(map[string]interface{})
+		for _, field := range operation.Upload {
+			if field == "file" {
+				properties[field] = map[string]interface{}{"type": "string", "format": "binary"}
+			} else {
+				properties[field] = map[string]interface{}{"type": "string"}
+			}
+		}
+		result["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"multipart/form-data": map[string]interface{}{
+					"schema": map[string]interface{}{"type": "object", "properties": properties},
+				},
+			},
+		}
+	}
+
+	var content map[string]interface{}
+	if operation.Stream {
+		content = map[string]interface{}{
+			"text/event-stream": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+		}
+	} else {
+		schema := envelopeRef
+		properties := make(map[string]interface{})
+		if operation.Response != nil {
+			properties["obj"] = s.schema(reflect.TypeOf(operation.Response))
+		}
+		if operation.List {
+			properties["total"] = map[string]interface{}{"type": "integer"}
+		}
+		if len(properties) > 0 {
+			schema = map[string]interface{}{
+				"allOf": []interface{}{envelopeRef, map[string]interface{}{"type": "object", "properties": properties}},
+			}
+		}
+		content = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		}
+	}
+	failure := map[string]interface{}{
+		"description": "failures have their HTTP status if the X-Http-Status header is true",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": envelopeRef},
+		},
+	}
+	success := map[string]interface{}{"description": "success is false when the operation fails", "content": content}
+	if operation.HttpStatus {
+		failure["description"] = "failure with its code"
+		success["description"] = "success"
+	}
+	status := "200"
+	if operation.Created {
+		status = "201"
+	}
+	result["responses"] = map[string]interface{}{
+		status:    success,
+		"default": failure,
+	}
+	return result
+}
+
+func operationId(operation *Operation) string {
+	id := strings.ToLower(operation.Method)
+	for _, part := range strings.Split(operation.Path, "/") {
+		part = strings.TrimLeft(part, ":*")
+		if part != "" {
+			id += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return id
+}
+
+// schema returns the schema of a type, named structs are added to components and referenced
+func (s *Spec) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	// custom encodings like raw json messages can be any value
+	if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return s.ref(t)
+	}
+	return map[string]interface{}{}
+}
+
+func (s *Spec) ref(t reflect.Type) map[string]interface{} {
+	name, ok := s.names[t]
+	if !ok {
+		name = strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, taken := s.schemas[name]; taken {
+			pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+			name = pkg + "." + name
+		}
+		s.names[t] = name
+		// placeholder for recursive types
+		s.schemas[name] = map[string]interface{}{}
+		s.schemas[name] = s.object(t)
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func (s *Spec) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || (field.Anonymous && field.Tag.Get("json") == "") {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		// fields of embedded structs are promoted, unless a field of the outer struct has the same name
+		if _, ok := properties[name]; ok && len(field.Index) > 1 {
+			continue
+		}
+		properties[name] = s.schema(field.Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"raha-xray/database"
+	"raha-xray/database/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AgentService runs on nodes which are managed by a primary raha-xray
+type AgentService struct {
+	ServerService
+}
+
+// Apply replaces inbounds, clients, outbounds and routing by the snapshot of the primary.
+// Traffic counters and activity of clients are local, so they are kept by client name.
+func (s *AgentService) Apply(snapshot *NodeSnapshot) error {
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		var oldClients []*model.Client
+		err := tx.Model(model.Client{}).Find(&oldClients).Error
+		if err != nil {
+			return err
+		}
+		activities := make(map[string]*model.Client, len(oldClients))
+		for _, client := range oldClients {
+			activities[client.Name] = client
+		}
+
+		for _, table := range []interface{}{
+			&model.ClientInbound{}, &model.Client{}, &model.Inbound{}, &model.Config{},
+			&model.RuleClient{}, &model.Rule{}, &model.Balancer{}, &model.Outbound{},
+		} {
+			err = tx.Where("1 = 1").Delete(table).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		var clientInbounds []model.ClientInbound
+		var ruleClients []model.RuleClient
+		var disabledClients, disabledInbounds []uint
+		for _, client := range snapshot.Clients {
+			if activity, ok := activities[client.Name]; ok {
+				client.Up = activity.Up
+				client.Down = activity.Down
+				client.FirstUsed = activity.FirstUsed
+				client.LastOnline = activity.LastOnline
+				client.OnlineTime = activity.OnlineTime
+			}
+			clientInbounds = append(clientInbounds, client.ClientInbounds...)
+			if !client.Enable {
+				disabledClients = append(disabledClients, client.Id)
+			}
+		}
+		for _, inbound := range snapshot.Inbounds {
+			if !inbound.Enable {
+				disabledInbounds = append(disabledInbounds, inbound.Id)
+			}
+		}
+		for _, rule := range snapshot.Rules {
+			ruleClients = append(ruleClients, rule.RuleClients...)
+		}
+
+		for _, values := range []interface{}{
+			snapshot.Configs, snapshot.Inbounds, snapshot.Clients, clientInbounds,
+			snapshot.Outbounds, snapshot.Balancers, snapshot.Rules, ruleClients,
+		} {
+			err = createAll(tx, values)
+			if err != nil {
+				return err
+			}
+		}
+		err = database.ResetSequences(tx, &model.Config{}, &model.Inbound{}, &model.Client{}, &model.ClientInbound{},
+			&model.Outbound{}, &model.Balancer{}, &model.Rule{}, &model.RuleClient{})
+		if err != nil {
+			return err
+		}
+
+		// enable has a default value, so it is skipped on create when false
+		for table, ids := range map[interface{}][]uint{
+			&model.Client{}:  disabledClients,
+			&model.Inbound{}: disabledInbounds,
+		} {
+			if len(ids) == 0 {
+				continue
+			}
+			err = tx.Model(table).Where("id in ?", ids).Update("enable", false).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// createAll creates rows of a slice without their associations, empty slices are skipped
+func createAll(tx *gorm.DB, values interface{}) error {
+	result := tx.Omit(clause.Associations).Create(values)
+	if result.Error == gorm.ErrEmptySlice {
+		return nil
+	}
+	return result.Error
+}
+
+// Report returns total traffic counters of clients and the server status for the primary
+func (s *AgentService) Report() (*AgentReport, error) {
+	db := database.GetDB()
+	var clients []*NodeClientTraffic
+	err := db.Model(model.Client{}).Select("id", "name", "up", "down").Find(&clients).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return &AgentReport{
+		Clients: clients,
+		Status:  s.ServerService.GetStatus(nil),
+	}, nil
+}
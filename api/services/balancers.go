@@ -0,0 +1,233 @@
+package services
+
+import (
+	"encoding/json"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/util/common"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type BalancerService struct {
+}
+
+func (s *BalancerService) GetAll() ([]*model.Balancer, error) {
+	db := database.GetDB()
+	var balancers []*model.Balancer
+	err := db.Model(model.Balancer{}).Find(&balancers).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	err = s.flagDisabledOutbounds(balancers...)
+	if err != nil {
+		return nil, err
+	}
+	return balancers, nil
+}
+
+var balancerListSpec = &listSpec{
+	sorts:       map[string]string{"id": "id", "tag": "tag", "strategy": "strategy"},
+	defaultSort: "id",
+	nameColumns: []string{"tag"},
+}
+
+// List returns a page of balancers matching the query and the count of all matching balancers
+func (s *BalancerService) List(query *ListQuery) ([]*model.Balancer, int64, error) {
+	db := database.GetDB()
+	var balancers []*model.Balancer
+	total, err := query.find(db.Model(model.Balancer{}), balancerListSpec, &balancers, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = s.flagDisabledOutbounds(balancers...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return balancers, total, nil
+}
+
+func (s *BalancerService) Get(id int) (*model.Balancer, error) {
+	db := database.GetDB()
+	var balancer *model.Balancer
+	err := db.Model(model.Balancer{}).Where("id = ?", id).Find(&balancer).Error
+	if err != nil {
+		return nil, err
+	}
+	if balancer.Id == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	err = s.flagDisabledOutbounds(balancer)
+	if err != nil {
+		return nil, err
+	}
+	return balancer, nil
+}
+
+// flagDisabledOutbounds warns about balancers without any enabled outbound or with a disabled fallback
+func (s *BalancerService) flagDisabledOutbounds(balancers ...*model.Balancer) error {
+	outboundService := OutboundService{}
+	disabledTags, err := outboundService.GetDisabledTags()
+	if err != nil {
+		return err
+	}
+	if len(disabledTags) == 0 {
+		return nil
+	}
+	enabledTags, err := outboundService.GetEnabledTags()
+	if err != nil {
+		return err
+	}
+	for _, balancer := range balancers {
+		var warnings []string
+		var selector []string
+		json.Unmarshal([]byte(balancer.Selector), &selector)
+		if !matchesAnyPrefix(enabledTags, selector) {
+			warnings = append(warnings, "no enabled outbound matches the selector")
+		}
+		if disabledTags[balancer.FallbackTag] {
+			warnings = append(warnings, "fallback outbound "+balancer.FallbackTag+" is disabled")
+		}
+		balancer.Warning = strings.Join(warnings, ", ")
+	}
+	return nil
+}
+
+// matchesAnyPrefix checks if any tag starts with one of the selector prefixes, the way xray selects balancer outbounds
+func matchesAnyPrefix(tags []string, selector []string) bool {
+	for _, tag := range tags {
+		for _, prefix := range selector {
+			if strings.HasPrefix(tag, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *BalancerService) Save(balancer *model.Balancer) error {
+	if balancer.Tag == "" {
+		return common.NewValidationError("balancer tag is required")
+	}
+	var selector []string
+	err := json.Unmarshal([]byte(balancer.Selector), &selector)
+	if err != nil || len(selector) == 0 {
+		return common.NewValidationError("balancer selector should be a non-empty array:", balancer.Selector)
+	}
+	if common.NonEmptyValue(balancer.Strategy) {
+		var strategy struct {
+			Type string `json:"type"`
+		}
+		err = json.Unmarshal([]byte(balancer.Strategy), &strategy)
+		if err != nil {
+			return common.NewValidationError("balancer strategy is not valid:", err)
+		}
+		switch strategy.Type {
+		case "", "random", "roundRobin", "leastPing", "leastLoad":
+		default:
+			return common.NewValidationError("unknown balancer strategy:", strategy.Type)
+		}
+	}
+
+	exists, err := defaultHasBalancer(balancer.Tag)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return common.NewValidationError("balancer tag is used in the default xray config:", balancer.Tag)
+	}
+
+	db := database.GetDB()
+	if balancer.Id > 0 {
+		// Rules refer to balancers by tag
+		var oldTag string
+		err = db.Model(model.Balancer{}).Select("tag").Where("id = ?", balancer.Id).Scan(&oldTag).Error
+		if err != nil {
+			return err
+		}
+		if oldTag != "" && oldTag != balancer.Tag {
+			var count int64
+			err = db.Model(model.Rule{}).Where("balancer_tag = ?", oldTag).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return common.NewValidationError("balancer is in use by some rules, it can not be renamed:", oldTag)
+			}
+		}
+	}
+	return db.Save(balancer).Error
+}
+
+func (s *BalancerService) Del(id uint) error {
+	db := database.GetDB()
+
+	// Check if rules using this balancer
+	var count int64
+	err := db.Model(model.Rule{}).
+		Where("balancer_tag IN (?)", db.Model(model.Balancer{}).Select("tag").Where("id = ?", id)).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.NewValidationError("balancer is in use by some rules")
+	}
+	return db.Delete(model.Balancer{}, id).Error
+}
+
+func (s *BalancerService) GetBalancerConfig(balancer *model.Balancer) map[string]interface{} {
+	balancerConfig := make(map[string]interface{})
+	balancerConfig["tag"] = balancer.Tag
+	var selector []string
+	json.Unmarshal([]byte(balancer.Selector), &selector)
+	balancerConfig["selector"] = selector
+	if common.NonEmptyValue(balancer.Strategy) {
+		var strategy interface{}
+		json.Unmarshal([]byte(balancer.Strategy), &strategy)
+		balancerConfig["strategy"] = strategy
+	}
+	if common.NonEmptyValue(balancer.FallbackTag) {
+		balancerConfig["fallbackTag"] = balancer.FallbackTag
+	}
+	return balancerConfig
+}
+
+// HasTag checks balancers of database and default xray config
+func (s *BalancerService) HasTag(tag string) (bool, error) {
+	db := database.GetDB()
+	var count int64
+	err := db.Model(model.Balancer{}).Where("tag = ?", tag).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	return defaultHasBalancer(tag)
+}
+
+// defaultHasBalancer checks balancers of the default xray config
+func defaultHasBalancer(tag string) (bool, error) {
+	settingService := SettingService{}
+	xrayConfig, err := settingService.GetXrayDefault()
+	if err != nil {
+		return false, err
+	}
+	var routeConfig struct {
+		Balancers []struct {
+			Tag string `json:"tag"`
+		} `json:"balancers"`
+	}
+	err = json.Unmarshal(xrayConfig.RoutingConfig, &routeConfig)
+	if err != nil {
+		return false, err
+	}
+	for _, balancer := range routeConfig.Balancers {
+		if balancer.Tag == tag {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"raha-xray/config"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"strings"
+	"testing"
+)
+
+func TestBalancerSave(t *testing.T) {
+	initTestDB(t)
+	savedDefault := xrayDefault
+	xrayDefault = config.GetDefaultXrayConfig()
+	xrayDefault = strings.Replace(xrayDefault, `"routing": {`, `"routing": {"balancers": [{"tag": "default", "selector": ["direct"]}],`, 1)
+	t.Cleanup(func() { xrayDefault = savedDefault })
+	s := &BalancerService{}
+
+	used := &model.Balancer{Tag: "used", Selector: `["direct"]`}
+	free := &model.Balancer{Tag: "free", Selector: `["direct"]`}
+	for _, balancer := range []*model.Balancer{used, free} {
+		err := s.Save(balancer)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := database.GetDB().Create(&model.Rule{Enable: true, BalancerTag: "used"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Save(&model.Balancer{Tag: "default", Selector: `["direct"]`})
+	if err == nil {
+		t.Error("balancer of the default config is duplicated")
+	}
+	err = s.Save(&model.Balancer{Id: used.Id, Tag: "renamed", Selector: `["direct"]`})
+	if err == nil {
+		t.Error("balancer of a rule is renamed")
+	}
+	// changes keeping the tag and renames of unused balancers are allowed
+	err = s.Save(&model.Balancer{Id: used.Id, Tag: "used", Selector: `["blocked"]`})
+	if err != nil {
+		t.Error(err)
+	}
+	err = s.Save(&model.Balancer{Id: free.Id, Tag: "renamed", Selector: `["direct"]`})
+	if err != nil {
+		t.Error(err)
+	}
+}
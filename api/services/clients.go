@@ -2,10 +2,13 @@ package services
 
 import (
 	"encoding/json"
+	"raha-xray/config"
 	"raha-xray/database"
 	"raha-xray/database/model"
 	"raha-xray/logger"
+	"raha-xray/util/common"
 	"raha-xray/xray"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -13,6 +16,10 @@ import (
 type ClientService struct {
 	xray.XrayAPI
 	InboundService
+	HistoryService
+	NotificationService
+	RuleService
+	QuotaService
 }
 
 func (s *ClientService) GetAll() ([]*model.Client, error) {
@@ -25,6 +32,68 @@ func (s *ClientService) GetAll() ([]*model.Client, error) {
 	return clients, nil
 }
 
+var clientListSpec = &listSpec{
+	sorts: map[string]string{
+		"id": "id", "name": "name", "enable": "enable", "quota": "quota", "expiry": "expiry",
+		"up": "up", "down": "down", "usage": "up + down",
+		"firstUsed": "first_used", "lastOnline": "last_online", "onlineTime": "online_time",
+	},
+	defaultSort: "id",
+	nameColumns: []string{"name"},
+	enable:      true,
+}
+
+// List returns a page of clients matching the query and the count of all matching clients
+func (s *ClientService) List(query *ListQuery) ([]*model.Client, int64, error) {
+	db := database.GetDB()
+	tx := db.Model(model.Client{})
+	now := time.Now().UnixMilli()
+	if query.Expired != nil {
+		if *query.Expired {
+			tx = tx.Where("expiry > 0 and expiry <= ?", now)
+		} else {
+			tx = tx.Where("(expiry = 0 or expiry > ?)", now)
+		}
+	}
+	if query.OverQuota != nil {
+		if *query.OverQuota {
+			tx = tx.Where("quota > 0 and up + down >= quota")
+		} else {
+			tx = tx.Where("(quota = 0 or up + down < quota)")
+		}
+	}
+	if query.InboundId > 0 {
+		tx = tx.Where("id in (?)", db.Model(model.ClientInbound{}).Select("client_id").Where("inbound_id = ?", query.InboundId))
+	}
+	if query.Remark != "" {
+		tx = tx.Where(searchCondition(tx, []string{"remark"}, query.Remark))
+	}
+
+	var clients []*model.Client
+	total, err := query.find(tx, clientListSpec, &clients, func(tx *gorm.DB) *gorm.DB {
+		if query.NoPreload {
+			return tx
+		}
+		return tx.Preload("ClientInbounds")
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return clients, total, nil
+}
+
+// GetInactive returns clients which have not been online in the last days, including never used ones
+func (s *ClientService) GetInactive(days int) ([]*model.Client, error) {
+	db := database.GetDB()
+	var clients []*model.Client
+	threshold := time.Now().AddDate(0, 0, -days).UnixMilli()
+	err := db.Model(model.Client{}).Preload("ClientInbounds").Where("last_online < ?", threshold).Find(&clients).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return clients, nil
+}
+
 func (s *ClientService) Get(id uint) (*model.Client, error) {
 	db := database.GetDB()
 	client := &model.Client{}
@@ -38,7 +107,75 @@ func (s *ClientService) Get(id uint) (*model.Client, error) {
 	return client, nil
 }
 
+func (s *ClientService) GetByName(name string) (*model.Client, error) {
+	db := database.GetDB()
+	client := &model.Client{}
+	err := db.Model(model.Client{}).Preload("ClientInbounds").Where("name = ?", name).Find(client).Error
+	if err != nil {
+		return nil, err
+	}
+	if client.Id == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return client, nil
+}
+
+func (s *ClientService) GetByTgId(tgId int64) (*model.Client, error) {
+	db := database.GetDB()
+	client := &model.Client{}
+	err := db.Model(model.Client{}).Where("tg_id = ?", tgId).Find(client).Error
+	if err != nil {
+		return nil, err
+	}
+	if client.Id == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return client, nil
+}
+
+// Link binds a telegram account to the client owning secret as id or password in any of its inbounds
+func (s *ClientService) Link(name string, secret string, tgId int64) (*model.Client, error) {
+	client, err := s.GetByName(name)
+	if err != nil {
+		return nil, err
+	}
+	matched := false
+	for _, clientInbound := range client.ClientInbounds {
+		var clientConfig map[string]interface{}
+		json.Unmarshal([]byte(clientInbound.Config), &clientConfig)
+		if clientConfig["id"] == secret || clientConfig["password"] == secret {
+			matched = true
+			break
+		}
+	}
+	if !matched || secret == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	db := database.GetDB()
+	err = db.Model(model.Client{}).Where("tg_id = ?", tgId).Update("tg_id", 0).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(model.Client{}).Where("id = ?", client.Id).Update("tg_id", tgId).Error
+	if err != nil {
+		return nil, err
+	}
+	client.TgId = tgId
+	return client, nil
+}
+
 func (s *ClientService) Add(clients []*model.Client) (error, bool) {
+	return s.add(clients, false)
+}
+
+// Create adds a new client, unlike Add it keeps a false enable instead of the default value
+func (s *ClientService) Create(client *model.Client) (error, bool) {
+	client.Id = 0
+	return s.add([]*model.Client{client}, true)
+}
+
+func (s *ClientService) add(clients []*model.Client, keepEnable bool) (error, bool) {
 	var err, err1 error
 	db := database.GetDB()
 	tx := db.Begin()
@@ -59,6 +196,8 @@ func (s *ClientService) Add(clients []*model.Client) (error, bool) {
 
 	// Add clients to inbounds by API
 	for _, client := range clients {
+		// Activity is recorded by traffic, it is not taken from input
+		client.FirstUsed, client.LastOnline, client.OnlineTime, client.CreatedAt = 0, 0, 0, 0
 		for index, clientInbound := range client.ClientInbounds {
 			var inbound model.Inbound
 			err1 = tx.Model(model.Inbound{}).Preload("Config").Where("id = ?", clientInbound.InboundId).Find(&inbound).Error
@@ -73,7 +212,7 @@ func (s *ClientService) Add(clients []*model.Client) (error, bool) {
 				newClientConfig, _ := json.MarshalIndent(clientConfig, "", "  ")
 				client.ClientInbounds[index].Config = string(newClientConfig)
 
-				if !needRestart && client.Enable {
+				if !needRestart && client.Enable && inbound.NodeId == 0 {
 					// Call API
 					err1 = s.XrayAPI.AddUser(string(inbound.Config.Protocol), inbound.Tag, clientConfig)
 					if err1 == nil {
@@ -87,15 +226,109 @@ func (s *ClientService) Add(clients []*model.Client) (error, bool) {
 		}
 	}
 
+	enables := make([]bool, len(clients))
+	for index, client := range clients {
+		enables[index] = client.Enable
+	}
 	err = tx.Create(clients).Error
 	if err != nil {
 		return err, needRestart
 	}
+	if keepEnable {
+		for index, client := range clients {
+			if enables[index] {
+				continue
+			}
+			// enable has a default value, so it is skipped on create when false
+			err = tx.Model(client).Update("enable", false).Error
+			if err != nil {
+				return err, needRestart
+			}
+		}
+	}
 
 	return nil, needRestart
 }
 
+// ClientPatch has the fields of a client which can be changed, nil fields are kept
+type ClientPatch struct {
+	Name   *string `json:"name"`
+	Enable *bool   `json:"enable"`
+	Quota  *uint64 `json:"quota"`
+	Expiry *uint64 `json:"expiry"`
+	Reset  *uint   `json:"reset"`
+	Once   *uint   `json:"once"`
+	Remark *string `json:"remark"`
+	TgId   *int64  `json:"tgId"`
+}
+
+// NewClientPatch sets all fields of the patch from a client, to replace them
+func NewClientPatch(client *model.Client) *ClientPatch {
+	return &ClientPatch{
+		Name:   &client.Name,
+		Enable: &client.Enable,
+		Quota:  &client.Quota,
+		Expiry: &client.Expiry,
+		Reset:  &client.Reset,
+		Once:   &client.Once,
+		Remark: &client.Remark,
+		TgId:   &client.TgId,
+	}
+}
+
+func (patch *ClientPatch) apply(client *model.Client) {
+	if patch.Name != nil {
+		client.Name = *patch.Name
+	}
+	if patch.Enable != nil {
+		client.Enable = *patch.Enable
+	}
+	if patch.Quota != nil {
+		client.Quota = *patch.Quota
+	}
+	if patch.Expiry != nil {
+		client.Expiry = *patch.Expiry
+	}
+	if patch.Reset != nil {
+		client.Reset = *patch.Reset
+	}
+	if patch.Once != nil {
+		client.Once = *patch.Once
+	}
+	if patch.Remark != nil {
+		client.Remark = *patch.Remark
+	}
+	if patch.TgId != nil {
+		client.TgId = *patch.TgId
+	}
+}
+
+// activityFields are recorded by traffic, they are not changed by input
+var activityFields = []string{"firstUsed", "lastOnline", "onlineTime", "createdAt"}
+
+// Update merges the fields in data into the client with the id of data
 func (s *ClientService) Update(data map[string]interface{}) (error, bool) {
+	for _, field := range activityFields {
+		delete(data, field)
+	}
+	return s.update(data["id"], func(client *model.Client) error {
+		dataBytes, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(dataBytes, client)
+	})
+}
+
+// Patch changes the fields of a client which are set in the patch, usage and inbounds are kept
+func (s *ClientService) Patch(id uint, patch *ClientPatch) (error, bool) {
+	return s.update(id, func(client *model.Client) error {
+		patch.apply(client)
+		return nil
+	})
+}
+
+func (s *ClientService) update(id interface{}, change func(client *model.Client) error) (error, bool) {
 	var err, err1 error
 	db := database.GetDB()
 	tx := db.Begin()
@@ -116,18 +349,18 @@ func (s *ClientService) Update(data map[string]interface{}) (error, bool) {
 
 	// Find old configuration of client by id
 	var oldClient model.Client
-	err = tx.Model(model.Client{}).Where("id = ?", data["id"]).Preload("ClientInbounds").Find(&oldClient).Error
+	err = tx.Model(model.Client{}).Where("id = ?", id).Preload("ClientInbounds").Find(&oldClient).Error
 	if err != nil {
 		return err, false
 	}
-
-	// Update the newClient with data inside the map
-	newClient := oldClient
-	dataBytes, err := json.Marshal(data)
-	if err != nil {
+	if oldClient.Id == 0 {
+		err = gorm.ErrRecordNotFound
 		return err, false
 	}
-	err = json.Unmarshal(dataBytes, &newClient)
+
+	// Update the newClient with the changes
+	newClient := oldClient
+	err = change(&newClient)
 	if err != nil {
 		return err, false
 	}
@@ -156,7 +389,7 @@ func (s *ClientService) Update(data map[string]interface{}) (error, bool) {
 				newClientConfig, _ := json.MarshalIndent(clientConfig, "", "  ")
 				oldClient.ClientInbounds[index].Config = string(newClientConfig)
 
-				if !needRestart && newClient.Enable {
+				if !needRestart && newClient.Enable && inbound.NodeId == 0 {
 					// Call API
 					err1 = s.XrayAPI.AddUser(string(inbound.Config.Protocol), inbound.Tag, clientConfig)
 					if err1 == nil {
@@ -169,9 +402,26 @@ func (s *ClientService) Update(data map[string]interface{}) (error, bool) {
 			}
 		}
 		// Update ClientInbounds due to changes client
-		err = tx.Save(oldClient.ClientInbounds).Error
+		if len(oldClient.ClientInbounds) > 0 {
+			err = tx.Save(oldClient.ClientInbounds).Error
+			if err != nil {
+				return err, true // Restart on unsuccessfull update
+			}
+		}
+	}
+
+	// Warnings fire again for a raised quota or an extended expiry
+	var clearEvents []string
+	if newClient.Quota != oldClient.Quota && (newClient.Quota == 0 || newClient.Quota > oldClient.Quota) {
+		clearEvents = append(clearEvents, EventQuota)
+	}
+	if newClient.Expiry != oldClient.Expiry && (newClient.Expiry == 0 || newClient.Expiry > oldClient.Expiry) {
+		clearEvents = append(clearEvents, EventExpiry)
+	}
+	if len(clearEvents) > 0 {
+		err = s.NotificationService.ClearAlerts(tx, newClient.Id, clearEvents...)
 		if err != nil {
-			return err, true // Restart on unsuccessfull update
+			return err, false
 		}
 	}
 
@@ -240,19 +490,25 @@ func (s *ClientService) Inbounds(id int, clientInbounds []*model.ClientInbound)
 				return err, false
 			}
 
-			// Call API to add new config
-			err1 = s.XrayAPI.AddUser(string(inbound.Config.Protocol), inbound.Tag, clientConfig)
-			if err1 == nil {
-				logger.Debug("Client added by api:", client.Name)
-			} else {
-				logger.Debug("Failed to adding client by api:", err1)
-				needRestart = true
+			// Call API to add new config, inbounds of nodes are not in the local xray
+			if inbound.NodeId == 0 {
+				err1 = s.XrayAPI.AddUser(string(inbound.Config.Protocol), inbound.Tag, clientConfig)
+				if err1 == nil {
+					logger.Debug("Client added by api:", client.Name)
+				} else {
+					logger.Debug("Failed to adding client by api:", err1)
+					needRestart = true
+				}
 			}
 		}
 	}
 
 	// Remove ommited ClientInbounds
-	err = tx.Where("id not in ? and client_id=?", clientInboundIds, client.Id).Delete(model.ClientInbound{}).Error
+	omitted := tx.Where("client_id = ?", client.Id)
+	if len(clientInboundIds) > 0 {
+		omitted = omitted.Where("id not in ?", clientInboundIds)
+	}
+	err = omitted.Delete(model.ClientInbound{}).Error
 	if err != nil {
 		return err, needRestart
 	}
@@ -292,7 +548,8 @@ func (s *ClientService) apiRemoveClients(tx *gorm.DB, client_id uint) bool {
 				FROM clients
 				WHERE enable = ? AND id = ?
 				) AS client ON client_inbounds.client_id = client.id
-			) AS clientInbound ON inbounds.id = clientInbound.inbound_id;
+			) AS clientInbound ON inbounds.id = clientInbound.inbound_id
+		WHERE inbounds.node_id = 0;
 		`, true, client_id).Scan(&clientTags).Error
 	if err != nil {
 		logger.Debug("Failed to find client data for removing by API:", err)
@@ -339,6 +596,18 @@ func (s *ClientService) Del(id uint) (error, bool) {
 	if err != nil {
 		return err, needRestart
 	}
+	err = s.HistoryService.DelByClient(tx, id)
+	if err != nil {
+		return err, needRestart
+	}
+	err = s.NotificationService.ClearAlerts(tx, id)
+	if err != nil {
+		return err, needRestart
+	}
+	err = s.RuleService.DelClient(tx, id)
+	if err != nil {
+		return err, needRestart
+	}
 	err = tx.Delete(model.Client{}, id).Error
 	if err != nil {
 		return err, needRestart
@@ -346,6 +615,64 @@ func (s *ClientService) Del(id uint) (error, bool) {
 	return nil, needRestart
 }
 
+func (s *ClientService) Reset(id uint) error {
+	if config.GetSettings().QuotaMode == QuotaCentral {
+		// the central instance would restore the usage on the next report
+		return common.NewValidationError("client usage is shared by the central quota instance, reset it there")
+	}
+	var err error
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	client := &model.Client{}
+	err = tx.Model(model.Client{}).Where("id = ?", id).Find(client).Error
+	if err != nil {
+		return err
+	}
+	if client.Id == 0 {
+		err = gorm.ErrRecordNotFound
+		return err
+	}
+
+	err = s.HistoryService.Record(tx, client, model.HistoryManual)
+	if err != nil {
+		return err
+	}
+	err = s.NotificationService.ClearAlerts(tx, client.Id)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Model(model.Client{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"up":   0,
+			"down": 0,
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return err
+	}
+
+	client.Up = 0
+	client.Down = 0
+	s.NotificationService.Send(NewEvent(EventReset, client, 0))
+	// shared usage is reset only when the local reset is committed
+	return s.QuotaService.ResetUsage(client.Name)
+}
+
+func (s *ClientService) History(id uint) ([]*model.ClientHistory, error) {
+	return s.HistoryService.GetByClient(id)
+}
+
 func (s *ClientService) GetOnlineClinets() []string {
 	return p.GetOnlineClients()
 }
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"raha-xray/database/model"
+	"raha-xray/xray"
+	"testing"
+)
+
+func TestClientEnableOnCreate(t *testing.T) {
+	initTestDB(t)
+	savedProcess := p
+	p = xray.NewProcess(&xray.Config{})
+	t.Cleanup(func() { p = savedProcess })
+	s := &ClientService{}
+
+	// v1 clients without enable are enabled by the default value
+	err, _ := s.Add([]*model.Client{{Name: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err, _ = s.Create(&model.Client{Id: 5, Name: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"a": true, "b": false} {
+		client, err := s.GetByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client.Enable != want {
+			t.Errorf("client %s enable %v, want %v", name, client.Enable, want)
+		}
+	}
+}
+
+func TestClientActivityIgnoredOnInput(t *testing.T) {
+	initTestDB(t)
+	savedProcess := p
+	p = xray.NewProcess(&xray.Config{})
+	t.Cleanup(func() { p = savedProcess })
+	s := &ClientService{}
+
+	err, _ := s.Add([]*model.Client{{Name: "a", FirstUsed: 1, LastOnline: 2, OnlineTime: 3, CreatedAt: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := s.GetByName("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.FirstUsed != 0 || client.LastOnline != 0 || client.OnlineTime != 0 || client.CreatedAt < 4 {
+		t.Errorf("activity of a new client is taken from input: %+v", client)
+	}
+
+	err, _ = s.Update(map[string]interface{}{
+		"id": client.Id, "name": "a", "remark": "changed",
+		"firstUsed": 5, "lastOnline": 6, "onlineTime": 7, "createdAt": 8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := s.GetByName("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Remark != "changed" {
+		t.Errorf("remark is %q", updated.Remark)
+	}
+	if updated.FirstUsed != 0 || updated.LastOnline != 0 || updated.OnlineTime != 0 || updated.CreatedAt != client.CreatedAt {
+		t.Errorf("activity of an updated client is taken from input: %+v", updated)
+	}
+}
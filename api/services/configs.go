@@ -1,10 +1,10 @@
 package services
 
 import (
-	"errors"
 	"raha-xray/database"
 	"raha-xray/database/model"
 	"raha-xray/logger"
+	"raha-xray/util/common"
 
 	"gorm.io/gorm"
 )
@@ -23,6 +23,23 @@ func (s *ConfigService) GetAll() ([]*model.Config, error) {
 	return configs, nil
 }
 
+var configListSpec = &listSpec{
+	sorts:       map[string]string{"id": "id", "protocol": "protocol"},
+	defaultSort: "id",
+	nameColumns: []string{"protocol"},
+}
+
+// List returns a page of configs matching the query and the count of all matching configs
+func (s *ConfigService) List(query *ListQuery) ([]*model.Config, int64, error) {
+	db := database.GetDB()
+	var configs []*model.Config
+	total, err := query.find(db.Model(model.Config{}), configListSpec, &configs, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return configs, total, nil
+}
+
 func (s *ConfigService) Get(id int) (*model.Config, error) {
 	db := database.GetDB()
 	var config *model.Config
@@ -73,6 +90,39 @@ func (s *ConfigService) Save(config *model.Config) (error, bool) {
 	return nil, needRestart
 }
 
+// ConfigPatch has the fields of a config which can be changed, nil fields are kept
+type ConfigPatch struct {
+	Protocol       *model.Protocol `json:"protocol"`
+	Settings       *string         `json:"settings"`
+	StreamSettings *string         `json:"streamSettings"`
+	Sniffing       *string         `json:"sniffing"`
+	ClientSettings *string         `json:"clientSettings"`
+}
+
+// Patch changes the fields of a config which are set in the patch
+func (s *ConfigService) Patch(id uint, patch *ConfigPatch) (error, bool) {
+	config, err := s.Get(int(id))
+	if err != nil {
+		return err, false
+	}
+	if patch.Protocol != nil {
+		config.Protocol = *patch.Protocol
+	}
+	if patch.Settings != nil {
+		config.Settings = *patch.Settings
+	}
+	if patch.StreamSettings != nil {
+		config.StreamSettings = *patch.StreamSettings
+	}
+	if patch.Sniffing != nil {
+		config.Sniffing = *patch.Sniffing
+	}
+	if patch.ClientSettings != nil {
+		config.ClientSettings = *patch.ClientSettings
+	}
+	return s.Save(config)
+}
+
 func (s *ConfigService) Del(id uint) error {
 	db := database.GetDB()
 
@@ -83,7 +133,7 @@ func (s *ConfigService) Del(id uint) error {
 		return result.Error
 	}
 	if count > 0 {
-		return errors.New("config is in use by some inbounds")
+		return common.NewValidationError("config is in use by some inbounds")
 	}
 	return db.Delete(model.Config{}, id).Error
 }
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"encoding/json"
+	"net"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/util/common"
+	"raha-xray/util/json_util"
+
+	"gorm.io/gorm"
+)
+
+type DnsService struct {
+}
+
+func (s *DnsService) GetServers() ([]*model.DnsServer, error) {
+	db := database.GetDB()
+	var servers []*model.DnsServer
+	err := db.Model(model.DnsServer{}).Order("priority, id").Find(&servers).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return servers, nil
+}
+
+func (s *DnsService) SaveServer(server *model.DnsServer) error {
+	err := validateDnsAddress(server.Address)
+	if err != nil {
+		return err
+	}
+	if server.Port > 65535 {
+		return common.NewValidationError("dns server port is not valid:", server.Port)
+	}
+	if common.NonEmptyValue(server.Domains) {
+		var domains []string
+		err = json.Unmarshal([]byte(server.Domains), &domains)
+		if err != nil {
+			return common.NewValidationError("dns server domains should be an array:", err)
+		}
+		err = validateDomains(domains)
+		if err != nil {
+			return err
+		}
+	}
+	if common.NonEmptyValue(server.ExpectIps) {
+		var ips []string
+		err = json.Unmarshal([]byte(server.ExpectIps), &ips)
+		if err != nil {
+			return common.NewValidationError("dns server expectIps should be an array:", err)
+		}
+		err = validateIps(ips)
+		if err != nil {
+			return err
+		}
+	}
+	if server.ClientIp != "" && net.ParseIP(server.ClientIp) == nil {
+		return common.NewValidationError("dns server clientIp is not valid:", server.ClientIp)
+	}
+
+	db := database.GetDB()
+	return db.Save(server).Error
+}
+
+func (s *DnsService) DelServer(id uint) error {
+	db := database.GetDB()
+	return db.Delete(model.DnsServer{}, id).Error
+}
+
+func (s *DnsService) GetHosts() ([]*model.DnsHost, error) {
+	db := database.GetDB()
+	var hosts []*model.DnsHost
+	err := db.Model(model.DnsHost{}).Find(&hosts).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+func (s *DnsService) SaveHost(host *model.DnsHost) error {
+	err := validateDomains([]string{host.Domain})
+	if err != nil {
+		return err
+	}
+	var addresses []string
+	err = json.Unmarshal([]byte(host.Address), &addresses)
+	if err != nil || len(addresses) == 0 {
+		return common.NewValidationError("dns host address should be a non-empty array:", host.Address)
+	}
+	for _, address := range addresses {
+		if net.ParseIP(address) == nil && validateHostname(address) != nil {
+			return common.NewValidationError("dns host address should be an ip or a domain name:", address)
+		}
+	}
+
+	db := database.GetDB()
+	return db.Save(host).Error
+}
+
+func (s *DnsService) DelHost(id uint) error {
+	db := database.GetDB()
+	return db.Delete(model.DnsHost{}, id).Error
+}
+
+func (s *DnsService) GetFakeDnsPools() ([]*model.FakeDnsPool, error) {
+	db := database.GetDB()
+	var pools []*model.FakeDnsPool
+	err := db.Model(model.FakeDnsPool{}).Find(&pools).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return pools, nil
+}
+
+func (s *DnsService) SaveFakeDnsPool(pool *model.FakeDnsPool) error {
+	_, ipNet, err := net.ParseCIDR(pool.IpPool)
+	if err != nil {
+		return common.NewValidationError("fakedns ip pool is not a valid CIDR:", pool.IpPool)
+	}
+	ones, bits := ipNet.Mask.Size()
+	if pool.PoolSize <= 0 || (bits-ones < 31 && pool.PoolSize > 1<<(bits-ones)) {
+		return common.NewValidationError("fakedns pool size does not fit the ip pool:", pool.PoolSize)
+	}
+
+	db := database.GetDB()
+	return db.Save(pool).Error
+}
+
+func (s *DnsService) DelFakeDnsPool(id uint) error {
+	db := database.GetDB()
+	return db.Delete(model.FakeDnsPool{}, id).Error
+}
+
+// GetDnsConfig appends servers and overrides hosts of the default dns config
+func (s *DnsService) GetDnsConfig(dns json_util.RawMessage) (json_util.RawMessage, error) {
+	servers, err := s.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := s.GetHosts()
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 && len(hosts) == 0 {
+		return dns, nil
+	}
+
+	dnsConfig := make(map[string]interface{})
+	if common.NonEmptyValue(string(dns)) && string(dns) != "null" {
+		err = json.Unmarshal(dns, &dnsConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	serversConfig, _ := dnsConfig["servers"].([]interface{})
+	for _, server := range servers {
+		serverConfig := make(map[string]interface{})
+		serverConfig["address"] = server.Address
+		if server.Port > 0 {
+			serverConfig["port"] = server.Port
+		}
+		if common.NonEmptyValue(server.Domains) {
+			var domains []string
+			json.Unmarshal([]byte(server.Domains), &domains)
+			serverConfig["domains"] = domains
+		}
+		if common.NonEmptyValue(server.ExpectIps) {
+			var expectIps []string
+			json.Unmarshal([]byte(server.ExpectIps), &expectIps)
+			serverConfig["expectIPs"] = expectIps
+		}
+		if server.SkipFallback {
+			serverConfig["skipFallback"] = true
+		}
+		if server.ClientIp != "" {
+			serverConfig["clientIp"] = server.ClientIp
+		}
+		serversConfig = append(serversConfig, serverConfig)
+	}
+	if len(serversConfig) > 0 {
+		dnsConfig["servers"] = serversConfig
+	}
+
+	hostsConfig, _ := dnsConfig["hosts"].(map[string]interface{})
+	if hostsConfig == nil {
+		hostsConfig = make(map[string]interface{})
+	}
+	for _, host := range hosts {
+		var addresses []string
+		json.Unmarshal([]byte(host.Address), &addresses)
+		if len(addresses) == 1 {
+			hostsConfig[host.Domain] = addresses[0]
+		} else {
+			hostsConfig[host.Domain] = addresses
+		}
+	}
+	if len(hostsConfig) > 0 {
+		dnsConfig["hosts"] = hostsConfig
+	}
+
+	return json.Marshal(dnsConfig)
+}
+
+// GetFakeDnsConfig appends database pools to the default fakedns pools
+func (s *DnsService) GetFakeDnsConfig(fakeDns json_util.RawMessage) (json_util.RawMessage, error) {
+	pools, err := s.GetFakeDnsPools()
+	if err != nil {
+		return nil, err
+	}
+	if len(pools) == 0 {
+		return fakeDns, nil
+	}
+
+	var poolsConfig []interface{}
+	if common.NonEmptyValue(string(fakeDns)) && string(fakeDns) != "null" {
+		// fakedns can be a single pool object or an array of pools
+		var defaultPools interface{}
+		err = json.Unmarshal(fakeDns, &defaultPools)
+		if err != nil {
+			return nil, err
+		}
+		switch defaultPools := defaultPools.(type) {
+		case []interface{}:
+			poolsConfig = defaultPools
+		case map[string]interface{}:
+			poolsConfig = append(poolsConfig, defaultPools)
+		}
+	}
+	for _, pool := range pools {
+		poolsConfig = append(poolsConfig, map[string]interface{}{
+			"ipPool":   pool.IpPool,
+			"poolSize": pool.PoolSize,
+		})
+	}
+	return json.Marshal(poolsConfig)
+}
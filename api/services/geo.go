@@ -0,0 +1,300 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"raha-xray/config"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/logger"
+	"raha-xray/util/common"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/app/router"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+const (
+	GeoIpFile         = "geoip.dat"
+	GeoSiteFile       = "geosite.dat"
+	CustomGeoIpFile   = "custom_ip.dat"
+	CustomGeoSiteFile = "custom_site.dat"
+)
+
+// MaxGeoAssetSize limits uploaded and downloaded geo assets, the full geosite.dat is about 10MB
+const MaxGeoAssetSize = 100 << 20
+
+var geoListNameRegex = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+type GeoService struct {
+	XrayService
+}
+
+func (s *GeoService) GetLists() ([]*model.GeoList, error) {
+	db := database.GetDB()
+	var lists []*model.GeoList
+	err := db.Model(model.GeoList{}).Find(&lists).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return lists, nil
+}
+
+func (s *GeoService) SaveList(list *model.GeoList) error {
+	if !geoListNameRegex.MatchString(list.Name) {
+		return common.NewValidationError("geo list name should contain only letters, digits, - and _:", list.Name)
+	}
+	var entries []string
+	err := json.Unmarshal([]byte(list.Entries), &entries)
+	if err != nil {
+		return common.NewValidationError("geo list entries should be an array:", err)
+	}
+	switch list.Type {
+	case model.GeoListDomain:
+		_, err = toGeoDomains(entries)
+	case model.GeoListIp:
+		_, err = toGeoCidrs(entries)
+	default:
+		return common.NewValidationError("geo list type should be domain or ip:", list.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	db := database.GetDB()
+	err = db.Save(list).Error
+	if err != nil {
+		return err
+	}
+	return s.CompileLists()
+}
+
+func (s *GeoService) DelList(id uint) error {
+	db := database.GetDB()
+	err := db.Delete(model.GeoList{}, id).Error
+	if err != nil {
+		return err
+	}
+	return s.CompileLists()
+}
+
+// CompileLists writes custom lists as dat files, rules can use them as ext:custom_site.dat:name or ext:custom_ip.dat:name
+func (s *GeoService) CompileLists() error {
+	lists, err := s.GetLists()
+	if err != nil {
+		return err
+	}
+	siteList := &router.GeoSiteList{}
+	ipList := &router.GeoIPList{}
+	for _, list := range lists {
+		var entries []string
+		json.Unmarshal([]byte(list.Entries), &entries)
+		if list.Type == model.GeoListDomain {
+			domains, err := toGeoDomains(entries)
+			if err != nil {
+				return err
+			}
+			siteList.Entry = append(siteList.Entry, &router.GeoSite{
+				CountryCode: strings.ToUpper(list.Name),
+				Domain:      domains,
+			})
+		} else {
+			cidrs, err := toGeoCidrs(entries)
+			if err != nil {
+				return err
+			}
+			ipList.Entry = append(ipList.Entry, &router.GeoIP{
+				CountryCode: strings.ToUpper(list.Name),
+				Cidr:        cidrs,
+			})
+		}
+	}
+
+	siteData, err := proto.Marshal(siteList)
+	if err != nil {
+		return err
+	}
+	err = writeGeoFile(CustomGeoSiteFile, siteData)
+	if err != nil {
+		return err
+	}
+	ipData, err := proto.Marshal(ipList)
+	if err != nil {
+		return err
+	}
+	return writeGeoFile(CustomGeoIpFile, ipData)
+}
+
+func toGeoDomains(entries []string) ([]*router.Domain, error) {
+	domains := make([]*router.Domain, 0, len(entries))
+	for _, entry := range entries {
+		domain := &router.Domain{Type: router.Domain_Domain, Value: entry}
+		switch {
+		case strings.HasPrefix(entry, "full:"):
+			domain.Type = router.Domain_Full
+		case strings.HasPrefix(entry, "domain:"):
+			domain.Type = router.Domain_Domain
+		case strings.HasPrefix(entry, "keyword:"):
+			domain.Type = router.Domain_Plain
+		case strings.HasPrefix(entry, "regexp:"):
+			domain.Type = router.Domain_Regex
+		case strings.Contains(entry, ":"):
+			return nil, common.NewValidationError("unsupported matcher in geo list:", entry)
+		}
+		if i := strings.Index(entry, ":"); i >= 0 {
+			domain.Value = entry[i+1:]
+		}
+		if domain.Value == "" {
+			return nil, common.NewValidationError("empty domain in geo list")
+		}
+		if domain.Type == router.Domain_Regex {
+			_, err := regexp.Compile(domain.Value)
+			if err != nil {
+				return nil, common.NewValidationErrorf("invalid regexp %s: %v", entry, err)
+			}
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+func toGeoCidrs(entries []string) ([]*router.CIDR, error) {
+	cidrs := make([]*router.CIDR, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, common.NewValidationError("invalid ip in geo list:", entry)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, common.NewValidationError("invalid CIDR in geo list:", entry)
+		}
+		ip := ipNet.IP
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		ones, _ := ipNet.Mask.Size()
+		cidrs = append(cidrs, &router.CIDR{Ip: ip, Prefix: uint32(ones)})
+	}
+	return cidrs, nil
+}
+
+// UpdateAssets downloads geoip and geosite files and restarts xray to load them
+func (s *GeoService) UpdateAssets() error {
+	appConfig := config.GetSettings()
+	assets := map[string]string{
+		GeoIpFile:   appConfig.GeoIpUrl,
+		GeoSiteFile: appConfig.GeoSiteUrl,
+	}
+	updated := false
+	for file, url := range assets {
+		if url == "" {
+			continue
+		}
+		err := s.download(file, url)
+		if err != nil {
+			return common.NewErrorf("update %s failed: %v", file, err)
+		}
+		logger.Info("Geo asset updated:", file)
+		updated = true
+	}
+	if updated && s.XrayService.IsXrayRunning() {
+		return s.XrayService.RestartXray()
+	}
+	return nil
+}
+
+// download fetches url and verifies it by the sha256sum file published next to it
+func (s *GeoService) download(file string, url string) error {
+	client := &http.Client{Timeout: 5 * time.Minute}
+
+	sumResp, err := client.Get(url + ".sha256sum")
+	if err != nil {
+		return err
+	}
+	defer sumResp.Body.Close()
+	if sumResp.StatusCode != http.StatusOK {
+		return common.NewErrorf("checksum download status %d", sumResp.StatusCode)
+	}
+	sumData, err := io.ReadAll(io.LimitReader(sumResp.Body, 1024))
+	if err != nil {
+		return err
+	}
+	sumFields := strings.Fields(string(sumData))
+	if len(sumFields) == 0 {
+		return common.NewError("empty checksum file")
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return common.NewErrorf("download status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxGeoAssetSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxGeoAssetSize {
+		return common.NewErrorf("%s is larger than %d bytes", file, MaxGeoAssetSize)
+	}
+	return s.SaveAsset(file, data, sumFields[0])
+}
+
+// SaveAsset verifies data against the hex sha256 checksum if given and replaces the asset file
+func (s *GeoService) SaveAsset(file string, data []byte, checksum string) error {
+	if file != GeoIpFile && file != GeoSiteFile {
+		return common.NewValidationError("unknown geo asset:", file)
+	}
+	if checksum != "" {
+		sum := sha256.Sum256(data)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), checksum) {
+			return common.NewError("checksum mismatch for", file)
+		}
+	}
+	return writeGeoFile(file, data)
+}
+
+// writeGeoFile replaces the file in xray folder atomically
+func writeGeoFile(file string, data []byte) error {
+	folder := config.GetXrayFolderPath()
+	err := os.MkdirAll(folder, 0750)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(folder, file+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(folder, file))
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"raha-xray/config"
+	"raha-xray/database/model"
+	"testing"
+
+	"github.com/xtls/xray-core/app/router"
+	"google.golang.org/protobuf/proto"
+)
+
+// initGeoTest points the xray folder to a temporary directory without a running xray
+func initGeoTest(t *testing.T) string {
+	t.Helper()
+	folder := t.TempDir()
+	t.Setenv("RAHA_XRAY_FOLDER", folder)
+	savedProcess := p
+	p = nil
+	t.Cleanup(func() { p = savedProcess })
+	return folder
+}
+
+func TestUpdateGeoAssets(t *testing.T) {
+	settings := initTestDB(t)
+	folder := initGeoTest(t)
+
+	assets := map[string][]byte{"/geoip.dat": []byte("geoip data"), "/geosite.dat": []byte("geosite data")}
+	sums := map[string]string{}
+	for path, data := range assets {
+		sum := sha256.Sum256(data)
+		sums[path+".sha256sum"] = hex.EncodeToString(sum[:]) + "  " + path[1:] + "\n"
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if data, ok := assets[r.URL.Path]; ok {
+			w.Write(data)
+		} else if sum, ok := sums[r.URL.Path]; ok {
+			w.Write([]byte(sum))
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	s := &GeoService{}
+	update := func(geoIpUrl string, geoSiteUrl string) error {
+		settings.GeoIpUrl = geoIpUrl
+		settings.GeoSiteUrl = geoSiteUrl
+		config.SetSettings(settings)
+		return s.UpdateAssets()
+	}
+	err := update(server.URL+"/geoip.dat", server.URL+"/geosite.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{GeoIpFile, GeoSiteFile} {
+		data, err := os.ReadFile(filepath.Join(folder, file))
+		if err != nil || string(data) != string(assets["/"+file]) {
+			t.Errorf("%s is %q, %v", file, data, err)
+		}
+	}
+
+	// assets are kept when their checksum does not match or is missing
+	assets["/geoip.dat"] = []byte("changed geoip data")
+	err = update(server.URL+"/geoip.dat", "")
+	if err == nil {
+		t.Error("asset with a wrong checksum is saved")
+	}
+	assets["/other.dat"] = []byte("other data")
+	err = update(server.URL+"/other.dat", "")
+	if err == nil {
+		t.Error("asset without a checksum is saved")
+	}
+	data, _ := os.ReadFile(filepath.Join(folder, GeoIpFile))
+	if string(data) != "geoip data" {
+		t.Errorf("geoip.dat is replaced by %q", data)
+	}
+}
+
+func TestCompileGeoLists(t *testing.T) {
+	initTestDB(t)
+	folder := initGeoTest(t)
+	s := &GeoService{}
+
+	for _, list := range []*model.GeoList{
+		{Name: "ads", Type: model.GeoListDomain, Entries: `["example.com","full:ads.example.com","keyword:track","regexp:^ad[0-9]+\\."]`},
+		{Name: "office", Type: model.GeoListIp, Entries: `["10.0.0.0/8","192.168.1.1","2001:db8::1"]`},
+	} {
+		err := s.SaveList(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, list := range []*model.GeoList{
+		{Name: "bad name", Type: model.GeoListDomain, Entries: `[]`},
+		{Name: "bad", Type: "country", Entries: `[]`},
+		{Name: "bad", Type: model.GeoListDomain, Entries: `"example.com"`},
+		{Name: "bad", Type: model.GeoListDomain, Entries: `["geosite:cn"]`},
+		{Name: "bad", Type: model.GeoListDomain, Entries: `["regexp:("]`},
+		{Name: "bad", Type: model.GeoListIp, Entries: `["10.0.0.300"]`},
+	} {
+		err := s.SaveList(list)
+		if err == nil {
+			t.Errorf("invalid list %+v is saved", list)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(folder, CustomGeoSiteFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	siteList := &router.GeoSiteList{}
+	err = proto.Unmarshal(data, siteList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(siteList.Entry) != 1 || siteList.Entry[0].CountryCode != "ADS" {
+		t.Fatalf("site lists %v", siteList.Entry)
+	}
+	wantDomains := []*router.Domain{
+		{Type: router.Domain_Domain, Value: "example.com"},
+		{Type: router.Domain_Full, Value: "ads.example.com"},
+		{Type: router.Domain_Plain, Value: "track"},
+		{Type: router.Domain_Regex, Value: `^ad[0-9]+\.`},
+	}
+	domains := siteList.Entry[0].Domain
+	if len(domains) != len(wantDomains) {
+		t.Fatalf("domains %v", domains)
+	}
+	for index, want := range wantDomains {
+		if domains[index].Type != want.Type || domains[index].Value != want.Value {
+			t.Errorf("domain %d is %v, want %v", index, domains[index], want)
+		}
+	}
+
+	data, err = os.ReadFile(filepath.Join(folder, CustomGeoIpFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipList := &router.GeoIPList{}
+	err = proto.Unmarshal(data, ipList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ipList.Entry) != 1 || ipList.Entry[0].CountryCode != "OFFICE" || len(ipList.Entry[0].Cidr) != 3 {
+		t.Fatalf("ip lists %v", ipList.Entry)
+	}
+	for index, want := range []uint32{8, 32, 128} {
+		cidr := ipList.Entry[0].Cidr[index]
+		if cidr.Prefix != want {
+			t.Errorf("cidr %d has prefix %d, want %d", index, cidr.Prefix, want)
+		}
+	}
+	if len(ipList.Entry[0].Cidr[1].Ip) != 4 {
+		t.Errorf("ipv4 address has %d bytes", len(ipList.Entry[0].Cidr[1].Ip))
+	}
+
+	// deleted lists are removed from the files
+	err = s.DelList(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(filepath.Join(folder, CustomGeoSiteFile))
+	siteList = &router.GeoSiteList{}
+	proto.Unmarshal(data, siteList)
+	if len(siteList.Entry) != 0 {
+		t.Errorf("deleted list is compiled: %v", siteList.Entry)
+	}
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"raha-xray/config"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/logger"
+	"raha-xray/util/common"
+	"raha-xray/util/json_util"
+	"raha-xray/xray"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// only one health check runs at a time
+var healthLock sync.Mutex
+
+type HealthService struct {
+	OutboundService
+	SettingService
+}
+
+// Check probes outbounds with the given ids, or all enabled outbounds if no id is given, and records the results
+func (s *HealthService) Check(ids ...uint) ([]*model.OutboundCheck, error) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+
+	appConfig := config.GetSettings()
+	if appConfig.HealthCheckUrl == "" {
+		return nil, common.NewValidationError("health check url is not configured")
+	}
+	timeout := time.Duration(appConfig.HealthCheckTimeout) * time.Second
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	// All outbounds are loaded to keep chains working
+	xrayConfig, err := s.SettingService.GetXrayDefault()
+	if err != nil {
+		return nil, err
+	}
+	outboundConfigs := append([]json_util.RawMessage{}, xrayConfig.OutboundConfigs...)
+	outbounds, err := s.OutboundService.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, outbound := range outbounds {
+		outboundJSON, err := s.OutboundService.GetOutboundConfig(outbound)
+		if err != nil {
+			return nil, err
+		}
+		outboundConfigs = append(outboundConfigs, *outboundJSON)
+		// disabled outbounds are probed only on request
+		if (len(ids) == 0 && outbound.Enable) || containsId(ids, outbound.Id) {
+			tags = append(tags, outbound.Tag)
+		}
+	}
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	results, err := xray.ProbeOutbounds(outboundConfigs, tags, appConfig.HealthCheckUrl, timeout)
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	err = db.Create(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		if !result.Success {
+			logger.Debug("Outbound health check failed:", result.Tag, result.Error)
+		}
+	}
+	return results, nil
+}
+
+func containsId(ids []uint, id uint) bool {
+	for _, item := range ids {
+		if item == id {
+			return true
+		}
+	}
+	return false
+}
+
+// GetLatest returns the last check of each outbound by tag
+func (s *HealthService) GetLatest() (map[string]*model.OutboundCheck, error) {
+	db := database.GetDB()
+	var checks []*model.OutboundCheck
+	err := db.Model(model.OutboundCheck{}).
+		Where("id IN (?)", db.Model(model.OutboundCheck{}).Select("MAX(id)").Group("tag")).
+		Find(&checks).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	latest := make(map[string]*model.OutboundCheck, len(checks))
+	for _, check := range checks {
+		latest[check.Tag] = check
+	}
+	return latest, nil
+}
+
+// GetAllWithHealth returns outbounds with their last check
+func (s *HealthService) GetAllWithHealth() ([]*model.Outbound, error) {
+	outbounds, err := s.OutboundService.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	latest, err := s.GetLatest()
+	if err != nil {
+		return nil, err
+	}
+	for _, outbound := range outbounds {
+		outbound.Health = latest[outbound.Tag]
+	}
+	return outbounds, nil
+}
+
+// ListWithHealth returns a page of outbounds with their last health check
+// OutboundListQuery lists outbounds with their last check if health is set
+type OutboundListQuery struct {
+	ListQuery
+	Health bool `form:"health"`
+}
+
+func (s *HealthService) ListWithHealth(query *ListQuery) ([]*model.Outbound, int64, error) {
+	outbounds, total, err := s.OutboundService.List(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	latest, err := s.GetLatest()
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, outbound := range outbounds {
+		outbound.Health = latest[outbound.Tag]
+	}
+	return outbounds, total, nil
+}
+
+func (s *HealthService) GetHistory(tag string, count int) ([]*model.OutboundCheck, error) {
+	db := database.GetDB()
+	var checks []*model.OutboundCheck
+	err := db.Model(model.OutboundCheck{}).Where("tag = ?", tag).Order("id desc").Limit(count).Find(&checks).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return checks, nil
+}
+
+func (s *HealthService) DelOldChecks(days int) int64 {
+	db := database.GetDB()
+	dateTimeThreshold := time.Now().AddDate(0, 0, -days).UnixMilli()
+	result := db.Where("date_time < ?", dateTimeThreshold).Delete(model.OutboundCheck{})
+	if result.Error != nil {
+		logger.Debug("Unable to delete old outbound checks", result.Error)
+		return 0
+	}
+	return result.RowsAffected
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"raha-xray/config"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"testing"
+)
+
+func TestHealthCheck(t *testing.T) {
+	settings := initTestDB(t)
+	savedDefault := xrayDefault
+	xrayDefault = config.GetDefaultXrayConfig()
+	t.Cleanup(func() { xrayDefault = savedDefault })
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	settings.HealthCheckUrl = server.URL
+	config.SetSettings(settings)
+	s := &HealthService{}
+
+	disabled := &model.Outbound{Protocol: "freedom", Tag: "disabled"}
+	err := database.GetDB().Create([]*model.Outbound{{Enable: true, Protocol: "freedom", Tag: "enabled"}, disabled}).Error
+	if err == nil {
+		err = database.GetDB().Model(disabled).Update("enable", false).Error
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(want string, ids ...uint) {
+		t.Helper()
+		checks, err := s.Check(ids...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(checks) != 1 || checks[0].Tag != want || !checks[0].Success {
+			t.Fatalf("checks %+v, want a successful check of %s", checks, want)
+		}
+	}
+	// disabled outbounds are checked only by id
+	check("enabled")
+	check("disabled", disabled.Id)
+
+	outbounds, _, err := s.ListWithHealth(&ListQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, outbound := range outbounds {
+		if outbound.Health == nil || outbound.Health.Tag != outbound.Tag {
+			t.Errorf("outbound %s has health %+v", outbound.Tag, outbound.Health)
+		}
+	}
+}
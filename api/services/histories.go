@@ -0,0 +1,53 @@
+package services
+
+import (
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type HistoryService struct {
+}
+
+func (s *HistoryService) GetByClient(clientId uint) ([]*model.ClientHistory, error) {
+	db := database.GetDB()
+	var histories []*model.ClientHistory
+	err := db.Model(model.ClientHistory{}).Where("client_id = ?", clientId).Order("id desc").Find(&histories).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return histories, nil
+}
+
+// Record stores the current usage period of client before it is wiped.
+// The period starts where the previous record of the client ended,
+// the first one starts with the first usage of the client or its creation.
+func (s *HistoryService) Record(tx *gorm.DB, client *model.Client, reason string) error {
+	var start uint64
+	err := tx.Model(model.ClientHistory{}).Select("COALESCE(MAX(end_at), 0)").Where("client_id = ?", client.Id).Scan(&start).Error
+	if err != nil {
+		return err
+	}
+	if start == 0 {
+		start = client.FirstUsed
+	}
+	if start == 0 {
+		start = client.CreatedAt
+	}
+	history := &model.ClientHistory{
+		ClientId: client.Id,
+		StartAt:  start,
+		EndAt:    uint64(time.Now().UnixMilli()),
+		Up:       client.Up,
+		Down:     client.Down,
+		Quota:    client.Quota,
+		Reason:   reason,
+	}
+	return tx.Create(history).Error
+}
+
+func (s *HistoryService) DelByClient(tx *gorm.DB, clientId uint) error {
+	return tx.Where("client_id = ?", clientId).Delete(model.ClientHistory{}).Error
+}
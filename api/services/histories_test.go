@@ -0,0 +1,67 @@
+package services
+
+import (
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/xray"
+	"testing"
+)
+
+func TestHistoryPeriods(t *testing.T) {
+	initTestDB(t)
+	savedProcess := p
+	p = xray.NewProcess(&xray.Config{})
+	t.Cleanup(func() { p = savedProcess })
+	db := database.GetDB()
+
+	clientService := &ClientService{}
+	err, _ := clientService.Add([]*model.Client{{Name: "new"}, {Name: "used"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Model(model.Client{}).Where("name = ?", "used").Update("first_used", 1000).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// clients of previous versions have no creation time
+	err = db.Exec("INSERT INTO clients (name, enable) VALUES ('old', true)").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := clientService.GetByName("old")
+	if err != nil || old.CreatedAt != 0 {
+		t.Fatalf("old client %+v, %v", old, err)
+	}
+
+	s := &HistoryService{}
+	for name, want := range map[string]uint64{"new": 0, "used": 1000} {
+		client, err := clientService.GetByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client.CreatedAt == 0 {
+			t.Fatalf("client %s has no creation time", name)
+		}
+		if want == 0 {
+			want = client.CreatedAt
+		}
+		for i := 0; i < 2; i++ {
+			err = s.Record(db, client, model.HistoryManual)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		histories, err := s.GetByClient(client.Id)
+		if err != nil || len(histories) != 2 {
+			t.Fatalf("histories of %s %v, %v", name, histories, err)
+		}
+		// histories are newest first, the first period starts with the first usage or the creation
+		if histories[1].StartAt != want {
+			t.Errorf("first period of %s starts at %d, want %d", name, histories[1].StartAt, want)
+		}
+		if histories[0].StartAt != histories[1].EndAt {
+			t.Errorf("second period of %s starts at %d, want %d", name, histories[0].StartAt, histories[1].EndAt)
+		}
+	}
+}
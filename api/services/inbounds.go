@@ -5,6 +5,7 @@ import (
 	"raha-xray/database"
 	"raha-xray/database/model"
 	"raha-xray/logger"
+	"raha-xray/util/common"
 	"raha-xray/util/json_util"
 	"raha-xray/xray"
 
@@ -25,6 +26,30 @@ func (s *InboundService) GetAll() ([]*model.Inbound, error) {
 	return inbounds, nil
 }
 
+var inboundListSpec = &listSpec{
+	sorts:       map[string]string{"id": "id", "name": "name", "enable": "enable", "tag": "tag", "port": "port", "nodeId": "node_id"},
+	defaultSort: "id",
+	nameColumns: []string{"name", "tag"},
+	enable:      true,
+}
+
+// List returns a page of inbounds matching the query and the count of all matching inbounds
+func (s *InboundService) List(query *ListQuery) ([]*model.Inbound, int64, error) {
+	db := database.GetDB()
+	var inbounds []*model.Inbound
+	total, err := query.find(db.Model(model.Inbound{}), inboundListSpec, &inbounds, func(tx *gorm.DB) *gorm.DB {
+		tx = tx.Preload("Config")
+		if query.NoPreload {
+			return tx
+		}
+		return tx.Preload("ClientInbounds")
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return inbounds, total, nil
+}
+
 func (s *InboundService) Get(id int) (*model.Inbound, error) {
 	db := database.GetDB()
 	var inbound *model.Inbound
@@ -39,6 +64,16 @@ func (s *InboundService) Get(id int) (*model.Inbound, error) {
 }
 
 func (s *InboundService) Save(inbound *model.Inbound) (error, bool) {
+	return s.save(inbound, false)
+}
+
+// Create adds a new inbound, unlike Save it keeps a false enable instead of the default value
+func (s *InboundService) Create(inbound *model.Inbound) (error, bool) {
+	inbound.Id = 0
+	return s.save(inbound, true)
+}
+
+func (s *InboundService) save(inbound *model.Inbound, keepEnable bool) (error, bool) {
 	var err, err1 error
 	db := database.GetDB()
 	tx := db.Begin()
@@ -52,16 +87,48 @@ func (s *InboundService) Save(inbound *model.Inbound) (error, bool) {
 
 	needRestart := false
 
-	// Remove old inbound with API
+	if inbound.Id > 0 {
+		var oldTag string
+		err = tx.Model(model.Inbound{}).Select("tag").Where("id = ?", inbound.Id).Scan(&oldTag).Error
+		if err != nil {
+			return err, false
+		}
+		if oldTag != inbound.Tag {
+			var used bool
+			used, err = reverseUsesInbound(tx, oldTag)
+			if err != nil {
+				return err, false
+			}
+			if used {
+				err = common.NewValidationError("inbound is used by a reverse portal, it can not be renamed:", oldTag)
+				return err, false
+			}
+		}
+	}
+
+	if inbound.NodeId > 0 {
+		var count int64
+		err = tx.Model(model.Node{}).Where("id = ?", inbound.NodeId).Count(&count).Error
+		if err != nil {
+			return err, false
+		}
+		if count == 0 {
+			err = common.NewValidationError("node not found:", inbound.NodeId)
+			return err, false
+		}
+	}
+
+	// Remove old inbound with API, inbounds of nodes are not in the local xray
 	if inbound.Id > 0 {
 		err1 = s.XrayAPI.Init(p.GetAPIServer())
 		if err1 == nil {
-			var inboundTag string
-			err1 = tx.Model(model.Inbound{}).Select("tag").Where("id = ?", inbound.Id).Find(&inboundTag).Error
+			var oldInbound model.Inbound
+			err1 = tx.Model(model.Inbound{}).Select("tag", "node_id").Where("id = ?", inbound.Id).Find(&oldInbound).Error
 			if err1 != nil {
 				logger.Debug("Failed to find inbound data to removing by API:", err1)
 				needRestart = true
-			} else {
+			} else if oldInbound.NodeId == 0 {
+				inboundTag := oldInbound.Tag
 				err1 = s.XrayAPI.DelInbound(inboundTag)
 				if err1 == nil {
 					logger.Debug("Inbound deleted by api:", inboundTag)
@@ -74,10 +141,18 @@ func (s *InboundService) Save(inbound *model.Inbound) (error, bool) {
 		s.XrayAPI.Close()
 	}
 
+	enable := inbound.Enable
 	err = tx.Save(inbound).Error
 	if err != nil {
 		return err, false
 	}
+	if keepEnable && !enable {
+		// enable has a default value, so it is skipped on create when false
+		err = tx.Model(inbound).Update("enable", false).Error
+		if err != nil {
+			return err, false
+		}
+	}
 
 	if !needRestart {
 		err1 = s.RebuildByApi(tx, []uint{inbound.Id}, false)
@@ -90,6 +165,51 @@ func (s *InboundService) Save(inbound *model.Inbound) (error, bool) {
 	return nil, needRestart
 }
 
+// InboundPatch has the fields of an inbound which can be changed, nil fields are kept
+type InboundPatch struct {
+	Name     *string `json:"name"`
+	Enable   *bool   `json:"enable"`
+	Listen   *string `json:"listen"`
+	Port     *uint   `json:"port"`
+	ConfigId *uint   `json:"configId"`
+	Tag      *string `json:"tag"`
+	NodeId   *uint   `json:"nodeId"`
+}
+
+// Patch changes the fields of an inbound which are set in the patch, its clients are kept
+func (s *InboundService) Patch(id uint, patch *InboundPatch) (error, bool) {
+	inbound, err := s.Get(int(id))
+	if err != nil {
+		return err, false
+	}
+	// associations are saved separately
+	inbound.Config = model.Config{}
+	inbound.ClientInbounds = nil
+
+	if patch.Name != nil {
+		inbound.Name = *patch.Name
+	}
+	if patch.Enable != nil {
+		inbound.Enable = *patch.Enable
+	}
+	if patch.Listen != nil {
+		inbound.Listen = *patch.Listen
+	}
+	if patch.Port != nil {
+		inbound.Port = *patch.Port
+	}
+	if patch.ConfigId != nil {
+		inbound.ConfigId = *patch.ConfigId
+	}
+	if patch.Tag != nil {
+		inbound.Tag = *patch.Tag
+	}
+	if patch.NodeId != nil {
+		inbound.NodeId = *patch.NodeId
+	}
+	return s.Save(inbound)
+}
+
 func (s *InboundService) RebuildByApi(tx *gorm.DB, ids []uint, delFirst bool) error {
 	var err error
 	var inbounds []*model.Inbound
@@ -97,7 +217,7 @@ func (s *InboundService) RebuildByApi(tx *gorm.DB, ids []uint, delFirst bool) er
 	err = tx.Model(model.Inbound{}).
 		Preload("Config").
 		Preload("ClientInbounds", "client_id NOT IN (select Id from clients where enable=false)").
-		Where("enable = true and id in ?", ids).Find(&inbounds).Error
+		Where("enable = true and node_id = 0 and id in ?", ids).Find(&inbounds).Error
 	if err != nil {
 		return err
 	}
@@ -174,7 +294,7 @@ func (s *InboundService) GetXrayInboundConfigs() ([]xray.InboundConfig, error) {
 	err = db.Model(model.Inbound{}).
 		Preload("Config").
 		Preload("ClientInbounds", "client_id NOT IN (select Id from clients where enable=false)").
-		Where("enable = true").Find(&inbounds).Error
+		Where("enable = true and node_id = 0").Find(&inbounds).Error
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
@@ -189,6 +309,31 @@ func (s *InboundService) GetXrayInboundConfigs() ([]xray.InboundConfig, error) {
 	return inboundConfigs, nil
 }
 
+// HasTag checks inbounds of database and default xray config
+func (s *InboundService) HasTag(tag string) (bool, error) {
+	db := database.GetDB()
+	var count int64
+	err := db.Model(model.Inbound{}).Where("tag = ?", tag).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	settingService := SettingService{}
+	xrayConfig, err := settingService.GetXrayDefault()
+	if err != nil {
+		return false, err
+	}
+	for _, inbound := range xrayConfig.InboundConfigs {
+		if inbound.Tag == tag {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (s *InboundService) Del(id uint) (error, bool) {
 	var err, err1 error
 	db := database.GetDB()
@@ -202,16 +347,32 @@ func (s *InboundService) Del(id uint) (error, bool) {
 		}
 	}()
 
+	var oldTag string
+	err = tx.Model(model.Inbound{}).Select("tag").Where("id = ?", id).Scan(&oldTag).Error
+	if err != nil {
+		return err, false
+	}
+	var used bool
+	used, err = reverseUsesInbound(tx, oldTag)
+	if err != nil {
+		return err, false
+	}
+	if used {
+		err = common.NewValidationError("inbound is used by a reverse portal:", oldTag)
+		return err, false
+	}
+
 	needRestart := false
 	err1 = s.XrayAPI.Init(p.GetAPIServer())
 	if err1 != nil {
 		needRestart = true
 	} else {
-		var inboundTag string
-		err1 = tx.Model(model.Inbound{}).Select("tag").Where("id = ?", id).Scan(&inboundTag).Error
+		var oldInbound model.Inbound
+		err1 = tx.Model(model.Inbound{}).Select("tag", "node_id").Where("id = ?", id).Find(&oldInbound).Error
 		if err1 != nil {
 			needRestart = true
-		} else {
+		} else if oldInbound.NodeId == 0 {
+			inboundTag := oldInbound.Tag
 			err1 = s.XrayAPI.DelInbound(inboundTag)
 			if err1 == nil {
 				logger.Debug("Inbound deleted by api:", inboundTag)
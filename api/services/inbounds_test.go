@@ -0,0 +1,48 @@
+package services
+
+import (
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/xray"
+	"testing"
+)
+
+func TestInboundEnableOnCreate(t *testing.T) {
+	initTestDB(t)
+	savedProcess := p
+	p = xray.NewProcess(&xray.Config{})
+	t.Cleanup(func() { p = savedProcess })
+	s := &InboundService{}
+
+	// v1 inbounds without enable are enabled by the default value
+	saved := &model.Inbound{Tag: "a", Port: 1001}
+	err, _ := s.Save(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := &model.Inbound{Tag: "b", Port: 1002}
+	err, _ = s.Create(created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Enable {
+		t.Error("created inbound is enabled")
+	}
+
+	var enables []bool
+	err = database.GetDB().Model(model.Inbound{}).Order("id").Pluck("enable", &enables).Error
+	if err != nil || len(enables) != 2 || !enables[0] || enables[1] {
+		t.Errorf("enable of inbounds %v, %v", enables, err)
+	}
+
+	// updates of v1 save what they have, like before
+	saved.Enable = false
+	err, _ = s.Save(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbound, err := s.Get(int(saved.Id))
+	if err != nil || inbound.Enable {
+		t.Errorf("updated inbound %+v, %v", inbound, err)
+	}
+}
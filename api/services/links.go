@@ -0,0 +1,363 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/url"
+	"raha-xray/database/model"
+	"raha-xray/util/common"
+	"strconv"
+	"strings"
+)
+
+// ShareLink is an outbound parsed from a vless, vmess, trojan or shadowsocks link
+type ShareLink struct {
+	Name     string
+	Outbound *model.Outbound
+}
+
+// ParseShareLinks parses links separated by new lines, the text may be a base64 encoded subscription body
+func ParseShareLinks(text string) ([]*ShareLink, []error) {
+	text = strings.TrimSpace(text)
+	if !strings.Contains(text, "://") {
+		decoded, err := decodeBase64(text)
+		if err == nil {
+			text = decoded
+		}
+	}
+	var links []*ShareLink
+	var errs []error
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		link, err := ParseShareLink(line)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		links = append(links, link)
+	}
+	return links, errs
+}
+
+func ParseShareLink(link string) (*ShareLink, error) {
+	scheme, _, found := strings.Cut(link, "://")
+	if !found {
+		return nil, common.NewValidationError("not a share link:", link)
+	}
+	switch strings.ToLower(scheme) {
+	case "vmess":
+		return parseVmessLink(link)
+	case "vless", "trojan":
+		return parseUrlLink(link)
+	case "ss":
+		return parseShadowsocksLink(link)
+	}
+	return nil, common.NewValidationError("unsupported share link scheme:", scheme)
+}
+
+func decodeBase64(data string) (string, error) {
+	data = strings.TrimSpace(data)
+	data = strings.NewReplacer("\r", "", "\n", "").Replace(data)
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		decoded, err := encoding.DecodeString(data)
+		if err == nil {
+			return string(decoded), nil
+		}
+	}
+	return "", common.NewValidationError("invalid base64 data")
+}
+
+func parseVmessLink(link string) (*ShareLink, error) {
+	decoded, err := decodeBase64(link[len("vmess://"):])
+	if err != nil {
+		return nil, common.NewValidationError("invalid vmess link:", err)
+	}
+	var vmess map[string]interface{}
+	err = json.Unmarshal([]byte(decoded), &vmess)
+	if err != nil {
+		return nil, common.NewValidationError("invalid vmess link:", err)
+	}
+	get := func(key string) string {
+		switch value := vmess[key].(type) {
+		case string:
+			return value
+		case float64:
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		return ""
+	}
+
+	port, err := strconv.Atoi(get("port"))
+	if err != nil {
+		return nil, common.NewValidationError("invalid vmess port:", get("port"))
+	}
+	alterId, _ := strconv.Atoi(get("aid"))
+	security := get("scy")
+	if security == "" {
+		security = "auto"
+	}
+	settings := map[string]interface{}{
+		"vnext": []interface{}{map[string]interface{}{
+			"address": get("add"),
+			"port":    port,
+			"users": []interface{}{map[string]interface{}{
+				"id":       get("id"),
+				"alterId":  alterId,
+				"security": security,
+			}},
+		}},
+	}
+
+	params := url.Values{}
+	params.Set("type", get("net"))
+	params.Set("headerType", get("type"))
+	params.Set("host", get("host"))
+	params.Set("path", get("path"))
+	params.Set("security", get("tls"))
+	params.Set("sni", get("sni"))
+	params.Set("alpn", get("alpn"))
+	params.Set("fp", get("fp"))
+	if get("net") == "grpc" {
+		params.Set("serviceName", get("path"))
+		params.Set("mode", get("type"))
+	}
+	return newShareLink(get("ps"), "vmess", settings, params)
+}
+
+func parseUrlLink(link string) (*ShareLink, error) {
+	linkUrl, err := url.Parse(link)
+	if err != nil {
+		return nil, common.NewValidationError("invalid share link:", err)
+	}
+	protocol := strings.ToLower(linkUrl.Scheme)
+	port, err := strconv.Atoi(linkUrl.Port())
+	if err != nil {
+		return nil, common.NewValidationError("invalid share link port:", linkUrl.Port())
+	}
+	params := linkUrl.Query()
+	user := linkUrl.User.Username()
+
+	var settings map[string]interface{}
+	if protocol == "vless" {
+		encryption := params.Get("encryption")
+		if encryption == "" {
+			encryption = "none"
+		}
+		vlessUser := map[string]interface{}{
+			"id":         user,
+			"encryption": encryption,
+		}
+		if flow := params.Get("flow"); flow != "" {
+			vlessUser["flow"] = flow
+		}
+		settings = map[string]interface{}{
+			"vnext": []interface{}{map[string]interface{}{
+				"address": linkUrl.Hostname(),
+				"port":    port,
+				"users":   []interface{}{vlessUser},
+			}},
+		}
+	} else {
+		// trojan uses tls by default
+		if params.Get("security") == "" {
+			params.Set("security", "tls")
+		}
+		settings = map[string]interface{}{
+			"servers": []interface{}{map[string]interface{}{
+				"address":  linkUrl.Hostname(),
+				"port":     port,
+				"password": user,
+			}},
+		}
+	}
+	return newShareLink(linkUrl.Fragment, protocol, settings, params)
+}
+
+func parseShadowsocksLink(link string) (*ShareLink, error) {
+	body, name, _ := strings.Cut(link[len("ss://"):], "#")
+	name, _ = url.PathUnescape(name)
+	body, query, _ := strings.Cut(body, "?")
+
+	// legacy links encode the whole method:password@host:port
+	if !strings.Contains(body, "@") {
+		decoded, err := decodeBase64(body)
+		if err != nil {
+			return nil, common.NewValidationError("invalid shadowsocks link:", err)
+		}
+		body = decoded
+	}
+	userInfo, address, found := strings.Cut(body, "@")
+	if !found {
+		return nil, common.NewValidationError("invalid shadowsocks link address")
+	}
+	if decoded, err := decodeBase64(userInfo); err == nil && strings.Contains(decoded, ":") {
+		userInfo = decoded
+	} else if unescaped, err := url.PathUnescape(userInfo); err == nil {
+		userInfo = unescaped
+	}
+	method, password, found := strings.Cut(userInfo, ":")
+	if !found {
+		return nil, common.NewValidationError("invalid shadowsocks link user info")
+	}
+	host, portStr, err := net.SplitHostPort(strings.TrimSuffix(address, "/"))
+	if err != nil {
+		return nil, common.NewValidationError("invalid shadowsocks link address:", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, common.NewValidationError("invalid shadowsocks link port:", portStr)
+	}
+	settings := map[string]interface{}{
+		"servers": []interface{}{map[string]interface{}{
+			"address":  host,
+			"port":     port,
+			"method":   method,
+			"password": password,
+		}},
+	}
+	params, _ := url.ParseQuery(query)
+	if plugin := params.Get("plugin"); plugin != "" {
+		return nil, common.NewValidationError("shadowsocks plugins are not supported:", plugin)
+	}
+	return newShareLink(name, "shadowsocks", settings, url.Values{})
+}
+
+func newShareLink(name string, protocol string, settings map[string]interface{}, params url.Values) (*ShareLink, error) {
+	streamSettings, err := getLinkStreamSettings(params)
+	if err != nil {
+		return nil, err
+	}
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	outbound := &model.Outbound{
+		Enable:   true,
+		Protocol: protocol,
+		Settings: string(settingsJSON),
+	}
+	if streamSettings != nil {
+		streamJSON, err := json.Marshal(streamSettings)
+		if err != nil {
+			return nil, err
+		}
+		outbound.StreamSettings = string(streamJSON)
+	}
+	return &ShareLink{Name: strings.TrimSpace(name), Outbound: outbound}, nil
+}
+
+// getLinkStreamSettings converts common share link parameters to xray stream settings
+func getLinkStreamSettings(params url.Values) (map[string]interface{}, error) {
+	network := params.Get("type")
+	security := params.Get("security")
+	if (network == "" || network == "tcp") && (security == "" || security == "none") && params.Get("headerType") != "http" {
+		return nil, nil
+	}
+	if network == "" {
+		network = "tcp"
+	}
+	streamSettings := map[string]interface{}{}
+	path := params.Get("path")
+	host := params.Get("host")
+	headerType := params.Get("headerType")
+
+	switch network {
+	case "tcp":
+		if headerType == "http" {
+			request := map[string]interface{}{}
+			if path != "" {
+				request["path"] = strings.Split(path, ",")
+			}
+			if host != "" {
+				request["headers"] = map[string]interface{}{"Host": strings.Split(host, ",")}
+			}
+			streamSettings["tcpSettings"] = map[string]interface{}{
+				"header": map[string]interface{}{"type": "http", "request": request},
+			}
+		}
+	case "ws":
+		wsSettings := map[string]interface{}{"path": path}
+		if host != "" {
+			wsSettings["headers"] = map[string]interface{}{"Host": host}
+		}
+		streamSettings["wsSettings"] = wsSettings
+	case "grpc":
+		streamSettings["grpcSettings"] = map[string]interface{}{
+			"serviceName": params.Get("serviceName"),
+			"multiMode":   params.Get("mode") == "multi",
+		}
+	case "http", "h2":
+		network = "http"
+		httpSettings := map[string]interface{}{"path": path}
+		if host != "" {
+			httpSettings["host"] = strings.Split(host, ",")
+		}
+		streamSettings["httpSettings"] = httpSettings
+	case "httpupgrade":
+		streamSettings["httpupgradeSettings"] = map[string]interface{}{"path": path, "host": host}
+	case "splithttp":
+		streamSettings["splithttpSettings"] = map[string]interface{}{"path": path, "host": host}
+	case "kcp", "mkcp":
+		network = "kcp"
+		kcpSettings := map[string]interface{}{}
+		if headerType != "" {
+			kcpSettings["header"] = map[string]interface{}{"type": headerType}
+		}
+		if seed := params.Get("seed"); seed != "" {
+			kcpSettings["seed"] = seed
+		}
+		streamSettings["kcpSettings"] = kcpSettings
+	case "quic":
+		quicSettings := map[string]interface{}{
+			"security": params.Get("quicSecurity"),
+			"key":      params.Get("key"),
+		}
+		if headerType != "" {
+			quicSettings["header"] = map[string]interface{}{"type": headerType}
+		}
+		streamSettings["quicSettings"] = quicSettings
+	default:
+		return nil, common.NewValidationError("unsupported share link network:", network)
+	}
+	streamSettings["network"] = network
+
+	switch security {
+	case "", "none":
+	case "tls":
+		tlsSettings := map[string]interface{}{}
+		if sni := params.Get("sni"); sni != "" {
+			tlsSettings["serverName"] = sni
+		}
+		if fp := params.Get("fp"); fp != "" {
+			tlsSettings["fingerprint"] = fp
+		}
+		if alpn := params.Get("alpn"); alpn != "" {
+			tlsSettings["alpn"] = strings.Split(alpn, ",")
+		}
+		if params.Get("allowInsecure") == "1" || params.Get("allowInsecure") == "true" {
+			tlsSettings["allowInsecure"] = true
+		}
+		streamSettings["security"] = "tls"
+		streamSettings["tlsSettings"] = tlsSettings
+	case "reality":
+		realitySettings := map[string]interface{}{
+			"serverName":  params.Get("sni"),
+			"fingerprint": params.Get("fp"),
+			"publicKey":   params.Get("pbk"),
+			"shortId":     params.Get("sid"),
+			"spiderX":     params.Get("spx"),
+		}
+		if realitySettings["fingerprint"] == "" {
+			realitySettings["fingerprint"] = "chrome"
+		}
+		streamSettings["security"] = "reality"
+		streamSettings["realitySettings"] = realitySettings
+	default:
+		return nil, common.NewValidationError("unsupported share link security:", security)
+	}
+	return streamSettings, nil
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lookupJSON returns the value of a dotted path like "vnext.0.port" in a JSON document
+func lookupJSON(t *testing.T, data string, path string) interface{} {
+	t.Helper()
+	var value interface{}
+	if data == "" {
+		return nil
+	}
+	err := json.Unmarshal([]byte(data), &value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch current := value.(type) {
+		case map[string]interface{}:
+			value = current[key]
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index >= len(current) {
+				return nil
+			}
+			value = current[index]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+func TestParseShareLink(t *testing.T) {
+	vmessJSON := `{"v":"2","ps":"vm","add":"vm.example.com","port":"443","id":"11111111-1111-1111-1111-111111111111","aid":"0",` +
+		`"net":"ws","type":"none","host":"cdn.example.com","path":"/ws","tls":"tls","sni":"vm.example.com"}`
+	vmessGrpcJSON := `{"ps":"vm-grpc","add":"1.2.3.4","port":8443,"id":"22222222-2222-2222-2222-222222222222",` +
+		`"net":"grpc","type":"multi","path":"service","tls":"tls"}`
+	tests := []struct {
+		name     string
+		link     string
+		protocol string
+		linkName string
+		// values of dotted paths in settings and stream settings, a nil value means the path is missing
+		settings map[string]interface{}
+		stream   map[string]interface{}
+		invalid  bool
+	}{
+		{
+			name:     "vmess",
+			link:     "vmess://" + base64.StdEncoding.EncodeToString([]byte(vmessJSON)),
+			protocol: "vmess",
+			linkName: "vm",
+			settings: map[string]interface{}{
+				"vnext.0.address":          "vm.example.com",
+				"vnext.0.port":             float64(443),
+				"vnext.0.users.0.id":       "11111111-1111-1111-1111-111111111111",
+				"vnext.0.users.0.security": "auto",
+			},
+			stream: map[string]interface{}{
+				"network":                 "ws",
+				"wsSettings.path":         "/ws",
+				"wsSettings.headers.Host": "cdn.example.com",
+				"security":                "tls",
+				"tlsSettings.serverName":  "vm.example.com",
+			},
+		},
+		{
+			name:     "vmess grpc with url base64 and numeric port",
+			link:     "vmess://" + base64.RawURLEncoding.EncodeToString([]byte(vmessGrpcJSON)),
+			protocol: "vmess",
+			linkName: "vm-grpc",
+			settings: map[string]interface{}{"vnext.0.port": float64(8443)},
+			stream: map[string]interface{}{
+				"network":                  "grpc",
+				"grpcSettings.serviceName": "service",
+				"grpcSettings.multiMode":   true,
+			},
+		},
+		{
+			name:     "vless tcp",
+			link:     "vless://33333333-3333-3333-3333-333333333333@vl.example.com:443?flow=xtls-rprx-vision#plain%20vless",
+			protocol: "vless",
+			linkName: "plain vless",
+			settings: map[string]interface{}{
+				"vnext.0.address":            "vl.example.com",
+				"vnext.0.users.0.encryption": "none",
+				"vnext.0.users.0.flow":       "xtls-rprx-vision",
+			},
+			stream: map[string]interface{}{"network": nil},
+		},
+		{
+			name: "vless reality",
+			link: "vless://44444444-4444-4444-4444-444444444444@1.2.3.4:443?type=tcp&security=reality&sni=www.example.com" +
+				"&pbk=publickey&sid=ab12&flow=xtls-rprx-vision#reality",
+			protocol: "vless",
+			linkName: "reality",
+			stream: map[string]interface{}{
+				"network":                     "tcp",
+				"security":                    "reality",
+				"realitySettings.serverName":  "www.example.com",
+				"realitySettings.publicKey":   "publickey",
+				"realitySettings.shortId":     "ab12",
+				"realitySettings.fingerprint": "chrome",
+			},
+		},
+		{
+			name:     "vless grpc",
+			link:     "vless://55555555-5555-5555-5555-555555555555@grpc.example.com:443?type=grpc&serviceName=svc&mode=gun&security=tls&alpn=h2,http/1.1#grpc",
+			protocol: "vless",
+			linkName: "grpc",
+			stream: map[string]interface{}{
+				"network":                  "grpc",
+				"grpcSettings.serviceName": "svc",
+				"grpcSettings.multiMode":   false,
+				"tlsSettings.alpn.1":       "http/1.1",
+			},
+		},
+		{
+			name:     "trojan uses tls by default",
+			link:     "trojan://secret@tr.example.com:443?sni=tr.example.com#trojan",
+			protocol: "trojan",
+			linkName: "trojan",
+			settings: map[string]interface{}{
+				"servers.0.address":  "tr.example.com",
+				"servers.0.port":     float64(443),
+				"servers.0.password": "secret",
+			},
+			stream: map[string]interface{}{
+				"network":                "tcp",
+				"security":               "tls",
+				"tlsSettings.serverName": "tr.example.com",
+			},
+		},
+		{
+			name:     "shadowsocks",
+			link:     "ss://" + base64.RawURLEncoding.EncodeToString([]byte("aes-256-gcm:pass")) + "@ss.example.com:8388#ss%20link",
+			protocol: "shadowsocks",
+			linkName: "ss link",
+			settings: map[string]interface{}{
+				"servers.0.address":  "ss.example.com",
+				"servers.0.port":     float64(8388),
+				"servers.0.method":   "aes-256-gcm",
+				"servers.0.password": "pass",
+			},
+		},
+		{
+			name:     "shadowsocks 2022 with plain user info",
+			link:     "ss://2022-blake3-aes-128-gcm:a2V5@[2001:db8::1]:8388#ss2022",
+			protocol: "shadowsocks",
+			linkName: "ss2022",
+			settings: map[string]interface{}{
+				"servers.0.address":  "2001:db8::1",
+				"servers.0.method":   "2022-blake3-aes-128-gcm",
+				"servers.0.password": "a2V5",
+			},
+		},
+		{
+			name:     "legacy shadowsocks",
+			link:     "ss://" + base64.StdEncoding.EncodeToString([]byte("chacha20-ietf-poly1305:pass@10.0.0.1:443")) + "#legacy",
+			protocol: "shadowsocks",
+			linkName: "legacy",
+			settings: map[string]interface{}{
+				"servers.0.address": "10.0.0.1",
+				"servers.0.port":    float64(443),
+				"servers.0.method":  "chacha20-ietf-poly1305",
+			},
+		},
+		{name: "unknown scheme", link: "hysteria2://pass@host:443", invalid: true},
+		{name: "no scheme", link: "vless-host:443", invalid: true},
+		{name: "vmess without json", link: "vmess://" + base64.StdEncoding.EncodeToString([]byte("not json")), invalid: true},
+		{name: "vmess without port", link: "vmess://" + base64.StdEncoding.EncodeToString([]byte(`{"add":"host"}`)), invalid: true},
+		{name: "vless without port", link: "vless://id@host?type=tcp", invalid: true},
+		{name: "unsupported network", link: "vless://id@host:443?type=unknown", invalid: true},
+		{name: "unsupported security", link: "vless://id@host:443?security=xtls", invalid: true},
+		{name: "shadowsocks plugin", link: "ss://YWVzLTI1Ni1nY206cGFzcw@host:443?plugin=obfs-local#ss", invalid: true},
+		{name: "shadowsocks without address", link: "ss://" + base64.StdEncoding.EncodeToString([]byte("method:pass")), invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			link, err := ParseShareLink(test.link)
+			if test.invalid {
+				if err == nil {
+					t.Fatalf("invalid link is parsed as %+v", link.Outbound)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if link.Name != test.linkName || link.Outbound.Protocol != test.protocol || !link.Outbound.Enable {
+				t.Errorf("link %q is %s %+v", test.link, link.Name, link.Outbound)
+			}
+			for path, want := range test.settings {
+				if got := lookupJSON(t, link.Outbound.Settings, path); got != want {
+					t.Errorf("settings %s is %v, want %v", path, got, want)
+				}
+			}
+			for path, want := range test.stream {
+				if got := lookupJSON(t, link.Outbound.StreamSettings, path); got != want {
+					t.Errorf("stream settings %s is %v, want %v", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseShareLinks(t *testing.T) {
+	text := "vless://id@host:443#one\r\n\r\n  trojan://pass@host:443#two\nbroken://link\n"
+	for name, body := range map[string]string{
+		"plain":      text,
+		"base64":     base64.StdEncoding.EncodeToString([]byte(text)),
+		"url base64": base64.RawURLEncoding.EncodeToString([]byte(text)),
+		// subscription bodies are often wrapped at 76 characters
+		"wrapped base64": func() string {
+			encoded := base64.StdEncoding.EncodeToString([]byte(text))
+			return encoded[:20] + "\r\n" + encoded[20:]
+		}(),
+	} {
+		links, errs := ParseShareLinks(body)
+		if len(links) != 2 || links[0].Name != "one" || links[1].Name != "two" || len(errs) != 1 {
+			t.Errorf("%s body is parsed to %d links and errors %v", name, len(links), errs)
+		}
+	}
+
+	links, errs := ParseShareLinks("not a subscription")
+	if len(links) != 0 || len(errs) != 1 {
+		t.Errorf("invalid body is parsed to %d links and errors %v", len(links), errs)
+	}
+}
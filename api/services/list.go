@@ -0,0 +1,102 @@
+package services
+
+import (
+	"raha-xray/util/common"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ListQuery is the pagination, sorting and filtering of list endpoints.
+// Empty values and filters which a resource does not have are ignored.
+type ListQuery struct {
+	Limit  int    `form:"limit"`
+	Offset int    `form:"offset"` // needs a limit
+	Sort   string `form:"sort"`   // a field name, "-" prefix sorts descending
+
+	Enable    *bool  `form:"enable"`
+	Expired   *bool  `form:"expired"`
+	OverQuota *bool  `form:"overQuota"`
+	InboundId uint   `form:"inboundId"`
+	Name      string `form:"name"`
+	Remark    string `form:"remark"`
+
+	// skips loading clients of inbounds and rules, or inbounds of clients
+	NoPreload bool `form:"noPreload"`
+}
+
+// listSpec is what a resource supports in list queries
+type listSpec struct {
+	sorts       map[string]string // sort field to column or expression
+	defaultSort string
+	nameColumns []string // columns searched by name
+	enable      bool
+}
+
+// find applies filters, counts all matching rows and loads the requested page into dest.
+// Preloads should be added to tx after filters by the prepare function.
+func (q *ListQuery) find(tx *gorm.DB, spec *listSpec, dest interface{}, prepare func(tx *gorm.DB) *gorm.DB) (int64, error) {
+	if q.Limit < 0 || q.Offset < 0 {
+		return 0, common.NewValidationError("limit and offset can not be negative")
+	}
+	if q.Offset > 0 && q.Limit == 0 {
+		// mysql has no offset without a limit
+		return 0, common.NewValidationError("offset needs a limit")
+	}
+	order := spec.defaultSort
+	if q.Sort != "" {
+		field, desc := strings.CutPrefix(q.Sort, "-")
+		column, ok := spec.sorts[field]
+		if !ok {
+			return 0, common.NewValidationError("sort field is not valid:", field)
+		}
+		if desc {
+			column += " desc"
+		}
+		// id keeps the order stable between pages
+		order = column + ", id"
+	}
+
+	if spec.enable && q.Enable != nil {
+		tx = tx.Where("enable = ?", *q.Enable)
+	}
+	if len(spec.nameColumns) > 0 && q.Name != "" {
+		tx = tx.Where(searchCondition(tx, spec.nameColumns, q.Name))
+	}
+
+	var total int64
+	err := tx.Session(&gorm.Session{}).Count(&total).Error
+	if err != nil {
+		return 0, err
+	}
+
+	if prepare != nil {
+		tx = prepare(tx)
+	}
+	tx = tx.Order(order)
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit)
+	}
+	if q.Offset > 0 {
+		tx = tx.Offset(q.Offset)
+	}
+	err = tx.Find(dest).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return 0, err
+	}
+	return total, nil
+}
+
+// searchCondition matches a part of any column case insensitively
+func searchCondition(tx *gorm.DB, columns []string, text string) *gorm.DB {
+	pattern := "%" + strings.ToLower(text) + "%"
+	condition := tx.Session(&gorm.Session{NewDB: true})
+	for index, column := range columns {
+		if index == 0 {
+			condition = condition.Where("LOWER("+column+") LIKE ?", pattern)
+		} else {
+			condition = condition.Or("LOWER("+column+") LIKE ?", pattern)
+		}
+	}
+	return condition
+}
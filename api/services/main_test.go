@@ -0,0 +1,26 @@
+package services
+
+import (
+	"raha-xray/config"
+	"raha-xray/database"
+	"testing"
+)
+
+// initTestDB points settings to a new sqlite database in a temporary directory
+func initTestDB(t *testing.T) *config.Setting {
+	t.Helper()
+	settings := *config.GetDefaultSettings()
+	settings.DbAddr = t.TempDir()
+	config.SetSettings(&settings)
+	err := database.InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, err := database.GetDB().DB()
+		if err == nil {
+			sqlDB.Close()
+		}
+	})
+	return &settings
+}
@@ -0,0 +1,479 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"raha-xray/config"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/logger"
+	"raha-xray/util/common"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// last reported status of nodes by id
+var nodeStatuses sync.Map
+
+// NodeSnapshot is the desired state of an agent, ids are kept so relations stay valid on the agent
+type NodeSnapshot struct {
+	Configs   []*model.Config   `json:"configs"`
+	Inbounds  []*model.Inbound  `json:"inbounds"`
+	Clients   []*model.Client   `json:"clients"`
+	Outbounds []*model.Outbound `json:"outbounds"`
+	Balancers []*model.Balancer `json:"balancers"`
+	Rules     []*model.Rule     `json:"rules"`
+}
+
+type NodeClientTraffic struct {
+	Id   uint   `json:"id"`
+	Name string `json:"name"`
+	Up   uint64 `json:"up"`
+	Down uint64 `json:"down"`
+}
+
+// AgentReport is returned by agents with total client counters and server status
+type AgentReport struct {
+	Clients []*NodeClientTraffic `json:"clients"`
+	Status  *Status              `json:"status"`
+}
+
+type NodeService struct {
+}
+
+func (s *NodeService) GetAll() ([]*model.Node, error) {
+	db := database.GetDB()
+	var nodes []*model.Node
+	err := db.Model(model.Node{}).Find(&nodes).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	for _, node := range nodes {
+		node.Token = ""
+		if status, ok := nodeStatuses.Load(node.Id); ok {
+			node.Status = status
+		}
+	}
+	return nodes, nil
+}
+
+var nodeListSpec = &listSpec{
+	sorts:       map[string]string{"id": "id", "name": "name", "enable": "enable", "lastSync": "last_sync", "lastSeen": "last_seen"},
+	defaultSort: "id",
+	nameColumns: []string{"name", "address"},
+	enable:      true,
+}
+
+// List returns a page of nodes matching the query and the count of all matching nodes
+func (s *NodeService) List(query *ListQuery) ([]*model.Node, int64, error) {
+	db := database.GetDB()
+	var nodes []*model.Node
+	total, err := query.find(db.Model(model.Node{}), nodeListSpec, &nodes, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, node := range nodes {
+		node.Token = ""
+		if status, ok := nodeStatuses.Load(node.Id); ok {
+			node.Status = status
+		}
+	}
+	return nodes, total, nil
+}
+
+// Get returns the node without its token, tokens are write only
+func (s *NodeService) Get(id uint) (*model.Node, error) {
+	node, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	node.Token = ""
+	return node, nil
+}
+
+func (s *NodeService) get(id uint) (*model.Node, error) {
+	db := database.GetDB()
+	node := &model.Node{}
+	err := db.Model(model.Node{}).Where("id = ?", id).Find(node).Error
+	if err != nil {
+		return nil, err
+	}
+	if node.Id == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if status, ok := nodeStatuses.Load(node.Id); ok {
+		node.Status = status
+	}
+	return node, nil
+}
+
+func (s *NodeService) Save(node *model.Node) error {
+	if node.Name == "" {
+		return common.NewValidationError("node name is required")
+	}
+	node.Address = strings.TrimSuffix(node.Address, "/")
+	nodeUrl, err := url.Parse(node.Address)
+	if err != nil || (nodeUrl.Scheme != "http" && nodeUrl.Scheme != "https") || nodeUrl.Host == "" {
+		return common.NewValidationError("node address should be an http(s) url with base path:", node.Address)
+	}
+	if node.Token == "" && node.Id > 0 {
+		// tokens are not returned by reads, so updates without a token keep it
+		oldNode, err := s.get(node.Id)
+		if err != nil {
+			return err
+		}
+		node.Token = oldNode.Token
+	}
+	if node.Token == "" {
+		return common.NewValidationError("node token is required")
+	}
+	// push the whole state again after any change of the node
+	node.SyncHash = ""
+
+	db := database.GetDB()
+	return db.Save(node).Error
+}
+
+// Del removes the node and its counters, inbounds of the node should be deleted or moved before
+func (s *NodeService) Del(id uint) error {
+	db := database.GetDB()
+	var count int64
+	err := db.Model(model.Inbound{}).Where("node_id = ?", id).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.NewValidationErrorf("node has %d inbounds, delete or move them first", count)
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("node_id = ?", id).Delete(model.NodeTraffic{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(model.Node{}, id).Error
+	})
+	if err == nil {
+		nodeStatuses.Delete(id)
+	}
+	return err
+}
+
+// GetSnapshot collects inbounds of the node with their configs and clients, and all outbounds and routing.
+// Quota, reset and first usage are handled by the primary, so they are not sent to agents.
+func (s *NodeService) GetSnapshot(nodeId uint) (*NodeSnapshot, error) {
+	db := database.GetDB()
+	snapshot := &NodeSnapshot{}
+
+	err := db.Model(model.Inbound{}).Where("node_id = ?", nodeId).Order("id").Find(&snapshot.Inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	var inboundIds, configIds []uint
+	for _, inbound := range snapshot.Inbounds {
+		inbound.NodeId = 0
+		inboundIds = append(inboundIds, inbound.Id)
+		configIds = append(configIds, inbound.ConfigId)
+	}
+	err = db.Model(model.Config{}).Where("id in ?", configIds).Order("id").Find(&snapshot.Configs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var clientInbounds []model.ClientInbound
+	err = db.Model(model.ClientInbound{}).Where("inbound_id in ?", inboundIds).Order("id").Find(&clientInbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	var clientIds []uint
+	for _, clientInbound := range clientInbounds {
+		clientIds = append(clientIds, clientInbound.ClientId)
+	}
+	err = db.Model(model.Client{}).Where("id in ?", clientIds).Order("id").Find(&snapshot.Clients).Error
+	if err != nil {
+		return nil, err
+	}
+	clients := make(map[uint]*model.Client)
+	for _, client := range snapshot.Clients {
+		client.Quota = 0
+		client.Reset = 0
+		client.Once = 0
+		client.Up = 0
+		client.Down = 0
+		client.FirstUsed = 0
+		client.LastOnline = 0
+		client.OnlineTime = 0
+		clients[client.Id] = client
+	}
+	for _, clientInbound := range clientInbounds {
+		if client := clients[clientInbound.ClientId]; client != nil {
+			client.ClientInbounds = append(client.ClientInbounds, clientInbound)
+		}
+	}
+
+	err = db.Model(model.Outbound{}).Order("id").Find(&snapshot.Outbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, outbound := range snapshot.Outbounds {
+		outbound.SubscriptionId = 0
+		outbound.SubscriptionKey = ""
+	}
+	err = db.Model(model.Balancer{}).Order("id").Find(&snapshot.Balancers).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(model.Rule{}).Preload("RuleClients").Order("id").Find(&snapshot.Rules).Error
+	if err != nil {
+		return nil, err
+	}
+	// rules of clients which are not on the node are dropped, without clients they would match all users
+	rules := snapshot.Rules[:0]
+	for _, rule := range snapshot.Rules {
+		var ruleClients []model.RuleClient
+		for _, ruleClient := range rule.RuleClients {
+			if clients[ruleClient.ClientId] != nil {
+				ruleClients = append(ruleClients, ruleClient)
+			}
+		}
+		if len(rule.RuleClients) > 0 && len(ruleClients) == 0 {
+			continue
+		}
+		rule.RuleClients = ruleClients
+		rules = append(rules, rule)
+	}
+	snapshot.Rules = rules
+	return snapshot, nil
+}
+
+// Sync pushes the state to the node now, even if it is not changed
+func (s *NodeService) Sync(id uint) error {
+	node, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	return s.Push(node, true)
+}
+
+// Push sends the snapshot to the node if it is changed since the last successful push
+func (s *NodeService) Push(node *model.Node, force bool) error {
+	snapshot, err := s.GetSnapshot(node.Id)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if !force && hash == node.SyncHash {
+		return nil
+	}
+
+	db := database.GetDB()
+	err = s.request(node, http.MethodPost, "/agent/sync", data, nil)
+	if err != nil {
+		db.Model(node).Update("last_error", strings.TrimSpace(err.Error()))
+		return err
+	}
+	node.SyncHash = hash
+	node.LastSync = uint64(time.Now().UnixMilli())
+	node.LastError = ""
+	return db.Model(node).Updates(map[string]interface{}{
+		"sync_hash":  node.SyncHash,
+		"last_sync":  node.LastSync,
+		"last_error": node.LastError,
+	}).Error
+}
+
+// Pull gets the report of the node and converts growth of client counters to traffics.
+// The new counters are returned to be saved with the traffics, so a failed accounting pulls them again.
+func (s *NodeService) Pull(node *model.Node) ([]*model.Traffic, []*model.NodeTraffic, error) {
+	db := database.GetDB()
+	report := &AgentReport{}
+	err := s.request(node, http.MethodGet, "/agent/report", nil, report)
+	if err != nil {
+		db.Model(node).Update("last_error", strings.TrimSpace(err.Error()))
+		return nil, nil, err
+	}
+	if report.Status != nil {
+		nodeStatuses.Store(node.Id, report.Status)
+	}
+
+	var counters []*model.NodeTraffic
+	err = db.Model(model.NodeTraffic{}).Where("node_id = ?", node.Id).Find(&counters).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	lastCounters := make(map[uint]*model.NodeTraffic, len(counters))
+	for _, counter := range counters {
+		lastCounters[counter.ClientId] = counter
+	}
+	// nodes have the same client ids, names are taken from here as the node may not be synced yet
+	var clients []*model.Client
+	err = db.Model(model.Client{}).Select("id", "name").Find(&clients).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	clientNames := make(map[uint]string, len(clients))
+	for _, client := range clients {
+		clientNames[client.Id] = client.Name
+	}
+
+	now := uint64(time.Now().UnixMilli())
+	var traffics []*model.Traffic
+	var changed []*model.NodeTraffic
+	for _, client := range report.Clients {
+		name, ok := clientNames[client.Id]
+		if !ok {
+			continue
+		}
+		counter := lastCounters[client.Id]
+		if counter == nil {
+			counter = &model.NodeTraffic{NodeId: node.Id, ClientId: client.Id}
+		}
+		up := counterDelta(counter.Up, client.Up)
+		down := counterDelta(counter.Down, client.Down)
+		if up > 0 {
+			traffics = append(traffics, &model.Traffic{DateTime: now, Resource: "user", Tag: name, Direction: false, Traffic: up})
+		}
+		if down > 0 {
+			traffics = append(traffics, &model.Traffic{DateTime: now, Resource: "user", Tag: name, Direction: true, Traffic: down})
+		}
+		if counter.Id == 0 || counter.Up != client.Up || counter.Down != client.Down {
+			counter.Up = client.Up
+			counter.Down = client.Down
+			changed = append(changed, counter)
+		}
+	}
+	err = db.Model(node).Updates(map[string]interface{}{
+		"last_seen":  now,
+		"last_error": "",
+	}).Error
+	return traffics, changed, err
+}
+
+// counterDelta returns growth of a counter, a smaller value means the counter is reset on the node
+func counterDelta(last uint64, current uint64) uint64 {
+	if current < last {
+		return current
+	}
+	return current - last
+}
+
+func (s *NodeService) getEnabled() ([]*model.Node, error) {
+	db := database.GetDB()
+	var nodes []*model.Node
+	err := db.Model(model.Node{}).Where("enable = ?", true).Find(&nodes).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// PullTraffics pulls all enabled nodes concurrently, failed nodes are logged and skipped
+func (s *NodeService) PullTraffics() ([]*model.Traffic, []*model.NodeTraffic) {
+	nodes, err := s.getEnabled()
+	if err != nil {
+		logger.Warning("Unable to load nodes:", err)
+		return nil, nil
+	}
+	var traffics []*model.Traffic
+	var counters []*model.NodeTraffic
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *model.Node) {
+			defer wg.Done()
+			nodeTraffics, nodeCounters, err := s.Pull(node)
+			if err != nil {
+				logger.Warning("Unable to pull node", node.Name, err)
+				return
+			}
+			mutex.Lock()
+			traffics = append(traffics, nodeTraffics...)
+			counters = append(counters, nodeCounters...)
+			mutex.Unlock()
+		}(node)
+	}
+	wg.Wait()
+	return traffics, counters
+}
+
+// SyncAll pushes changed snapshots to all enabled nodes concurrently
+func (s *NodeService) SyncAll() {
+	nodes, err := s.getEnabled()
+	if err != nil {
+		logger.Warning("Unable to load nodes:", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *model.Node) {
+			defer wg.Done()
+			err := s.Push(node, false)
+			if err != nil {
+				logger.Warning("Unable to push node", node.Name, err)
+			}
+		}(node)
+	}
+	wg.Wait()
+}
+
+// request calls the api of the agent with its token and decodes obj of the response
+func (s *NodeService) request(node *model.Node, method string, path string, body []byte, obj interface{}) error {
+	return callRemote(node.Address+path, node.Token, method, body, obj)
+}
+
+// callRemote calls the api of another raha-xray and decodes obj of the response
+func callRemote(apiUrl string, token string, method string, body []byte, obj interface{}) error {
+	timeout := config.GetSettings().NodeTimeout
+	if timeout == 0 {
+		timeout = config.GetDefaultSettings().NodeTimeout
+	}
+	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	req, err := http.NewRequest(method, apiUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Token", token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return common.NewErrorf("remote response status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var msg struct {
+		Success bool            `json:"success"`
+		Msg     string          `json:"msg"`
+		Obj     json.RawMessage `json:"obj"`
+	}
+	err = json.Unmarshal(data, &msg)
+	if err != nil {
+		return common.NewError("invalid remote response:", err)
+	}
+	if !msg.Success {
+		return common.NewError("remote error:", msg.Msg)
+	}
+	if obj != nil {
+		return json.Unmarshal(msg.Obj, obj)
+	}
+	return nil
+}
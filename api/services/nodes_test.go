@@ -0,0 +1,292 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"raha-xray/config"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/xray"
+	"sync"
+	"testing"
+)
+
+// testAgent serves client counters of a node and keeps pushed snapshots like the agent api
+type testAgent struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	clients   []*NodeClientTraffic
+	snapshots [][]byte
+}
+
+func newTestAgent(t *testing.T) *testAgent {
+	agent := &testAgent{}
+	agent.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		agent.mutex.Lock()
+		defer agent.mutex.Unlock()
+		switch r.URL.Path {
+		case "/agent/report":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": true,
+				"obj":     &AgentReport{Clients: agent.clients},
+			})
+		case "/agent/sync":
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			agent.snapshots = append(agent.snapshots, data)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(agent.Close)
+	return agent
+}
+
+func (a *testAgent) report(clients ...*NodeClientTraffic) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.clients = clients
+}
+
+func TestPullNodeTraffics(t *testing.T) {
+	settings := initTestDB(t)
+	savedProcess := p
+	p = xray.NewProcess(&xray.Config{})
+	t.Cleanup(func() { p = savedProcess })
+	db := database.GetDB()
+
+	for _, client := range []*model.Client{{Id: 1, Name: "a", Enable: true}, {Id: 2, Name: "b", Enable: true}} {
+		err := db.Create(client).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	agents := []*testAgent{newTestAgent(t), newTestAgent(t)}
+	for index, agent := range agents {
+		err := db.Create(&model.Node{Name: fmt.Sprintf("node%d", index+1), Address: agent.URL, Token: "token", Enable: true}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nodeService := &NodeService{}
+	trafficService := &TrafficService{}
+	pull := func() {
+		t.Helper()
+		traffics, counters := nodeService.PullTraffics()
+		err, _ := trafficService.AddTraffic(traffics, counters)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkClient := func(id uint, up uint64, down uint64) {
+		t.Helper()
+		client := &model.Client{}
+		err := db.First(client, id).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client.Up != up || client.Down != down {
+			t.Errorf("client %s has %d/%d, want %d/%d", client.Name, client.Up, client.Down, up, down)
+		}
+	}
+
+	agents[0].report(&NodeClientTraffic{Id: 1, Name: "a", Up: 100, Down: 200}, &NodeClientTraffic{Id: 2, Name: "b", Up: 10})
+	agents[1].report(&NodeClientTraffic{Id: 1, Name: "a", Up: 5, Down: 5}, &NodeClientTraffic{Id: 3, Name: "unknown", Up: 7})
+	pull()
+	checkClient(1, 105, 205)
+	checkClient(2, 10, 0)
+	var count int64
+	db.Model(&model.NodeTraffic{}).Count(&count)
+	if count != 3 {
+		t.Errorf("%d node counters, want 3", count)
+	}
+
+	// a renamed client keeps its counters, which are saved only with the traffics
+	err := db.Model(&model.Client{}).Where("id = ?", 1).Update("name", "renamed").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	agents[0].report(&NodeClientTraffic{Id: 1, Name: "a", Up: 150, Down: 200}, &NodeClientTraffic{Id: 2, Name: "b", Up: 10})
+	agents[1].report(&NodeClientTraffic{Id: 1, Name: "a", Up: 1, Down: 1})
+	nodeService.PullTraffics()
+	pull()
+	checkClient(1, 156, 206)
+	checkClient(2, 10, 0)
+
+	// counters are kept when the traffics are not accounted
+	settings.TrafficDays = 1
+	config.SetSettings(settings)
+	agents[0].report(&NodeClientTraffic{Id: 1, Name: "renamed", Up: 160, Down: 200})
+	traffics, counters := nodeService.PullTraffics()
+	err = db.Migrator().DropTable(&model.Traffic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err, _ = trafficService.AddTraffic(traffics, counters)
+	if err == nil {
+		t.Fatal("traffics are accounted without their table")
+	}
+	err = db.AutoMigrate(&model.Traffic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pull()
+	checkClient(1, 166, 206)
+}
+
+func TestNodeSyncRoundTrip(t *testing.T) {
+	initTestDB(t)
+	db := database.GetDB()
+	agents := []*testAgent{newTestAgent(t), newTestAgent(t)}
+	for index, agent := range agents {
+		err := db.Create(&model.Node{Name: fmt.Sprintf("node%d", index+1), Address: agent.URL, Token: "token", Enable: true}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows := []interface{}{
+		&model.Config{Id: 1, Protocol: model.VLESS, Settings: "{}"},
+		&model.Inbound{Id: 1, Tag: "in1", Port: 1001, ConfigId: 1, NodeId: 1},
+		&model.Inbound{Id: 2, Tag: "in2", Port: 1002, ConfigId: 1, NodeId: 1},
+		&model.Inbound{Id: 3, Tag: "in3", Port: 1003, ConfigId: 1, NodeId: 2},
+		&model.Inbound{Id: 4, Tag: "local", Port: 1004, ConfigId: 1},
+		&model.Client{Id: 1, Name: "a", Quota: 100, Up: 10},
+		&model.Client{Id: 2, Name: "b"},
+		&model.Client{Id: 3, Name: "c"},
+		&[]model.ClientInbound{{InboundId: 1, ClientId: 1}, {InboundId: 1, ClientId: 3}, {InboundId: 3, ClientId: 2}, {InboundId: 3, ClientId: 3}},
+		&model.Outbound{Id: 1, Tag: "direct", Protocol: "freedom", Enable: true},
+		&model.Outbound{Id: 2, Tag: "blocked", Protocol: "blackhole"},
+		&model.Rule{Id: 1, Enable: true, OutboundTag: "direct"},
+		&model.Rule{Id: 2, Enable: true, OutboundTag: "direct", RuleClients: []model.RuleClient{{ClientId: 2}}},
+		&model.Rule{Id: 3, Enable: true, OutboundTag: "direct", RuleClients: []model.RuleClient{{ClientId: 1}, {ClientId: 2}}},
+	}
+	for _, row := range rows {
+		err := db.Create(row).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for table, id := range map[interface{}]uint{&model.Inbound{}: 2, &model.Client{}: 3} {
+		err := db.Model(table).Where("id = ?", id).Update("enable", false).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nodeService := &NodeService{}
+	for i := 0; i < 2; i++ {
+		nodeService.SyncAll()
+	}
+	for index, agent := range agents {
+		if len(agent.snapshots) != 1 {
+			t.Fatalf("node%d got %d snapshots, unchanged snapshots should be pushed once", index+1, len(agent.snapshots))
+		}
+	}
+	err := nodeService.Sync(1)
+	if err != nil || len(agents[0].snapshots) != 2 {
+		t.Fatalf("forced sync pushed %d snapshots, %v", len(agents[0].snapshots), err)
+	}
+
+	// every node applies its snapshot to its own database
+	type nodeState struct {
+		inbounds map[string]bool
+		clients  map[string]bool
+		rules    map[uint][]uint
+	}
+	wants := []nodeState{
+		{
+			inbounds: map[string]bool{"in1": true, "in2": false},
+			clients:  map[string]bool{"a": true, "c": false},
+			rules:    map[uint][]uint{1: nil, 3: {1}},
+		},
+		{
+			inbounds: map[string]bool{"in3": true},
+			clients:  map[string]bool{"b": true, "c": false},
+			rules:    map[uint][]uint{1: nil, 2: {2}, 3: {2}},
+		},
+	}
+	agentService := &AgentService{}
+	for index, agent := range agents {
+		initTestDB(t)
+		db = database.GetDB()
+		// activity of clients on the agent is kept by name
+		err = db.Create(&model.Client{Id: 9, Name: "c", Up: 7}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		snapshot := &NodeSnapshot{}
+		err = json.Unmarshal(agent.snapshots[0], snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = agentService.Apply(snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := wants[index]
+
+		var inbounds []*model.Inbound
+		db.Find(&inbounds)
+		if len(inbounds) != len(want.inbounds) {
+			t.Errorf("node%d has %d inbounds, want %d", index+1, len(inbounds), len(want.inbounds))
+		}
+		for _, inbound := range inbounds {
+			enable, ok := want.inbounds[inbound.Tag]
+			if !ok || inbound.Enable != enable || inbound.NodeId != 0 {
+				t.Errorf("node%d has inbound %+v", index+1, inbound)
+			}
+		}
+
+		var clients []*model.Client
+		db.Find(&clients)
+		if len(clients) != len(want.clients) {
+			t.Errorf("node%d has %d clients, want %d", index+1, len(clients), len(want.clients))
+		}
+		for _, client := range clients {
+			enable, ok := want.clients[client.Name]
+			if !ok || client.Enable != enable || client.Quota != 0 {
+				t.Errorf("node%d has client %+v", index+1, client)
+			}
+			if client.Name == "c" && client.Up != 7 {
+				t.Errorf("node%d client c up %d, want the local 7", index+1, client.Up)
+			}
+		}
+
+		var outbounds []*model.Outbound
+		db.Order("id").Find(&outbounds)
+		if len(outbounds) != 2 || !outbounds[0].Enable || outbounds[1].Enable {
+			t.Errorf("node%d has outbounds %+v", index+1, outbounds)
+		}
+
+		var rules []*model.Rule
+		db.Preload("RuleClients").Find(&rules)
+		if len(rules) != len(want.rules) {
+			t.Errorf("node%d has %d rules, want %d", index+1, len(rules), len(want.rules))
+		}
+		for _, rule := range rules {
+			clientIds, ok := want.rules[rule.Id]
+			if !ok || len(rule.RuleClients) != len(clientIds) {
+				t.Errorf("node%d has rule %d with %d clients", index+1, rule.Id, len(rule.RuleClients))
+				continue
+			}
+			for i, ruleClient := range rule.RuleClients {
+				if ruleClient.ClientId != clientIds[i] {
+					t.Errorf("node%d rule %d has client %d, want %d", index+1, rule.Id, ruleClient.ClientId, clientIds[i])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"raha-xray/config"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/logger"
+	"raha-xray/util/common"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	EventQuota    = "client.quota"
+	EventExpiry   = "client.expiry"
+	EventDisabled = "client.disabled"
+	EventReset    = "client.reset"
+	EventTest     = "test"
+)
+
+type Event struct {
+	Type     string        `json:"type"`
+	DateTime int64         `json:"dateTime"`
+	Level    int           `json:"level,omitempty"`
+	Client   *model.Client `json:"client,omitempty"`
+}
+
+type NotificationService struct {
+}
+
+// webhookBackoff is doubled after each failed attempt of a webhook
+var webhookBackoff = time.Second
+
+var listeners = make(map[string]func(*Event))
+var listenersLock sync.RWMutex
+
+// SetEventListener registers fn to receive every event beside webhooks, nil fn removes it
+func SetEventListener(name string, fn func(*Event)) {
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+	if fn == nil {
+		delete(listeners, name)
+	} else {
+		listeners[name] = fn
+	}
+}
+
+func hasEventListeners() bool {
+	listenersLock.RLock()
+	defer listenersLock.RUnlock()
+	return len(listeners) > 0
+}
+
+func notifyListeners(event *Event) {
+	listenersLock.RLock()
+	fns := make([]func(*Event), 0, len(listeners))
+	for _, fn := range listeners {
+		fns = append(fns, fn)
+	}
+	listenersLock.RUnlock()
+	for _, fn := range fns {
+		fn(event)
+	}
+}
+
+func NewEvent(eventType string, client *model.Client, level int) *Event {
+	if client != nil {
+		// Inbound configs carry client secrets
+		c := *client
+		c.ClientInbounds = nil
+		client = &c
+	}
+	return &Event{
+		Type:     eventType,
+		DateTime: time.Now().UnixMilli(),
+		Level:    level,
+		Client:   client,
+	}
+}
+
+func (s *NotificationService) IsEnabled() bool {
+	return len(config.GetSettings().Webhooks) > 0 || hasEventListeners()
+}
+
+// Send delivers events to listeners and all configured webhooks in the background
+func (s *NotificationService) Send(events ...*Event) {
+	if !s.IsEnabled() || len(events) == 0 {
+		return
+	}
+	appConfig := config.GetSettings()
+	go func() {
+		defer common.Recover("webhook delivery")
+		for _, event := range events {
+			notifyListeners(event)
+			for _, webhook := range appConfig.Webhooks {
+				s.deliver(webhook, appConfig.WebhookSecret, appConfig.WebhookRetries, event)
+			}
+		}
+	}()
+}
+
+func (s *NotificationService) deliver(url string, secret string, retries int, event *Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.Warning("Unable to marshal event:", err)
+		return
+	}
+
+	log := &model.WebhookLog{
+		DateTime: uint64(time.Now().UnixMilli()),
+		Event:    event.Type,
+		Url:      url,
+		Payload:  string(payload),
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	for attempt := 1; ; attempt++ {
+		log.Attempts = attempt
+		log.Status, err = s.post(client, url, secret, event.Type, payload)
+		if err == nil {
+			log.Error = ""
+			break
+		}
+		log.Error = err.Error()
+		logger.Debugf("Webhook %s attempt %d failed: %v", url, attempt, err)
+		if attempt > retries {
+			break
+		}
+		time.Sleep(time.Duration(1<<attempt) * webhookBackoff)
+	}
+
+	db := database.GetDB()
+	err = db.Create(log).Error
+	if err != nil {
+		logger.Warning("Unable to save webhook log:", err)
+	}
+}
+
+func (s *NotificationService) post(client *http.Client, url string, secret string, eventType string, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Raha-Event", eventType)
+	req.Header.Set("X-Raha-Signature", "sha256="+Sign(secret, payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, common.NewErrorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns hex encoded HMAC-SHA256 of payload, receivers recompute it with the shared secret
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckAlerts fires quota and expiry warnings for clients crossing configured thresholds.
+// Each threshold is fired once per usage period.
+func (s *NotificationService) CheckAlerts() error {
+	if !s.IsEnabled() {
+		return nil
+	}
+	appConfig := config.GetSettings()
+	db := database.GetDB()
+	now := time.Now().UnixMilli()
+	var events []*Event
+
+	for _, level := range appConfig.QuotaAlerts {
+		var clients []*model.Client
+		err := db.Model(model.Client{}).
+			Where("enable = ? and quota > 0 and (up + down) * 100 >= quota * ?", true, level).
+			Where("id NOT IN (?)", db.Model(model.ClientAlert{}).Select("client_id").Where("event = ? and level = ?", EventQuota, level)).
+			Find(&clients).Error
+		if err != nil {
+			return err
+		}
+		for _, client := range clients {
+			err = db.Create(&model.ClientAlert{ClientId: client.Id, Event: EventQuota, Level: level}).Error
+			if err != nil {
+				return err
+			}
+			events = append(events, NewEvent(EventQuota, client, level))
+		}
+	}
+
+	for _, days := range appConfig.ExpiryAlerts {
+		var clients []*model.Client
+		err := db.Model(model.Client{}).
+			Where("enable = ? and expiry > ? and expiry <= ?", true, now, now+int64(days)*86400000).
+			Where("id NOT IN (?)", db.Model(model.ClientAlert{}).Select("client_id").Where("event = ? and level = ?", EventExpiry, days)).
+			Find(&clients).Error
+		if err != nil {
+			return err
+		}
+		for _, client := range clients {
+			err = db.Create(&model.ClientAlert{ClientId: client.Id, Event: EventExpiry, Level: days}).Error
+			if err != nil {
+				return err
+			}
+			events = append(events, NewEvent(EventExpiry, client, days))
+		}
+	}
+
+	s.Send(events...)
+	return nil
+}
+
+// ClearAlerts lets warnings of a client fire again in its next usage period,
+// only warnings of the given events are cleared if any is given
+func (s *NotificationService) ClearAlerts(tx *gorm.DB, clientId uint, events ...string) error {
+	query := tx.Where("client_id = ?", clientId)
+	if len(events) > 0 {
+		query = query.Where("event IN ?", events)
+	}
+	return query.Delete(model.ClientAlert{}).Error
+}
+
+func (s *NotificationService) GetLogs(count int) ([]*model.WebhookLog, error) {
+	db := database.GetDB()
+	var logs []*model.WebhookLog
+	err := db.Model(model.WebhookLog{}).Order("id desc").Limit(count).Find(&logs).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return logs, nil
+}
+
+func (s *NotificationService) DelOldLogs(days int) int64 {
+	db := database.GetDB()
+	dateTimeThreshold := time.Now().AddDate(0, 0, -days).UnixMilli()
+	result := db.Where("date_time < ?", dateTimeThreshold).Delete(model.WebhookLog{})
+	if result.Error != nil {
+		logger.Debug("Unable to delete old webhook logs", result.Error)
+		return 0
+	}
+	return result.RowsAffected
+}
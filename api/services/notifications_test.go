@@ -0,0 +1,205 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/xray"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func setWebhookBackoff(t *testing.T, backoff time.Duration) {
+	saved := webhookBackoff
+	webhookBackoff = backoff
+	t.Cleanup(func() { webhookBackoff = saved })
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	initTestDB(t)
+	setWebhookBackoff(t, time.Millisecond)
+
+	const secret = "webhook-secret"
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		if r.Header.Get("X-Raha-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("signature %q does not match the payload", r.Header.Get("X-Raha-Signature"))
+		}
+		if r.Header.Get("X-Raha-Event") != EventReset {
+			t.Errorf("event header is %q", r.Header.Get("X-Raha-Event"))
+		}
+		// the first two attempts fail
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s := &NotificationService{}
+	s.deliver(server.URL, secret, 2, NewEvent(EventReset, &model.Client{Name: "alice"}, 0))
+
+	if attempts != 3 {
+		t.Fatalf("webhook is called %d times, want 3", attempts)
+	}
+	var logs []*model.WebhookLog
+	err := database.GetDB().Find(&logs).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("%d webhook logs, want 1", len(logs))
+	}
+	log := logs[0]
+	if log.Status != http.StatusNoContent || log.Attempts != 3 || log.Error != "" || log.Event != EventReset || log.Url != server.URL {
+		t.Errorf("unexpected webhook log %+v", log)
+	}
+	var event Event
+	err = json.Unmarshal([]byte(log.Payload), &event)
+	if err != nil || event.Client == nil || event.Client.Name != "alice" {
+		t.Errorf("payload %q is not the event", log.Payload)
+	}
+}
+
+func TestWebhookRetriesExhausted(t *testing.T) {
+	initTestDB(t)
+	setWebhookBackoff(t, time.Millisecond)
+
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	s := &NotificationService{}
+	s.deliver(server.URL, "", 1, NewEvent(EventTest, nil, 0))
+
+	if attempts != 2 {
+		t.Fatalf("webhook is called %d times, want 2", attempts)
+	}
+	var log model.WebhookLog
+	err := database.GetDB().First(&log).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Status != http.StatusBadGateway || log.Attempts != 2 || log.Error == "" {
+		t.Errorf("unexpected webhook log %+v", log)
+	}
+}
+
+func TestListenerCanChangeListeners(t *testing.T) {
+	called := make(chan bool, 1)
+	SetEventListener("test", func(event *Event) {
+		// listeners run without the lock held
+		SetEventListener("test", nil)
+		called <- true
+	})
+	t.Cleanup(func() { SetEventListener("test", nil) })
+
+	go notifyListeners(NewEvent(EventReset, nil, 0))
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener is blocked")
+	}
+	if hasEventListeners() {
+		t.Error("listener is not removed")
+	}
+}
+
+// collectEvents returns a channel of the events sent to listeners while the test runs
+func collectEvents(t *testing.T) chan *Event {
+	events := make(chan *Event, 10)
+	SetEventListener("test", func(event *Event) { events <- event })
+	t.Cleanup(func() { SetEventListener("test", nil) })
+	return events
+}
+
+func receiveEvents(events chan *Event) []string {
+	var received []string
+	for {
+		select {
+		case event := <-events:
+			received = append(received, fmt.Sprintf("%s %s %d", event.Type, event.Client.Name, event.Level))
+		case <-time.After(100 * time.Millisecond):
+			sort.Strings(received)
+			return received
+		}
+	}
+}
+
+func TestCheckAlerts(t *testing.T) {
+	initTestDB(t)
+	savedProcess := p
+	p = xray.NewProcess(&xray.Config{})
+	t.Cleanup(func() { p = savedProcess })
+	events := collectEvents(t)
+	s := &NotificationService{}
+	clientService := &ClientService{}
+
+	expiry := uint64(time.Now().Add(48 * time.Hour).UnixMilli())
+	err := database.GetDB().Create([]*model.Client{
+		{Name: "quota", Enable: true, Up: 500, Down: 350, Quota: 1000},
+		{Name: "expiry", Enable: true, Expiry: expiry},
+		{Name: "fine", Enable: true, Up: 100, Quota: 1000},
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(want ...string) {
+		t.Helper()
+		err := s.CheckAlerts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		received := receiveEvents(events)
+		if strings.Join(received, ", ") != strings.Join(want, ", ") {
+			t.Errorf("events %v, want %v", received, want)
+		}
+	}
+	patch := func(name string, clientPatch *ClientPatch) {
+		t.Helper()
+		client, err := clientService.GetByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err, _ = clientService.Patch(client.Id, clientPatch)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check(EventExpiry+" expiry 3", EventQuota+" quota 80")
+	// thresholds fire once
+	check()
+
+	// a raised quota or an extended expiry lets thresholds fire again
+	quota, extended := uint64(2000), expiry+7*86400000
+	patch("quota", &ClientPatch{Quota: &quota})
+	patch("expiry", &ClientPatch{Expiry: &extended})
+	check()
+	quota = 1000
+	patch("quota", &ClientPatch{Quota: &quota})
+	patch("expiry", &ClientPatch{Expiry: &expiry})
+	check(EventExpiry+" expiry 3", EventQuota+" quota 80")
+
+	// lowering does not clear fired thresholds
+	quota = 880
+	patch("quota", &ClientPatch{Quota: &quota})
+	check(EventQuota + " quota 95")
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"encoding/json"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/util/common"
+	"raha-xray/util/json_util"
+	"raha-xray/xray"
+
+	"github.com/xtls/xray-core/app/observatory"
+	"gorm.io/gorm"
+)
+
+type ObservatoryService struct {
+	xray.XrayAPI
+}
+
+func (s *ObservatoryService) GetAll() ([]*model.Observatory, error) {
+	db := database.GetDB()
+	var observatories []*model.Observatory
+	err := db.Model(model.Observatory{}).Find(&observatories).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return observatories, nil
+}
+
+var observatoryListSpec = &listSpec{
+	sorts:       map[string]string{"id": "id", "type": "type"},
+	defaultSort: "id",
+	nameColumns: []string{"type"},
+}
+
+// List returns a page of observatories matching the query and the count of all matching observatories
+func (s *ObservatoryService) List(query *ListQuery) ([]*model.Observatory, int64, error) {
+	db := database.GetDB()
+	var observatories []*model.Observatory
+	total, err := query.find(db.Model(model.Observatory{}), observatoryListSpec, &observatories, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return observatories, total, nil
+}
+
+func (s *ObservatoryService) Get(id int) (*model.Observatory, error) {
+	db := database.GetDB()
+	var observatory *model.Observatory
+	err := db.Model(model.Observatory{}).Where("id = ?", id).Find(&observatory).Error
+	if err != nil {
+		return nil, err
+	}
+	if observatory.Id == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return observatory, nil
+}
+
+func (s *ObservatoryService) Save(observatory *model.Observatory) error {
+	switch observatory.Type {
+	case model.ObservatoryDefault, model.ObservatoryBurst:
+	default:
+		return common.NewValidationError("observatory type should be observatory or burstObservatory:", observatory.Type)
+	}
+	var selector []string
+	err := json.Unmarshal([]byte(observatory.SubjectSelector), &selector)
+	if err != nil || len(selector) == 0 {
+		return common.NewValidationError("observatory subject selector should be a non-empty array:", observatory.SubjectSelector)
+	}
+	if common.NonEmptyValue(observatory.PingConfig) && !json.Valid([]byte(observatory.PingConfig)) {
+		return common.NewValidationError("observatory ping config is not valid json")
+	}
+
+	db := database.GetDB()
+	return db.Save(observatory).Error
+}
+
+func (s *ObservatoryService) Del(id uint) error {
+	db := database.GetDB()
+	return db.Delete(model.Observatory{}, id).Error
+}
+
+func (s *ObservatoryService) GetObservatoryConfig(observatory *model.Observatory) (json_util.RawMessage, error) {
+	observatoryConfig := make(map[string]interface{})
+	var selector []string
+	json.Unmarshal([]byte(observatory.SubjectSelector), &selector)
+	observatoryConfig["subjectSelector"] = selector
+	if observatory.Type == model.ObservatoryBurst {
+		if common.NonEmptyValue(observatory.PingConfig) {
+			var pingConfig interface{}
+			json.Unmarshal([]byte(observatory.PingConfig), &pingConfig)
+			observatoryConfig["pingConfig"] = pingConfig
+		}
+	} else {
+		if common.NonEmptyValue(observatory.ProbeUrl) {
+			observatoryConfig["probeURL"] = observatory.ProbeUrl
+		}
+		if common.NonEmptyValue(observatory.ProbeInterval) {
+			observatoryConfig["probeInterval"] = observatory.ProbeInterval
+		}
+		observatoryConfig["enableConcurrency"] = observatory.EnableConcurrency
+	}
+	return json.Marshal(observatoryConfig)
+}
+
+func (s *ObservatoryService) GetStatus() ([]*observatory.OutboundStatus, error) {
+	err := s.XrayAPI.Init(p.GetAPIServer())
+	if err != nil {
+		return nil, err
+	}
+	defer s.XrayAPI.Close()
+	return s.XrayAPI.GetOutboundStatus()
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"encoding/json"
+	"raha-xray/config"
 	"raha-xray/database"
 	"raha-xray/database/model"
 	"raha-xray/logger"
@@ -26,6 +27,24 @@ func (s *OutboundService) GetAll() ([]*model.Outbound, error) {
 	return outbounds, nil
 }
 
+var outboundListSpec = &listSpec{
+	sorts:       map[string]string{"id": "id", "tag": "tag", "enable": "enable", "protocol": "protocol", "subscriptionId": "subscription_id"},
+	defaultSort: "id",
+	nameColumns: []string{"tag"},
+	enable:      true,
+}
+
+// List returns a page of outbounds matching the query and the count of all matching outbounds
+func (s *OutboundService) List(query *ListQuery) ([]*model.Outbound, int64, error) {
+	db := database.GetDB()
+	var outbounds []*model.Outbound
+	total, err := query.find(db.Model(model.Outbound{}), outboundListSpec, &outbounds, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return outbounds, total, nil
+}
+
 func (s *OutboundService) Get(id int) (*model.Outbound, error) {
 	db := database.GetDB()
 	var outbound *model.Outbound
@@ -40,35 +59,83 @@ func (s *OutboundService) Get(id int) (*model.Outbound, error) {
 }
 
 func (s *OutboundService) Save(outbound *model.Outbound) (error, bool) {
+	return s.save(database.GetDB(), outbound, true)
+}
+
+// save checks and stores the outbound, live changes apply it to xray by api, otherwise xray is restarted for it
+func (s *OutboundService) save(db *gorm.DB, outbound *model.Outbound, live bool) (error, bool) {
 	var err error
-	db := database.GetDB()
 
-	needRestart := false
-	err = s.XrayAPI.Init(p.GetAPIServer())
+	var oldOutbound model.Outbound
+	if outbound.Id > 0 {
+		err = db.Model(model.Outbound{}).Select("tag", "enable").Where("id = ?", outbound.Id).Find(&oldOutbound).Error
+		if err != nil {
+			return err, false
+		}
+		if oldOutbound.Tag != outbound.Tag {
+			used, err := reverseUsesOutbound(db, oldOutbound.Tag)
+			if err != nil {
+				return err, false
+			}
+			if used {
+				return common.NewValidationError("outbound is used by a reverse bridge, it can not be renamed:", oldOutbound.Tag), false
+			}
+		}
+		if oldOutbound.Enable && !outbound.Enable {
+			used, err := reverseUsesOutbound(db, oldOutbound.Tag)
+			if err != nil {
+				return err, false
+			}
+			if used {
+				return common.NewValidationError("outbound is used by a reverse bridge, it can not be disabled:", oldOutbound.Tag), false
+			}
+			if oldOutbound.Tag == config.GetSettings().OutboundFallbackTag {
+				return common.NewValidationError("outbound is the outbound fallback tag, it can not be disabled:", oldOutbound.Tag), false
+			}
+		}
+	}
+	// Rules still refer to the old tag of a renamed outbound
+	ruleTag := outbound.Tag
+	if outbound.Id > 0 {
+		ruleTag = oldOutbound.Tag
+	}
+	var ruleCount int64
+	err = db.Model(model.Rule{}).Where("outbound_tag = ? and enable = ?", ruleTag, true).Count(&ruleCount).Error
 	if err != nil {
-		needRestart = true
+		return err, false
+	}
+	// Rules of a disabled outbound would be dropped from routing without a fallback tag
+	if ruleCount > 0 && !outbound.Enable && (outbound.Id == 0 || oldOutbound.Enable) && config.GetSettings().OutboundFallbackTag == "" {
+		return common.NewValidationErrorf("outbound %s is used by %d rules, set the outbound fallback tag or change the rules before disabling it", ruleTag, ruleCount), false
+	}
+	// Routing of the rules changes with enable, which is applied by restart
+	needRestart := outbound.Id > 0 && ruleCount > 0 && oldOutbound.Enable != outbound.Enable
+	if !live {
+		err = db.Save(outbound).Error
+		return err, true
 	}
-	defer s.XrayAPI.Close()
 
-	// Remove old outbound with API
-	if outbound.Id > 0 && !needRestart {
-		var outboundTag string
-		err = db.Model(model.Outbound{}).Select("tag").Where("id = ?", outbound.Id).Find(&outboundTag).Error
+	if !needRestart {
+		err = s.XrayAPI.Init(p.GetAPIServer())
 		if err != nil {
-			logger.Debug("Failed to find outbound data to removing by API:", err)
 			needRestart = true
+		}
+		defer s.XrayAPI.Close()
+	}
+
+	// Remove old outbound with API
+	if outbound.Id > 0 && !needRestart && oldOutbound.Enable {
+		outboundTag := oldOutbound.Tag
+		err = s.XrayAPI.DelOutbound(outboundTag)
+		if err == nil {
+			logger.Debug("Outbound deleted by api:", outboundTag)
 		} else {
-			err = s.XrayAPI.DelOutbound(outboundTag)
-			if err == nil {
-				logger.Debug("Outbound deleted by api:", outboundTag)
-			} else {
-				logger.Debug("Unable to delete outbound by api:", err)
-				needRestart = true
-			}
+			logger.Debug("Unable to delete outbound by api:", err)
+			needRestart = true
 		}
 	}
 
-	if !needRestart {
+	if !needRestart && outbound.Enable {
 		outboundConfig, err := s.GetOutboundConfig(outbound)
 		if err != nil {
 			needRestart = true
@@ -85,7 +152,53 @@ func (s *OutboundService) Save(outbound *model.Outbound) (error, bool) {
 		}
 	}
 
-	return db.Save(outbound).Error, needRestart
+	err = db.Save(outbound).Error
+	return err, needRestart
+}
+
+// OutboundPatch has the fields of an outbound which can be changed, nil fields are kept
+type OutboundPatch struct {
+	Enable         *bool   `json:"enable"`
+	SendThrough    *string `json:"sendThrough"`
+	Protocol       *string `json:"protocol"`
+	Settings       *string `json:"settings"`
+	Tag            *string `json:"tag"`
+	StreamSettings *string `json:"streamSettings"`
+	ProxySettings  *string `json:"proxySettings"`
+	Mux            *string `json:"mux"`
+}
+
+// Patch changes the fields of an outbound which are set in the patch, its subscription is kept
+func (s *OutboundService) Patch(id uint, patch *OutboundPatch) (error, bool) {
+	outbound, err := s.Get(int(id))
+	if err != nil {
+		return err, false
+	}
+	if patch.Enable != nil {
+		outbound.Enable = *patch.Enable
+	}
+	if patch.SendThrough != nil {
+		outbound.SendThrough = *patch.SendThrough
+	}
+	if patch.Protocol != nil {
+		outbound.Protocol = *patch.Protocol
+	}
+	if patch.Settings != nil {
+		outbound.Settings = *patch.Settings
+	}
+	if patch.Tag != nil {
+		outbound.Tag = *patch.Tag
+	}
+	if patch.StreamSettings != nil {
+		outbound.StreamSettings = *patch.StreamSettings
+	}
+	if patch.ProxySettings != nil {
+		outbound.ProxySettings = *patch.ProxySettings
+	}
+	if patch.Mux != nil {
+		outbound.Mux = *patch.Mux
+	}
+	return s.Save(outbound)
 }
 
 func (s *OutboundService) GetOutboundConfig(outbound *model.Outbound) (*json_util.RawMessage, error) {
@@ -124,21 +237,127 @@ func (s *OutboundService) GetOutboundConfig(outbound *model.Outbound) (*json_uti
 	return &outboundRaw, nil
 }
 
+// GetDisabledTags returns tags of disabled outbounds
+func (s *OutboundService) GetDisabledTags() (map[string]bool, error) {
+	db := database.GetDB()
+	var tags []string
+	err := db.Model(model.Outbound{}).Where("enable = ?", false).Pluck("tag", &tags).Error
+	if err != nil {
+		return nil, err
+	}
+	disabledTags := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		disabledTags[tag] = true
+	}
+	return disabledTags, nil
+}
+
+// GetEnabledTags returns tags of enabled outbounds of database and default xray config
+func (s *OutboundService) GetEnabledTags() ([]string, error) {
+	db := database.GetDB()
+	var tags []string
+	err := db.Model(model.Outbound{}).Where("enable = ?", true).Pluck("tag", &tags).Error
+	if err != nil {
+		return nil, err
+	}
+	settingService := SettingService{}
+	xrayConfig, err := settingService.GetXrayDefault()
+	if err != nil {
+		return nil, err
+	}
+	for _, outboundConfig := range xrayConfig.OutboundConfigs {
+		var outbound struct {
+			Tag string `json:"tag"`
+		}
+		json.Unmarshal(outboundConfig, &outbound)
+		tags = append(tags, outbound.Tag)
+	}
+	return tags, nil
+}
+
+// checkFallbackTag makes sure tag is an enabled outbound or a portal, which disabled outbounds can be routed to
+func (s *OutboundService) checkFallbackTag(tag string) error {
+	tags, err := s.GetEnabledTags()
+	if err != nil {
+		return err
+	}
+	for _, enabledTag := range tags {
+		if enabledTag == tag {
+			return nil
+		}
+	}
+	var count int64
+	err = database.GetDB().Model(model.ReversePortal{}).Where("tag = ?", tag).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return common.NewValidationError("outbound fallback tag is not an enabled outbound or portal:", tag)
+	}
+	return nil
+}
+
+// HasTag checks outbounds of database and default xray config
+func (s *OutboundService) HasTag(tag string) (bool, error) {
+	return s.hasTag(database.GetDB(), tag)
+}
+
+func (s *OutboundService) hasTag(db *gorm.DB, tag string) (bool, error) {
+	var count int64
+	err := db.Model(model.Outbound{}).Where("tag = ?", tag).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	settingService := SettingService{}
+	xrayConfig, err := settingService.GetXrayDefault()
+	if err != nil {
+		return false, err
+	}
+	for _, outboundConfig := range xrayConfig.OutboundConfigs {
+		var outbound struct {
+			Tag string `json:"tag"`
+		}
+		json.Unmarshal(outboundConfig, &outbound)
+		if outbound.Tag == tag {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (s *OutboundService) Del(id uint) (error, bool) {
 	var err error
 	db := database.GetDB()
 
+	var oldTag string
+	err = db.Model(model.Outbound{}).Select("tag").Where("id = ?", id).Scan(&oldTag).Error
+	if err != nil {
+		return err, false
+	}
+	used, err := reverseUsesOutbound(db, oldTag)
+	if err != nil {
+		return err, false
+	}
+	if used {
+		return common.NewValidationError("outbound is used by a reverse bridge:", oldTag), false
+	}
+
 	needRestart := false
 	err = s.XrayAPI.Init(p.GetAPIServer())
 	defer s.XrayAPI.Close()
 	if err != nil {
 		needRestart = true
 	} else {
-		var outboundTag string
-		err = db.Model(model.Outbound{}).Select("tag").Where("id = ?", id).Scan(&outboundTag).Error
+		var outbound model.Outbound
+		err = db.Model(model.Outbound{}).Select("tag", "enable").Where("id = ?", id).Scan(&outbound).Error
 		if err != nil {
 			needRestart = true
-		} else {
+		} else if outbound.Enable {
+			outboundTag := outbound.Tag
 			err = s.XrayAPI.DelOutbound(outboundTag)
 			if err == nil {
 				logger.Debug("Outbound deleted by api:", outboundTag)
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"raha-xray/config"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/xray"
+	"strings"
+	"testing"
+)
+
+func TestOutboundGuards(t *testing.T) {
+	settings := initTestDB(t)
+	savedProcess, savedDefault := p, xrayDefault
+	p = xray.NewProcess(&xray.Config{})
+	xrayDefault = config.GetDefaultXrayConfig()
+	t.Cleanup(func() { p, xrayDefault = savedProcess, savedDefault })
+	s := &OutboundService{}
+
+	db := database.GetDB()
+	outbounds := []*model.Outbound{
+		{Enable: true, Protocol: "freedom", Tag: "ruled"},
+		{Enable: true, Protocol: "freedom", Tag: "bridged"},
+		{Enable: true, Protocol: "freedom", Tag: "fallback"},
+	}
+	err := db.Create(outbounds).Error
+	if err == nil {
+		err = db.Create(&model.Rule{Enable: true, OutboundTag: "ruled"}).Error
+	}
+	if err == nil {
+		err = db.Create(&model.ReverseBridge{Tag: "bridge", TunnelOutboundTag: "bridged", TargetOutboundTag: "direct"}).Error
+	}
+	if err == nil {
+		err = db.Create(&model.ReversePortal{Tag: "portal"}).Error
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	save := func(index int, tag string, enable bool) error {
+		outbound := *outbounds[index]
+		outbound.Tag = tag
+		outbound.Enable = enable
+		err, _ := s.Save(&outbound)
+		return err
+	}
+
+	// rules of the old tag are found when a save renames and disables
+	err = save(0, "renamed", false)
+	if err == nil || !strings.Contains(err.Error(), "used by 1 rules") {
+		t.Errorf("disabling a renamed outbound of rules: %v", err)
+	}
+	err = save(1, "bridged", false)
+	if err == nil || !strings.Contains(err.Error(), "reverse bridge") {
+		t.Errorf("disabling an outbound of a bridge: %v", err)
+	}
+
+	for tag, valid := range map[string]bool{"fallback": true, "direct": true, "portal": true, "missing": false, "bridge": false} {
+		err = s.checkFallbackTag(tag)
+		if (err == nil) != valid {
+			t.Errorf("fallback tag %s: %v", tag, err)
+		}
+	}
+	invalid := *settings
+	invalid.OutboundFallbackTag = "missing"
+	err = (&SettingService{}).SaveSettings(&invalid)
+	if err == nil {
+		t.Error("missing fallback tag is saved")
+	}
+
+	settings.OutboundFallbackTag = "fallback"
+	config.SetSettings(settings)
+	err = save(2, "fallback", false)
+	if err == nil {
+		t.Error("fallback outbound is disabled")
+	}
+	// with a fallback tag the rules are routed to it
+	err = save(0, "ruled", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.checkFallbackTag("ruled")
+	if err == nil {
+		t.Error("disabled outbound is a valid fallback tag")
+	}
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"raha-xray/config"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/logger"
+	"raha-xray/util/random"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	QuotaCentral = "central"
+	QuotaMysql   = "mysql"
+)
+
+var (
+	// quotaLock guards pendingUsages and sentReport
+	quotaLock sync.Mutex
+	// usage deltas which are not shared yet
+	pendingUsages = make(map[string]*ClientUsage)
+	// the last report to the central instance with an unknown result, it is sent again with its id
+	sentReport *UsageReport
+)
+
+// ClientUsage is a usage delta in reports and the combined usage in responses
+type ClientUsage struct {
+	Name   string `json:"name"`
+	Up     uint64 `json:"up"`
+	Down   uint64 `json:"down"`
+	Enable bool   `json:"enable"`
+}
+
+// UsageReport is sent to the central instance, its id lets the central instance skip a report which is sent again
+type UsageReport struct {
+	Id      string         `json:"id"`
+	Clients []*ClientUsage `json:"clients"`
+}
+
+type QuotaService struct {
+}
+
+// Report sends usage deltas of clients to the central instance or the shared database and returns the combined usage.
+// Deltas are kept until they are written, so they are sent on the next tick if it fails.
+func (s *QuotaService) Report(traffics []*model.Traffic) ([]*ClientUsage, error) {
+	mode := config.GetSettings().QuotaMode
+	if mode == "" {
+		return nil, nil
+	}
+	db := database.GetDB()
+	var names []string
+	err := db.Model(model.Client{}).Pluck("name", &names).Error
+	if err != nil {
+		return nil, err
+	}
+	clientNames := make(map[string]bool, len(names))
+	for _, name := range names {
+		clientNames[name] = true
+	}
+
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+	for _, traffic := range traffics {
+		if traffic.Resource != "user" || !clientNames[traffic.Tag] {
+			continue
+		}
+		usage := pendingUsages[traffic.Tag]
+		if usage == nil {
+			usage = &ClientUsage{Name: traffic.Tag}
+			pendingUsages[traffic.Tag] = usage
+		}
+		if traffic.Direction {
+			usage.Down += traffic.Traffic
+		} else {
+			usage.Up += traffic.Traffic
+		}
+	}
+	if mode == QuotaCentral {
+		return s.reportCentral(names)
+	}
+	return s.reportMysql(names)
+}
+
+// takePendingUsages returns usage deltas of all clients and clears them
+func takePendingUsages(names []string) []*ClientUsage {
+	usages := make([]*ClientUsage, 0, len(names))
+	for _, name := range names {
+		usage := pendingUsages[name]
+		if usage == nil {
+			usage = &ClientUsage{Name: name}
+		}
+		usages = append(usages, usage)
+	}
+	pendingUsages = make(map[string]*ClientUsage)
+	return usages
+}
+
+// Apply replaces local counters by the combined usage, so quota checks of AddTraffic apply to all servers.
+// The central instance is also authoritative for enable state, it returns true if a client state is changed.
+func (s *QuotaService) Apply(tx *gorm.DB, shared []*ClientUsage) (bool, error) {
+	if len(shared) == 0 {
+		return false, nil
+	}
+	central := config.GetSettings().QuotaMode == QuotaCentral
+	var clients []*model.Client
+	err := tx.Model(model.Client{}).Select("id", "name", "enable", "quota", "expiry", "up", "down").Find(&clients).Error
+	if err != nil {
+		return false, err
+	}
+	clientsByName := make(map[string]*model.Client, len(clients))
+	for _, client := range clients {
+		clientsByName[client.Name] = client
+	}
+
+	now := uint64(time.Now().UnixMilli())
+	changed := false
+	for _, usage := range shared {
+		client := clientsByName[usage.Name]
+		if client == nil {
+			continue
+		}
+		updates := make(map[string]interface{})
+		if client.Up != usage.Up || client.Down != usage.Down {
+			updates["up"] = usage.Up
+			updates["down"] = usage.Down
+		}
+		if central {
+			if client.Enable && !usage.Enable {
+				updates["enable"] = false
+			} else if !client.Enable && usage.Enable &&
+				(client.Expiry == 0 || client.Expiry > now) &&
+				(client.Quota == 0 || usage.Up+usage.Down < client.Quota) {
+				updates["enable"] = true
+			}
+			if _, ok := updates["enable"]; ok {
+				logger.Debug("Client state changed by central quota:", client.Name, usage.Enable)
+				changed = true
+			}
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		err = tx.Model(model.Client{}).Where("id = ?", client.Id).Updates(updates).Error
+		if err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+// reportCentral sends the deltas of a failed report again before new ones, the central instance skips it if it is accounted
+func (s *QuotaService) reportCentral(names []string) ([]*ClientUsage, error) {
+	if sentReport == nil {
+		sentReport = &UsageReport{Id: random.Seq(16), Clients: takePendingUsages(names)}
+	}
+	appConfig := config.GetSettings()
+	body, err := json.Marshal(sentReport)
+	if err != nil {
+		return nil, err
+	}
+	var shared []*ClientUsage
+	quotaUrl := strings.TrimSuffix(appConfig.QuotaUrl, "/") + "/quota/report"
+	err = callRemote(quotaUrl, appConfig.QuotaToken, http.MethodPost, body, &shared)
+	if err != nil {
+		return nil, err
+	}
+	sentReport = nil
+	return shared, nil
+}
+
+// reportMysql adds the deltas to the shared database, they are cleared once the write is committed
+func (s *QuotaService) reportMysql(names []string) ([]*ClientUsage, error) {
+	db, err := database.GetQuotaDB()
+	if err != nil {
+		return nil, err
+	}
+	usages := make([]*ClientUsage, 0, len(pendingUsages))
+	for _, usage := range pendingUsages {
+		usages = append(usages, usage)
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, usage := range usages {
+			if usage.Up == 0 && usage.Down == 0 {
+				continue
+			}
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "name"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"up":   gorm.Expr("up + ?", usage.Up),
+					"down": gorm.Expr("down + ?", usage.Down),
+				}),
+			}).Create(&model.SharedUsage{Name: usage.Name, Up: usage.Up, Down: usage.Down}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	pendingUsages = make(map[string]*ClientUsage)
+
+	var sharedUsages []*model.SharedUsage
+	err = db.Model(model.SharedUsage{}).Where("name in ?", names).Find(&sharedUsages).Error
+	if err != nil {
+		return nil, err
+	}
+	shared := make([]*ClientUsage, 0, len(sharedUsages))
+	for _, sharedUsage := range sharedUsages {
+		shared = append(shared, &ClientUsage{
+			Name:   sharedUsage.Name,
+			Up:     sharedUsage.Up,
+			Down:   sharedUsage.Down,
+			Enable: true,
+		})
+	}
+	return shared, nil
+}
+
+// ResetUsage clears combined usage of reset clients in the shared database, it is called after the local reset is committed.
+// In central mode resets are done by the central instance.
+func (s *QuotaService) ResetUsage(names ...string) error {
+	if config.GetSettings().QuotaMode != QuotaMysql || len(names) == 0 {
+		return nil
+	}
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+	for _, name := range names {
+		delete(pendingUsages, name)
+	}
+	db, err := database.GetQuotaDB()
+	if err != nil {
+		return err
+	}
+	return db.Model(model.SharedUsage{}).Where("name in ?", names).
+		Updates(map[string]interface{}{"up": 0, "down": 0}).Error
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"raha-xray/config"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"testing"
+)
+
+func TestReportCentralAgain(t *testing.T) {
+	settings := initTestDB(t)
+	t.Cleanup(func() {
+		pendingUsages = make(map[string]*ClientUsage)
+		sentReport = nil
+	})
+	err := database.GetDB().Create(&model.Client{Name: "a"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reports []*UsageReport
+	fail := true
+	central := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := &UsageReport{}
+		json.NewDecoder(r.Body).Decode(report)
+		reports = append(reports, report)
+		// the report is received, but its response is lost
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "obj": report.Clients})
+	}))
+	t.Cleanup(central.Close)
+	settings.QuotaMode = QuotaCentral
+	settings.QuotaUrl = central.URL
+	config.SetSettings(settings)
+
+	s := &QuotaService{}
+	_, err = s.Report([]*model.Traffic{{Resource: "user", Tag: "a", Traffic: 10}})
+	if err == nil {
+		t.Fatal("failed report returns no error")
+	}
+	fail = false
+	_, err = s.Report([]*model.Traffic{{Resource: "user", Tag: "a", Traffic: 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Report(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reports) != 3 {
+		t.Fatalf("%d reports, want 3", len(reports))
+	}
+	// the failed report is sent again as it was, new deltas follow in the next report
+	if reports[1].Id != reports[0].Id || reports[1].Clients[0].Up != 10 {
+		t.Errorf("report sent again is %+v, first %+v", reports[1], reports[0])
+	}
+	if reports[2].Id == reports[0].Id || reports[2].Clients[0].Up != 5 {
+		t.Errorf("next report is %+v", reports[2])
+	}
+}
+
+func TestAccountReportOnce(t *testing.T) {
+	initTestDB(t)
+	db := database.GetDB()
+	err := db.Create(&model.Client{Name: "a"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &TrafficService{}
+	report := &UsageReport{Id: "report", Clients: []*ClientUsage{{Name: "a", Up: 10, Down: 20}}}
+	for i := 0; i < 2; i++ {
+		shared, err := s.Account(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(shared) != 1 || shared[0].Up != 10 || shared[0].Down != 20 {
+			t.Errorf("shared usage of report %d is %+v", i+1, shared)
+		}
+	}
+	_, err = s.Account(&UsageReport{Id: "next", Clients: []*ClientUsage{{Name: "a", Up: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &model.Client{}
+	db.Where("name = ?", "a").First(client)
+	if client.Up != 11 || client.Down != 20 {
+		t.Errorf("client has %d/%d, want 11/20", client.Up, client.Down)
+	}
+}
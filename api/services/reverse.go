@@ -0,0 +1,239 @@
+package services
+
+import (
+	"encoding/json"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/util/common"
+	"raha-xray/util/json_util"
+
+	"gorm.io/gorm"
+)
+
+type ReverseService struct {
+	InboundService
+	OutboundService
+}
+
+func (s *ReverseService) GetBridges() ([]*model.ReverseBridge, error) {
+	db := database.GetDB()
+	var bridges []*model.ReverseBridge
+	err := db.Model(model.ReverseBridge{}).Find(&bridges).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return bridges, nil
+}
+
+func (s *ReverseService) GetPortals() ([]*model.ReversePortal, error) {
+	db := database.GetDB()
+	var portals []*model.ReversePortal
+	err := db.Model(model.ReversePortal{}).Find(&portals).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return portals, nil
+}
+
+func (s *ReverseService) SaveBridge(bridge *model.ReverseBridge) error {
+	if bridge.Tag == "" || bridge.Domain == "" {
+		return common.NewValidationError("bridge tag and domain are required")
+	}
+	// bridge works as an inbound in routing
+	exists, err := s.InboundService.HasTag(bridge.Tag)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return common.NewValidationError("bridge tag is used by an inbound:", bridge.Tag)
+	}
+	if bridge.TargetOutboundTag == "" {
+		bridge.TargetOutboundTag = "direct"
+	}
+	for _, tag := range []string{bridge.TunnelOutboundTag, bridge.TargetOutboundTag} {
+		exists, err = s.OutboundService.HasTag(tag)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return common.NewValidationError("outbound not found:", tag)
+		}
+	}
+
+	db := database.GetDB()
+	return db.Save(bridge).Error
+}
+
+func (s *ReverseService) SavePortal(portal *model.ReversePortal) error {
+	if portal.Tag == "" || portal.Domain == "" {
+		return common.NewValidationError("portal tag and domain are required")
+	}
+	// portal works as an outbound in routing
+	exists, err := s.OutboundService.HasTag(portal.Tag)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return common.NewValidationError("portal tag is used by an outbound:", portal.Tag)
+	}
+	var clientInboundTags []string
+	err = json.Unmarshal([]byte(portal.ClientInboundTag), &clientInboundTags)
+	if err != nil || len(clientInboundTags) == 0 {
+		return common.NewValidationError("portal client inbound tags should be a non-empty array:", portal.ClientInboundTag)
+	}
+	for _, tag := range append(clientInboundTags, portal.TunnelInboundTag) {
+		exists, err = s.InboundService.HasTag(tag)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return common.NewValidationError("inbound not found:", tag)
+		}
+	}
+
+	db := database.GetDB()
+	return db.Save(portal).Error
+}
+
+func (s *ReverseService) DelBridge(id uint) error {
+	db := database.GetDB()
+	return db.Delete(model.ReverseBridge{}, id).Error
+}
+
+func (s *ReverseService) DelPortal(id uint) error {
+	db := database.GetDB()
+	return db.Delete(model.ReversePortal{}, id).Error
+}
+
+// HasInboundTag checks inbounds and bridges, both can be used as inbound tag in routing
+func (s *ReverseService) HasInboundTag(tag string) (bool, error) {
+	exists, err := s.InboundService.HasTag(tag)
+	if err != nil || exists {
+		return exists, err
+	}
+	db := database.GetDB()
+	var count int64
+	err = db.Model(model.ReverseBridge{}).Where("tag = ?", tag).Count(&count).Error
+	return count > 0, err
+}
+
+// HasOutboundTag checks outbounds and portals, both can be used as outbound tag in routing
+func (s *ReverseService) HasOutboundTag(tag string) (bool, error) {
+	exists, err := s.OutboundService.HasTag(tag)
+	if err != nil || exists {
+		return exists, err
+	}
+	db := database.GetDB()
+	var count int64
+	err = db.Model(model.ReversePortal{}).Where("tag = ?", tag).Count(&count).Error
+	return count > 0, err
+}
+
+// reverseUsesInbound checks tunnel and client inbound tags of portals
+func reverseUsesInbound(tx *gorm.DB, tag string) (bool, error) {
+	var portals []*model.ReversePortal
+	err := tx.Model(model.ReversePortal{}).Find(&portals).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return false, err
+	}
+	for _, portal := range portals {
+		if portal.TunnelInboundTag == tag {
+			return true, nil
+		}
+		var clientInboundTags []string
+		json.Unmarshal([]byte(portal.ClientInboundTag), &clientInboundTags)
+		for _, clientInboundTag := range clientInboundTags {
+			if clientInboundTag == tag {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// reverseUsesOutbound checks tunnel and target outbound tags of bridges
+func reverseUsesOutbound(tx *gorm.DB, tag string) (bool, error) {
+	var count int64
+	err := tx.Model(model.ReverseBridge{}).Where("tunnel_outbound_tag = ? or target_outbound_tag = ?", tag, tag).Count(&count).Error
+	return count > 0, err
+}
+
+// GetReverseConfig appends bridges and portals to the default reverse config
+func (s *ReverseService) GetReverseConfig(reverse json_util.RawMessage) (json_util.RawMessage, error) {
+	bridges, err := s.GetBridges()
+	if err != nil {
+		return nil, err
+	}
+	portals, err := s.GetPortals()
+	if err != nil {
+		return nil, err
+	}
+	if len(bridges) == 0 && len(portals) == 0 {
+		return reverse, nil
+	}
+
+	var reverseConfig struct {
+		Bridges []interface{} `json:"bridges,omitempty"`
+		Portals []interface{} `json:"portals,omitempty"`
+	}
+	if common.NonEmptyValue(string(reverse)) && string(reverse) != "null" {
+		err = json.Unmarshal(reverse, &reverseConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, bridge := range bridges {
+		reverseConfig.Bridges = append(reverseConfig.Bridges, map[string]string{
+			"tag":    bridge.Tag,
+			"domain": bridge.Domain,
+		})
+	}
+	for _, portal := range portals {
+		reverseConfig.Portals = append(reverseConfig.Portals, map[string]string{
+			"tag":    portal.Tag,
+			"domain": portal.Domain,
+		})
+	}
+	return json.Marshal(reverseConfig)
+}
+
+// GetRules returns routing rules which connect bridges and portals to their tunnels
+func (s *ReverseService) GetRules() ([]map[string]interface{}, error) {
+	var rules []map[string]interface{}
+	bridges, err := s.GetBridges()
+	if err != nil {
+		return nil, err
+	}
+	portals, err := s.GetPortals()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bridge := range bridges {
+		rules = append(rules, map[string]interface{}{
+			"type":        "field",
+			"inboundTag":  []string{bridge.Tag},
+			"domain":      []string{"full:" + bridge.Domain},
+			"outboundTag": bridge.TunnelOutboundTag,
+		}, map[string]interface{}{
+			"type":        "field",
+			"inboundTag":  []string{bridge.Tag},
+			"outboundTag": bridge.TargetOutboundTag,
+		})
+	}
+	for _, portal := range portals {
+		var clientInboundTags []string
+		json.Unmarshal([]byte(portal.ClientInboundTag), &clientInboundTags)
+		rules = append(rules, map[string]interface{}{
+			"type":        "field",
+			"inboundTag":  []string{portal.TunnelInboundTag},
+			"domain":      []string{"full:" + portal.Domain},
+			"outboundTag": portal.Tag,
+		}, map[string]interface{}{
+			"type":        "field",
+			"inboundTag":  clientInboundTags,
+			"outboundTag": portal.Tag,
+		})
+	}
+	return rules, nil
+}
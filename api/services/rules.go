@@ -1,44 +1,374 @@
 package services
 
 import (
+	"encoding/json"
+	"raha-xray/config"
 	"raha-xray/database"
 	"raha-xray/database/model"
+	"strings"
 
 	"gorm.io/gorm"
 )
 
 type RuleService struct {
+	BalancerService
+	ReverseService
 }
 
 func (s *RuleService) GetAll() ([]*model.Rule, error) {
 	db := database.GetDB()
 	var rules []*model.Rule
-	err := db.Model(model.Rule{}).Find(&rules).Error
+	err := db.Model(model.Rule{}).Preload("RuleClients").Order("priority, id").Find(&rules).Error
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
+	err = s.flagDisabledOutbounds(rules...)
+	if err != nil {
+		return nil, err
+	}
 	return rules, nil
 }
 
+var ruleListSpec = &listSpec{
+	sorts:       map[string]string{"id": "id", "priority": "priority", "enable": "enable", "outboundTag": "outbound_tag", "balancerTag": "balancer_tag"},
+	defaultSort: "priority, id",
+	nameColumns: []string{"outbound_tag", "balancer_tag"},
+	enable:      true,
+}
+
+// List returns a page of rules matching the query and the count of all matching rules
+func (s *RuleService) List(query *ListQuery) ([]*model.Rule, int64, error) {
+	db := database.GetDB()
+	var rules []*model.Rule
+	total, err := query.find(db.Model(model.Rule{}), ruleListSpec, &rules, func(tx *gorm.DB) *gorm.DB {
+		if query.NoPreload {
+			return tx
+		}
+		return tx.Preload("RuleClients")
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	err = s.flagDisabledOutbounds(rules...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rules, total, nil
+}
+
 func (s *RuleService) Get(id int) (*model.Rule, error) {
 	db := database.GetDB()
 	var rule *model.Rule
-	err := db.Model(model.Rule{}).Where("id = ?", id).Find(&rule).Error
+	err := db.Model(model.Rule{}).Preload("RuleClients").Where("id = ?", id).Find(&rule).Error
 	if err != nil {
 		return nil, err
 	}
 	if rule.Id == 0 {
 		return nil, gorm.ErrRecordNotFound
 	}
+	err = s.flagDisabledOutbounds(rule)
+	if err != nil {
+		return nil, err
+	}
 	return rule, nil
 }
 
+// flagDisabledOutbounds warns about rules which route to a disabled outbound
+func (s *RuleService) flagDisabledOutbounds(rules ...*model.Rule) error {
+	disabledTags, err := s.ReverseService.OutboundService.GetDisabledTags()
+	if err != nil {
+		return err
+	}
+	fallbackTag := config.GetSettings().OutboundFallbackTag
+	for _, rule := range rules {
+		if !disabledTags[rule.OutboundTag] {
+			continue
+		}
+		if fallbackTag != "" {
+			rule.Warning = "outbound " + rule.OutboundTag + " is disabled, routed to " + fallbackTag
+		} else {
+			rule.Warning = "outbound " + rule.OutboundTag + " is disabled, rule is skipped"
+		}
+	}
+	return nil
+}
+
 func (s *RuleService) Save(rule *model.Rule) error {
+	err := s.Validate(rule)
+	if err != nil {
+		return err
+	}
 	db := database.GetDB()
-	return db.Save(rule).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Omit("RuleClients").Save(rule).Error
+		if err != nil {
+			return err
+		}
+		// Replace related clients
+		err = tx.Where("rule_id = ?", rule.Id).Delete(model.RuleClient{}).Error
+		if err != nil {
+			return err
+		}
+		for index := range rule.RuleClients {
+			rule.RuleClients[index].Id = 0
+			rule.RuleClients[index].RuleId = rule.Id
+		}
+		if len(rule.RuleClients) > 0 {
+			return tx.Create(&rule.RuleClients).Error
+		}
+		return nil
+	})
+}
+
+// RulePatch has the fields of a rule which can be changed, nil fields are kept
+type RulePatch struct {
+	Enable        *bool               `json:"enable"`
+	Priority      *int                `json:"priority"`
+	Position      *string             `json:"position"`
+	DomainMatcher *string             `json:"domainMatcher"`
+	Type          *string             `json:"type"`
+	Domain        *model.StringList   `json:"domain"`
+	Ip            *model.StringList   `json:"ip"`
+	Port          *string             `json:"port"`
+	SourcePort    *string             `json:"sourcePort"`
+	Network       *string             `json:"network"`
+	Source        *model.StringList   `json:"source"`
+	User          *model.StringList   `json:"user"`
+	InboundTag    *model.StringList   `json:"inboundTag"`
+	Protocol      *model.StringList   `json:"protocol"`
+	Attrs         *string             `json:"attrs"`
+	OutboundTag   *string             `json:"outboundTag"`
+	BalancerTag   *string             `json:"balancerTag"`
+	RuleClients   *[]model.RuleClient `json:"clients"`
+}
+
+// Patch changes the fields of a rule which are set in the patch, the result is validated like Save
+func (s *RuleService) Patch(id uint, patch *RulePatch) error {
+	rule, err := s.Get(int(id))
+	if err != nil {
+		return err
+	}
+	rule.Warning = ""
+
+	if patch.Enable != nil {
+		rule.Enable = *patch.Enable
+	}
+	if patch.Priority != nil {
+		rule.Priority = *patch.Priority
+	}
+	if patch.Position != nil {
+		rule.Position = *patch.Position
+	}
+	if patch.DomainMatcher != nil {
+		rule.DomainMatcher = *patch.DomainMatcher
+	}
+	if patch.Type != nil {
+		rule.Type = *patch.Type
+	}
+	if patch.Domain != nil {
+		rule.Domain = *patch.Domain
+	}
+	if patch.Ip != nil {
+		rule.Ip = *patch.Ip
+	}
+	if patch.Port != nil {
+		rule.Port = *patch.Port
+	}
+	if patch.SourcePort != nil {
+		rule.SourcePort = *patch.SourcePort
+	}
+	if patch.Network != nil {
+		rule.Network = *patch.Network
+	}
+	if patch.Source != nil {
+		rule.Source = *patch.Source
+	}
+	if patch.User != nil {
+		rule.User = *patch.User
+	}
+	if patch.InboundTag != nil {
+		rule.InboundTag = *patch.InboundTag
+	}
+	if patch.Protocol != nil {
+		rule.Protocol = *patch.Protocol
+	}
+	if patch.Attrs != nil {
+		rule.Attrs = *patch.Attrs
+	}
+	if patch.OutboundTag != nil {
+		rule.OutboundTag = *patch.OutboundTag
+	}
+	if patch.BalancerTag != nil {
+		rule.BalancerTag = *patch.BalancerTag
+	}
+	if patch.RuleClients != nil {
+		rule.RuleClients = *patch.RuleClients
+	}
+	return s.Save(rule)
+}
+
+// Validate checks every matcher of the rule and returns FieldErrors for invalid ones
+func (s *RuleService) Validate(rule *model.Rule) error {
+	errs := FieldErrors{}
+	switch rule.Position {
+	case "":
+		rule.Position = model.RuleAfter
+	case model.RuleBefore, model.RuleAfter:
+	default:
+		errs["position"] = "should be before or after"
+	}
+	if rule.Type != "" && rule.Type != "field" {
+		errs["type"] = "should be field"
+	}
+	if rule.DomainMatcher != "" {
+		errs.Add("domainMatcher", validateEnum([]string{rule.DomainMatcher}, "hybrid", "linear"))
+	}
+	errs.Add("domain", validateDomains(rule.Domain))
+	errs.Add("ip", validateIps(rule.Ip))
+	errs.Add("source", validateIps(rule.Source))
+	if rule.Port != "" {
+		errs.Add("port", validatePorts(rule.Port))
+	}
+	if rule.SourcePort != "" {
+		errs.Add("sourcePort", validatePorts(rule.SourcePort))
+	}
+	if rule.Network != "" {
+		errs.Add("network", validateEnum(strings.Split(rule.Network, ","), "tcp", "udp"))
+	}
+	errs.Add("protocol", validateEnum(rule.Protocol, "http", "tls", "quic", "bittorrent"))
+	for _, user := range rule.User {
+		if user == "" {
+			errs["user"] = "empty user email"
+		}
+	}
+	if rule.Attrs != "" {
+		var attrs map[string]string
+		if json.Unmarshal([]byte(rule.Attrs), &attrs) != nil {
+			errs["attrs"] = "should be an object of strings"
+		}
+	}
+	if len(rule.RuleClients) > 0 {
+		clientIds := make([]uint, 0, len(rule.RuleClients))
+		for _, ruleClient := range rule.RuleClients {
+			clientIds = append(clientIds, ruleClient.ClientId)
+		}
+		var count int64
+		err := database.GetDB().Model(model.Client{}).Where("id IN ?", clientIds).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if int(count) != len(clientIds) {
+			errs["clients"] = "client not found or repeated"
+		}
+	}
+	for _, tag := range rule.InboundTag {
+		exists, err := s.ReverseService.HasInboundTag(tag)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			errs["inboundTag"] = "inbound not found: " + tag
+		}
+	}
+	for field, values := range map[string]model.StringList{
+		"domain": rule.Domain, "ip": rule.Ip, "source": rule.Source,
+		"user": rule.User, "inboundTag": rule.InboundTag, "protocol": rule.Protocol,
+	} {
+		errs.Add(field, validateListValues(values))
+	}
+
+	switch {
+	case rule.OutboundTag == "" && rule.BalancerTag == "":
+		errs["outboundTag"] = "outboundTag or balancerTag is required"
+	case rule.OutboundTag != "" && rule.BalancerTag != "":
+		errs["balancerTag"] = "only one of outboundTag and balancerTag can be set"
+	case rule.OutboundTag != "":
+		exists, err := s.ReverseService.HasOutboundTag(rule.OutboundTag)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			errs["outboundTag"] = "outbound not found: " + rule.OutboundTag
+		}
+	default:
+		exists, err := s.BalancerService.HasTag(rule.BalancerTag)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			errs["balancerTag"] = "balancer not found: " + rule.BalancerTag
+		}
+	}
+	return errs.Err()
+}
+
+// Reorder sets priority of rules by their order in ids
+func (s *RuleService) Reorder(ids []uint) error {
+	var err error
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err == nil {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	for index, id := range ids {
+		err = tx.Model(model.Rule{}).Where("id = ?", id).Update("priority", index).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *RuleService) Del(id uint) error {
 	db := database.GetDB()
-	return db.Delete(model.Rule{}, id).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("rule_id = ?", id).Delete(model.RuleClient{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(model.Rule{}, id).Error
+	})
+}
+
+// GetClientEmails maps ids of clients used in rules to their emails
+func (s *RuleService) GetClientEmails() (map[uint]string, error) {
+	var clients []struct {
+		Id   uint
+		Name string
+	}
+	db := database.GetDB()
+	err := db.Model(model.Client{}).
+		Where("id IN (?)", db.Model(model.RuleClient{}).Select("client_id")).
+		Select("id, name").Scan(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	emails := make(map[uint]string, len(clients))
+	for _, client := range clients {
+		emails[client.Id] = client.Name
+	}
+	return emails, nil
+}
+
+// DelClient removes the client from rules in tx, rules left without any user are disabled instead of matching everyone
+func (s *RuleService) DelClient(tx *gorm.DB, clientId uint) error {
+	var ruleIds []uint
+	err := tx.Model(model.RuleClient{}).Where("client_id = ?", clientId).Pluck("rule_id", &ruleIds).Error
+	if err != nil || len(ruleIds) == 0 {
+		return err
+	}
+	err = tx.Where("client_id = ?", clientId).Delete(model.RuleClient{}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(model.Rule{}).
+		Where("id IN ?", ruleIds).
+		Where("id NOT IN (?)", tx.Model(model.RuleClient{}).Select("rule_id")).
+		Where(map[string]interface{}{"user": ""}).
+		Update("enable", false).Error
 }
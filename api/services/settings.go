@@ -21,6 +21,13 @@ func (s *SettingService) SaveSettings(data *config.Setting) error {
 	if err != nil {
 		return err
 	}
+	if data.OutboundFallbackTag != "" {
+		outboundService := OutboundService{}
+		err = outboundService.checkFallbackTag(data.OutboundFallbackTag)
+		if err != nil {
+			return err
+		}
+	}
 	newSettings, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
 		return err
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"raha-xray/util/common"
+	"sync"
+	"time"
+)
+
+const (
+	StreamStatus  = "status"
+	StreamOnlines = "onlines"
+	StreamTraffic = "traffic"
+	StreamConfig  = "config"
+)
+
+const statusInterval = 5 * time.Second
+
+type StreamEvent struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+type OnlinesChange struct {
+	Onlines []string `json:"onlines"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type streamHub struct {
+	sync.Mutex
+	subscribers map[chan *StreamEvent]bool
+	lastStatus  *Status
+	stop        chan struct{}
+}
+
+var hub = &streamHub{
+	subscribers: make(map[chan *StreamEvent]bool),
+}
+
+type StreamService struct {
+}
+
+// Subscribe returns a channel receiving all stream events, the latest status is sent first
+func (s *StreamService) Subscribe() chan *StreamEvent {
+	hub.Lock()
+	defer hub.Unlock()
+	ch := make(chan *StreamEvent, 16)
+	hub.subscribers[ch] = true
+	if hub.lastStatus != nil {
+		ch <- &StreamEvent{Type: StreamStatus, Data: hub.lastStatus}
+	}
+	if hub.stop == nil {
+		hub.stop = make(chan struct{})
+		go hub.statusLoop(hub.stop)
+	}
+	return ch
+}
+
+func (s *StreamService) Unsubscribe(ch chan *StreamEvent) {
+	hub.Lock()
+	defer hub.Unlock()
+	delete(hub.subscribers, ch)
+	if len(hub.subscribers) == 0 && hub.stop != nil {
+		close(hub.stop)
+		hub.stop = nil
+		hub.lastStatus = nil
+	}
+}
+
+// Publish sends an event to all subscribers, slow subscribers miss events instead of blocking
+func Publish(eventType string, data interface{}) {
+	hub.Lock()
+	defer hub.Unlock()
+	event := &StreamEvent{Type: eventType, Data: data}
+	for ch := range hub.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func hasSubscribers() bool {
+	hub.Lock()
+	defer hub.Unlock()
+	return len(hub.subscribers) > 0
+}
+
+// statusLoop computes server status once per interval for all subscribers
+func (h *streamHub) statusLoop(stop chan struct{}) {
+	defer common.Recover("status stream")
+	serverService := ServerService{}
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	var lastStatus *Status
+	for {
+		status := serverService.GetStatus(lastStatus)
+		lastStatus = status
+		h.Lock()
+		if h.stop == stop {
+			h.lastStatus = status
+		}
+		h.Unlock()
+		Publish(StreamStatus, status)
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func setOnlineClients(onlineClients []string) {
+	oldClients := p.GetOnlineClients()
+	p.SetOnlineClients(onlineClients)
+	if !hasSubscribers() {
+		return
+	}
+
+	change := OnlinesChange{Onlines: onlineClients}
+	oldSet := make(map[string]bool, len(oldClients))
+	for _, client := range oldClients {
+		oldSet[client] = true
+	}
+	newSet := make(map[string]bool, len(onlineClients))
+	for _, client := range onlineClients {
+		newSet[client] = true
+		if !oldSet[client] {
+			change.Added = append(change.Added, client)
+		}
+	}
+	for _, client := range oldClients {
+		if !newSet[client] {
+			change.Removed = append(change.Removed, client)
+		}
+	}
+	if len(change.Added) > 0 || len(change.Removed) > 0 {
+		Publish(StreamOnlines, change)
+	}
+}
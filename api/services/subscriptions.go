@@ -0,0 +1,292 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"raha-xray/logger"
+	"raha-xray/util/common"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/infra/conf"
+	"gorm.io/gorm"
+)
+
+var tagInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.\-]+`)
+
+type ImportResult struct {
+	Outbounds []*model.Outbound `json:"outbounds"`
+	Disabled  []*model.Outbound `json:"disabled"`
+	Errors    []string          `json:"errors"`
+}
+
+type SubscriptionService struct {
+	OutboundService
+}
+
+func (s *SubscriptionService) GetAll() ([]*model.Subscription, error) {
+	db := database.GetDB()
+	var subscriptions []*model.Subscription
+	err := db.Model(model.Subscription{}).Find(&subscriptions).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+var subscriptionListSpec = &listSpec{
+	sorts:       map[string]string{"id": "id", "url": "url", "prefix": "prefix", "lastUpdate": "last_update"},
+	defaultSort: "id",
+	nameColumns: []string{"url", "prefix"},
+}
+
+// List returns a page of subscriptions matching the query and the count of all matching subscriptions
+func (s *SubscriptionService) List(query *ListQuery) ([]*model.Subscription, int64, error) {
+	db := database.GetDB()
+	var subscriptions []*model.Subscription
+	total, err := query.find(db.Model(model.Subscription{}), subscriptionListSpec, &subscriptions, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return subscriptions, total, nil
+}
+
+func (s *SubscriptionService) Get(id uint) (*model.Subscription, error) {
+	db := database.GetDB()
+	subscription := &model.Subscription{}
+	err := db.Model(model.Subscription{}).Where("id = ?", id).Find(subscription).Error
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Id == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return subscription, nil
+}
+
+// Save stores the subscription and imports its outbounds
+func (s *SubscriptionService) Save(subscription *model.Subscription) (*ImportResult, error, bool) {
+	subUrl, err := url.Parse(subscription.Url)
+	if err != nil || (subUrl.Scheme != "http" && subUrl.Scheme != "https") {
+		return nil, common.NewValidationError("subscription url is not valid:", subscription.Url), false
+	}
+	db := database.GetDB()
+	err = db.Save(subscription).Error
+	if err != nil {
+		return nil, err, false
+	}
+	return s.Update(subscription.Id)
+}
+
+// Del removes the subscription, its outbounds are kept as normal outbounds
+func (s *SubscriptionService) Del(id uint) error {
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(model.Outbound{}).Where("subscription_id = ?", id).
+			Updates(map[string]interface{}{"subscription_id": 0, "subscription_key": ""}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(model.Subscription{}, id).Error
+	})
+}
+
+// Update fetches the subscription and updates its outbounds in place
+func (s *SubscriptionService) Update(id uint) (*ImportResult, error, bool) {
+	subscription, err := s.Get(id)
+	if err != nil {
+		return nil, err, false
+	}
+	body, err := s.fetch(subscription.Url)
+	if err != nil {
+		return nil, err, false
+	}
+	result, err, needRestart := s.Import(body, subscription.Prefix, subscription.Id)
+	if err != nil {
+		return nil, err, needRestart
+	}
+	db := database.GetDB()
+	err = db.Model(subscription).Update("last_update", time.Now().UnixMilli()).Error
+	return result, err, needRestart
+}
+
+// UpdateAll refreshes every subscription, failures are logged and skipped
+func (s *SubscriptionService) UpdateAll() bool {
+	subscriptions, err := s.GetAll()
+	if err != nil {
+		logger.Warning("Unable to load outbound subscriptions:", err)
+		return false
+	}
+	needRestart := false
+	for _, subscription := range subscriptions {
+		_, err, restart := s.Update(subscription.Id)
+		if err != nil {
+			logger.Warning("Unable to update outbound subscription:", subscription.Url, err)
+		}
+		needRestart = needRestart || restart
+	}
+	return needRestart
+}
+
+func (s *SubscriptionService) fetch(subUrl string) (string, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(subUrl)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", common.NewErrorf("subscription download status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	return string(data), err
+}
+
+// Import creates outbounds from share links, outbounds of the subscription with the same key are updated instead.
+// Outbounds of the subscription whose key is not in the links anymore are disabled. All changes are saved in one
+// transaction and applied to xray by restart.
+func (s *SubscriptionService) Import(text string, prefix string, subscriptionId uint) (*ImportResult, error, bool) {
+	links, errs := ParseShareLinks(text)
+	result := &ImportResult{}
+	for _, err := range errs {
+		result.Errors = append(result.Errors, strings.TrimSpace(err.Error()))
+	}
+	if len(links) == 0 {
+		return nil, common.NewValidationError("no valid share link found:", strings.Join(result.Errors, "; ")), false
+	}
+
+	needRestart := false
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		existing := make(map[string]*model.Outbound)
+		if subscriptionId > 0 {
+			var outbounds []*model.Outbound
+			err := tx.Model(model.Outbound{}).Where("subscription_id = ?", subscriptionId).Find(&outbounds).Error
+			if err != nil {
+				return err
+			}
+			for _, outbound := range outbounds {
+				existing[outbound.SubscriptionKey] = outbound
+			}
+		}
+
+		keys := make(map[string]int)
+		usedTags := make(map[string]bool)
+		for _, link := range links {
+			err := validateOutbound(link.Outbound)
+			if err != nil {
+				result.Errors = append(result.Errors, link.Name+": "+strings.TrimSpace(err.Error()))
+				continue
+			}
+			key := link.Name
+			if key == "" {
+				key = link.Outbound.Protocol
+			}
+			keys[key]++
+			if keys[key] > 1 {
+				key += "#" + strconv.Itoa(keys[key])
+			}
+
+			outbound := existing[key]
+			if outbound != nil {
+				changed := outbound.Protocol != link.Outbound.Protocol ||
+					outbound.Settings != link.Outbound.Settings ||
+					outbound.StreamSettings != link.Outbound.StreamSettings
+				usedTags[outbound.Tag] = true
+				result.Outbounds = append(result.Outbounds, outbound)
+				if !changed {
+					continue
+				}
+				outbound.Protocol = link.Outbound.Protocol
+				outbound.Settings = link.Outbound.Settings
+				outbound.StreamSettings = link.Outbound.StreamSettings
+			} else {
+				outbound = link.Outbound
+				outbound.SubscriptionId = subscriptionId
+				if subscriptionId > 0 {
+					outbound.SubscriptionKey = key
+				}
+				outbound.Tag, err = s.uniqueTag(tx, prefix, key, usedTags)
+				if err != nil {
+					return err
+				}
+				usedTags[outbound.Tag] = true
+				result.Outbounds = append(result.Outbounds, outbound)
+			}
+
+			err, restart := s.OutboundService.save(tx, outbound, false)
+			if err != nil {
+				return err
+			}
+			needRestart = needRestart || restart
+		}
+
+		for key, outbound := range existing {
+			if keys[key] > 0 || !outbound.Enable {
+				continue
+			}
+			outbound.Enable = false
+			err, restart := s.OutboundService.save(tx, outbound, false)
+			var validationError *common.ValidationError
+			if errors.As(err, &validationError) {
+				// outbounds which are still needed by routing are kept
+				result.Errors = append(result.Errors, key+": "+strings.TrimSpace(err.Error()))
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			needRestart = needRestart || restart
+			result.Disabled = append(result.Disabled, outbound)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err, false
+	}
+	return result, nil, needRestart
+}
+
+func (s *SubscriptionService) uniqueTag(tx *gorm.DB, prefix string, name string, usedTags map[string]bool) (string, error) {
+	base := strings.Trim(tagInvalidChars.ReplaceAllString(name, "-"), "-")
+	if base == "" {
+		base = "outbound"
+	}
+	if prefix != "" {
+		base = prefix + "-" + base
+	}
+	tag := base
+	for index := 2; ; index++ {
+		exists, err := s.OutboundService.hasTag(tx, tag)
+		if err != nil {
+			return "", err
+		}
+		if !exists && !usedTags[tag] {
+			return tag, nil
+		}
+		tag = base + "-" + strconv.Itoa(index)
+	}
+}
+
+// validateOutbound builds the outbound by xray to catch broken links before saving
+func validateOutbound(outbound *model.Outbound) error {
+	outboundService := OutboundService{}
+	outboundJSON, err := outboundService.GetOutboundConfig(outbound)
+	if err != nil {
+		return err
+	}
+	var outboundConfig conf.OutboundDetourConfig
+	err = json.Unmarshal(*outboundJSON, &outboundConfig)
+	if err != nil {
+		return err
+	}
+	_, err = outboundConfig.Build()
+	return err
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"raha-xray/config"
+	"raha-xray/database"
+	"raha-xray/database/model"
+	"sync"
+	"testing"
+)
+
+func TestSubscriptionUpdate(t *testing.T) {
+	initTestDB(t)
+	savedDefault := xrayDefault
+	xrayDefault = config.GetDefaultXrayConfig()
+	t.Cleanup(func() { xrayDefault = savedDefault })
+	db := database.GetDB()
+	var mutex sync.Mutex
+	body := "vless://11111111-1111-1111-1111-111111111111@a.example.com:443#a\n" +
+		"trojan://pass@b.example.com:443#b\n" +
+		"trojan://pass@c.example.com:443#c\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(body))))
+	}))
+	t.Cleanup(server.Close)
+
+	s := &SubscriptionService{}
+	result, err, _ := s.Save(&model.Subscription{Url: server.URL, Prefix: "sub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Outbounds) != 3 || result.Outbounds[0].Tag != "sub-a" {
+		t.Fatalf("imported outbounds %+v", result.Outbounds)
+	}
+	err = db.Create(&model.Rule{Enable: true, OutboundTag: "sub-c"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b and c are removed upstream, c is kept for its rule
+	mutex.Lock()
+	body = "vless://11111111-1111-1111-1111-111111111111@a2.example.com:443#a\n"
+	mutex.Unlock()
+	result, err, needRestart := s.Update(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !needRestart || len(result.Disabled) != 1 || result.Disabled[0].Tag != "sub-b" || len(result.Errors) != 1 {
+		t.Errorf("update disabled %v with errors %v, restart %v", result.Disabled, result.Errors, needRestart)
+	}
+	enables := make(map[string]bool)
+	var outbounds []*model.Outbound
+	db.Find(&outbounds)
+	for _, outbound := range outbounds {
+		enables[outbound.Tag] = outbound.Enable
+	}
+	if len(outbounds) != 3 || !enables["sub-a"] || enables["sub-b"] || !enables["sub-c"] {
+		t.Errorf("outbounds after update %v", enables)
+	}
+	if lookupJSON(t, outbounds[0].Settings, "vnext.0.address") != "a2.example.com" {
+		t.Errorf("outbound a is not updated: %s", outbounds[0].Settings)
+	}
+
+	// unchanged subscriptions change nothing
+	_, err, needRestart = s.Update(1)
+	if err != nil || needRestart {
+		t.Errorf("unchanged update restarts %v, %v", needRestart, err)
+	}
+
+	// an import which fails on the way changes nothing
+	mutex.Lock()
+	body = "trojan://pass@d.example.com:443#d\nvless://11111111-1111-1111-1111-111111111111@a3.example.com:443#a\n"
+	mutex.Unlock()
+	err = db.Exec("CREATE TRIGGER fail_a BEFORE UPDATE ON outbounds WHEN NEW.tag = 'sub-a' BEGIN SELECT RAISE(ABORT, 'failed'); END").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err, _ = s.Update(1)
+	if err == nil {
+		t.Fatal("failed import returns no error")
+	}
+	var count int64
+	db.Model(model.Outbound{}).Count(&count)
+	if count != 3 {
+		t.Errorf("%d outbounds after a failed import, want 3", count)
+	}
+}
@@ -7,13 +7,44 @@ import (
 	"raha-xray/database/model"
 	"raha-xray/logger"
 	"raha-xray/xray"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// to measure online time between two traffic ticks
+var lastTrafficTick int64
+
+// usage is reported by other servers since the last traffic tick, so the tick checks quotas without local traffic
+var reportedUsage atomic.Bool
+
+// ids of accounted usage reports with their time, reports with an unknown result are sent again with the same id
+var accountedReports sync.Map
+
+// accountedReportTTL is how long ids of accounted reports are kept
+const accountedReportTTL = int64(time.Hour / time.Millisecond)
+
+func pruneAccountedReports(now int64) {
+	accountedReports.Range(func(id, accountedAt interface{}) bool {
+		if now-accountedAt.(int64) > accountedReportTTL {
+			accountedReports.Delete(id)
+		}
+		return true
+	})
+}
+
+// count of clients in each traffic update statement, it keeps statements under placeholder limits of databases
+const clientUpdateBatch = 500
+
 type TrafficService struct {
 	xray.XrayAPI
+	HistoryService
+	NotificationService
+	QuotaService
 }
 
 func (s *TrafficService) GetTraffics(resource string, tag string) ([]*model.Traffic, error) {
@@ -28,14 +59,23 @@ func (s *TrafficService) GetTraffics(resource string, tag string) ([]*model.Traf
 	return traffics, nil
 }
 
-func (s *TrafficService) AddTraffic(traffics []*model.Traffic) (error, bool) {
-	if len(traffics) == 0 {
+// AddTraffic accounts traffics of clients, last counters of nodes are saved with them in one transaction
+func (s *TrafficService) AddTraffic(traffics []*model.Traffic, nodeCounters []*model.NodeTraffic) (error, bool) {
+	var err, err1 error
+
+	// Share usage with other servers before locking the database
+	shared, err1 := s.QuotaService.Report(traffics)
+	if err1 != nil {
+		logger.Warning("Unable to share client usage:", err1)
+	}
+	reported := reportedUsage.Swap(false)
+	if len(traffics) == 0 && len(shared) == 0 && len(nodeCounters) == 0 && !reported {
 		// Empty onlineUsers
-		p.SetOnlineClients(nil)
+		setOnlineClients(nil)
 		return nil, false
 	}
-	var err, err1 error
 	var onlineClients []string
+	var events []*Event
 
 	db := database.GetDB()
 	tx := db.Begin()
@@ -44,12 +84,34 @@ func (s *TrafficService) AddTraffic(traffics []*model.Traffic) (error, bool) {
 		s.XrayAPI.Close()
 		if err != nil {
 			tx.Rollback()
-		} else {
-			tx.Commit()
+			return
+		}
+		err1 := tx.Commit().Error
+		if err1 != nil {
+			logger.Warning("Unable to commit client traffics:", err1)
+			return
+		}
+		// shared usage is reset only when the local reset is committed
+		var resetNames []string
+		for _, event := range events {
+			if event.Type == EventReset {
+				resetNames = append(resetNames, event.Client.Name)
+			}
 		}
+		err1 = s.QuotaService.ResetUsage(resetNames...)
+		if err1 != nil {
+			logger.Warning("Unable to reset shared client usage:", err1)
+		}
+		// events of uncommitted changes are never sent
+		s.NotificationService.Send(events...)
 	}()
 
 	now := time.Now().UnixMilli()
+	onlineSeconds := int64(10)
+	if lastTrafficTick > 0 && now-lastTrafficTick < 60000 {
+		onlineSeconds = (now - lastTrafficTick) / 1000
+	}
+	lastTrafficTick = now
 	needRestart := false
 	err1 = s.XrayAPI.Init(p.GetAPIServer())
 	if err1 != nil {
@@ -58,7 +120,7 @@ func (s *TrafficService) AddTraffic(traffics []*model.Traffic) (error, bool) {
 	}
 
 	// Reset Expiry for repeatable clients
-	err, needRestart = s.resetClients(tx, needRestart)
+	events, err, needRestart = s.resetClients(tx, needRestart)
 	if err != nil {
 		return err, needRestart
 	}
@@ -69,28 +131,26 @@ func (s *TrafficService) AddTraffic(traffics []*model.Traffic) (error, bool) {
 		return err, needRestart
 	}
 
-	for _, traffic := range traffics {
-		if traffic.Resource == "user" {
-			if traffic.Direction {
-				// Add user in onlineUsers array on download
-				onlineClients = append(onlineClients, traffic.Tag)
+	onlineClients, err = s.addClientTraffics(tx, traffics, now, onlineSeconds)
+	if err != nil {
+		return err, false
+	}
 
-				err = tx.Model(&model.Client{}).Where("name = ?", traffic.Tag).
-					Updates(map[string]interface{}{
-						"down": gorm.Expr("down + ?", traffic.Traffic),
-					}).Error
-			} else {
-				err = tx.Model(&model.Client{}).Where("name = ?", traffic.Tag).
-					Updates(map[string]interface{}{
-						"up": gorm.Expr("up + ?", traffic.Traffic),
-					}).Error
-			}
-			if err != nil {
-				return err, false
-			}
+	if len(nodeCounters) > 0 {
+		err = tx.Save(nodeCounters).Error
+		if err != nil {
+			return err, false
 		}
 	}
 
+	// Combined usage of shared quota replaces local counters
+	var stateChanged bool
+	stateChanged, err = s.QuotaService.Apply(tx, shared)
+	if err != nil {
+		return err, needRestart
+	}
+	needRestart = needRestart || stateChanged
+
 	if !needRestart {
 		// remove finished clients with API
 		var clientTags []struct {
@@ -108,7 +168,8 @@ func (s *TrafficService) AddTraffic(traffics []*model.Traffic) (error, bool) {
 					FROM clients
 					WHERE enable = ? and ((quota > 0 and up + down >= quota) or (expiry > 0 and expiry <= ?))
 					) AS client ON client_inbounds.client_id = client.id
-				) AS clientInbound ON inbounds.id = clientInbound.inbound_id;
+				) AS clientInbound ON inbounds.id = clientInbound.inbound_id
+			WHERE inbounds.node_id = 0;
 			`, true, now).Scan(&clientTags).Error
 		if err1 != nil {
 			logger.Debug("Failed to find finished clients:", err1)
@@ -126,20 +187,44 @@ func (s *TrafficService) AddTraffic(traffics []*model.Traffic) (error, bool) {
 		}
 	}
 
-	result := tx.Model(model.Client{}).
+	var finishedClients []*model.Client
+	err = tx.Model(model.Client{}).
 		Where("((quota > 0 and up + down >= quota) or (expiry > 0 and expiry <= ?)) and enable = ?", now, true).
-		Update("enable", false)
-	err = result.Error
+		Find(&finishedClients).Error
 	if err != nil {
-		logger.Warning("Error in disabling invalid clients:", err)
-	} else if result.RowsAffected > 0 {
-		logger.Debugf("%v clients disabled", result.RowsAffected)
+		return err, needRestart
+	}
+
+	var finishedIds []uint
+	var disabledEvents []*Event
+	for _, client := range finishedClients {
+		// Keep usage of expired clients which are not going to be reset
+		if client.Reset == 0 && client.Expiry > 0 && client.Expiry <= uint64(now) {
+			err = s.HistoryService.Record(tx, client, model.HistoryExpired)
+			if err != nil {
+				return err, needRestart
+			}
+		}
+		finishedIds = append(finishedIds, client.Id)
+		client.Enable = false
+		disabledEvents = append(disabledEvents, NewEvent(EventDisabled, client, 0))
+	}
+
+	if len(finishedIds) > 0 {
+		result := tx.Model(model.Client{}).Where("id in ?", finishedIds).Update("enable", false)
+		err = result.Error
+		if err != nil {
+			logger.Warning("Error in disabling invalid clients:", err)
+		} else if result.RowsAffected > 0 {
+			logger.Debugf("%v clients disabled", result.RowsAffected)
+			events = append(events, disabledEvents...)
+		}
 	}
 
 	appConfig := config.GetSettings()
 
 	// Store all traffics if it is enabled
-	if appConfig.TrafficDays != 0 {
+	if appConfig.TrafficDays != 0 && len(traffics) > 0 {
 		err = tx.Save(traffics).Error
 		if err != nil {
 			return err, needRestart
@@ -147,22 +232,141 @@ func (s *TrafficService) AddTraffic(traffics []*model.Traffic) (error, bool) {
 	}
 
 	// Set onlineUsers
-	p.SetOnlineClients(onlineClients)
+	setOnlineClients(onlineClients)
 
 	return nil, needRestart
 }
 
-func (s *TrafficService) resetClients(tx *gorm.DB, needRestart bool) (error, bool) {
+// Account adds usage deltas which are reported by another server to clients in the request,
+// and returns the current usage and state of the clients known by this instance.
+// Finished clients are disabled by the next traffic tick. A report which is sent again with an accounted id is not added again.
+func (s *TrafficService) Account(report *UsageReport) ([]*ClientUsage, error) {
+	now := time.Now().UnixMilli()
+	accounted := false
+	if report.Id != "" {
+		pruneAccountedReports(now)
+		_, accounted = accountedReports.LoadOrStore(report.Id, now)
+	}
+	var names []string
+	var traffics []*model.Traffic
+	for _, usage := range report.Clients {
+		names = append(names, usage.Name)
+		if accounted {
+			continue
+		}
+		if usage.Up > 0 {
+			traffics = append(traffics, &model.Traffic{DateTime: uint64(now), Resource: "user", Tag: usage.Name, Direction: false, Traffic: usage.Up})
+		}
+		if usage.Down > 0 {
+			traffics = append(traffics, &model.Traffic{DateTime: uint64(now), Resource: "user", Tag: usage.Name, Direction: true, Traffic: usage.Down})
+		}
+	}
+
+	db := database.GetDB()
+	if len(traffics) > 0 {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			err := s.firstUsageExpiration(tx, traffics)
+			if err != nil {
+				return err
+			}
+			// online time is counted by the reporting server
+			_, err = s.addClientTraffics(tx, traffics, now, 0)
+			if err != nil {
+				return err
+			}
+			if config.GetSettings().TrafficDays != 0 {
+				return tx.Create(traffics).Error
+			}
+			return nil
+		})
+		if err != nil {
+			// the reporter sends it again
+			accountedReports.Delete(report.Id)
+			return nil, err
+		}
+		reportedUsage.Store(true)
+	}
+
+	var shared []*ClientUsage
+	err := db.Model(model.Client{}).Select("name", "up", "down", "enable").Where("name in ?", names).Find(&shared).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return shared, nil
+}
+
+// addClientTraffics adds traffic counters of clients with CASE based updates, each statement updates a batch of clients.
+// Clients with download traffic are online, their names are returned.
+func (s *TrafficService) addClientTraffics(tx *gorm.DB, traffics []*model.Traffic, now int64, onlineSeconds int64) ([]string, error) {
+	var names, onlineClients []string
+	ups := make(map[string]uint64)
+	downs := make(map[string]uint64)
+	for _, traffic := range traffics {
+		if traffic.Resource != "user" {
+			continue
+		}
+		_, hasUp := ups[traffic.Tag]
+		_, hasDown := downs[traffic.Tag]
+		if !hasUp && !hasDown {
+			names = append(names, traffic.Tag)
+		}
+		if traffic.Direction {
+			if !hasDown {
+				onlineClients = append(onlineClients, traffic.Tag)
+			}
+			downs[traffic.Tag] += traffic.Traffic
+		} else {
+			ups[traffic.Tag] += traffic.Traffic
+		}
+	}
+
+	for start := 0; start < len(names); start += clientUpdateBatch {
+		batch := names[start:min(start+clientUpdateBatch, len(names))]
+		var upCase, downCase strings.Builder
+		var upArgs, downArgs []interface{}
+		var onlines []string
+		for _, name := range batch {
+			if up, ok := ups[name]; ok {
+				upCase.WriteString(" WHEN ? THEN up + ?")
+				upArgs = append(upArgs, name, up)
+			}
+			if down, ok := downs[name]; ok {
+				downCase.WriteString(" WHEN ? THEN down + ?")
+				downArgs = append(downArgs, name, down)
+				onlines = append(onlines, name)
+			}
+		}
+		updates := make(map[string]interface{})
+		if len(upArgs) > 0 {
+			updates["up"] = gorm.Expr("CASE name"+upCase.String()+" ELSE up END", upArgs...)
+		}
+		if len(downArgs) > 0 {
+			updates["down"] = gorm.Expr("CASE name"+downCase.String()+" ELSE down END", downArgs...)
+			updates["last_online"] = gorm.Expr("CASE WHEN name IN ? THEN ? ELSE last_online END", onlines, now)
+			updates["online_time"] = gorm.Expr("CASE WHEN name IN ? THEN online_time + ? ELSE online_time END", onlines, onlineSeconds)
+		}
+		err := tx.Model(&model.Client{}).Where("name in ?", batch).Updates(updates).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return onlineClients, nil
+}
+
+// resetClients starts a new usage period of repeatable clients and returns their reset events
+func (s *TrafficService) resetClients(tx *gorm.DB, needRestart bool) ([]*Event, error, bool) {
 	var clients []*model.Client
 	var err, err1 error
 
-	err = tx.Model(model.Client{}).Where("`reset` > 0 and expiry > 0 and expiry < ?", time.Now().UnixMilli()).Preload("client_inbounds").Scan(clients).Error
+	err = tx.Model(model.Client{}).Where(clause.Gt{Column: clause.Column{Name: "reset"}, Value: 0}).
+		Where("expiry > 0 and expiry < ?", time.Now().UnixMilli()).Preload("ClientInbounds").Find(&clients).Error
 	if err != nil && err != gorm.ErrRecordNotFound {
-		return err, true
+		return nil, err, true
 	}
 	if len(clients) == 0 {
-		return nil, needRestart
+		return nil, nil, needRestart
 	}
+	var events []*Event
 	for _, client := range clients {
 		if !client.Enable && !needRestart {
 			client.Enable = true
@@ -178,7 +382,7 @@ func (s *TrafficService) resetClients(tx *gorm.DB, needRestart bool) (error, boo
 					json.Unmarshal([]byte(clientInbound.Config), &clientConfig)
 					clientConfig["email"] = client.Name
 
-					if !needRestart {
+					if !needRestart && inbound.NodeId == 0 {
 						// Call API
 						err1 = s.XrayAPI.AddUser(string(inbound.Config.Protocol), inbound.Tag, clientConfig)
 						if err1 == nil {
@@ -191,12 +395,25 @@ func (s *TrafficService) resetClients(tx *gorm.DB, needRestart bool) (error, boo
 				}
 			}
 		}
+		err = s.HistoryService.Record(tx, client, model.HistoryReset)
+		if err != nil {
+			return nil, err, needRestart
+		}
+		err = s.NotificationService.ClearAlerts(tx, client.Id)
+		if err != nil {
+			return nil, err, needRestart
+		}
 		client.Up = 0
 		client.Down = 0
 		client.Expiry = uint64(time.Now().AddDate(0, 0, int(client.Reset)).UnixMilli())
+		events = append(events, NewEvent(EventReset, client, 0))
 	}
 
-	return tx.Save(clients).Error, needRestart
+	err = tx.Save(clients).Error
+	if err != nil {
+		return nil, err, needRestart
+	}
+	return events, nil, needRestart
 }
 
 func (s *TrafficService) firstUsageExpiration(tx *gorm.DB, traffics []*model.Traffic) error {
@@ -218,7 +435,8 @@ func (s *TrafficService) firstUsageExpiration(tx *gorm.DB, traffics []*model.Tra
 	if err != nil {
 		return err
 	}
-	return nil
+	return tx.Model(&model.Client{}).Where("first_used = 0 and name in ?", clientEmails).
+		Update("first_used", now).Error
 }
 
 func (s *TrafficService) DelOldTraffics() int64 {
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"net"
+	"net/url"
+	"raha-xray/util/common"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FieldErrors maps invalid fields to their validation messages
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	messages := make([]string, 0, len(e))
+	for _, field := range fields {
+		messages = append(messages, field+": "+e[field])
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add keeps the error of field if it is not nil
+func (e FieldErrors) Add(field string, err error) {
+	if err != nil {
+		e[field] = strings.TrimSpace(err.Error())
+	}
+}
+
+// Err returns nil when there is no field error
+func (e FieldErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// validateListValues rejects a JSON array which is bound as one value of a list, like form values of domain=["a","b"]
+func validateListValues(values []string) error {
+	for _, value := range values {
+		if strings.HasPrefix(strings.TrimSpace(value), "[") {
+			return common.NewValidationError("should be separate values instead of a JSON array:", value)
+		}
+	}
+	return nil
+}
+
+var domainPrefixes = []string{"domain:", "full:", "keyword:", "dotless:", "geosite:", "ext:"}
+
+// validateDomains checks xray domain matchers like domain:, full:, regexp: and geosite:
+func validateDomains(domains []string) error {
+	for _, domain := range domains {
+		if domain == "" {
+			return common.NewValidationError("empty domain matcher")
+		}
+		if strings.HasPrefix(domain, "regexp:") {
+			_, err := regexp.Compile(strings.TrimPrefix(domain, "regexp:"))
+			if err != nil {
+				return common.NewValidationErrorf("invalid regexp %s: %v", domain, err)
+			}
+			continue
+		}
+		for _, prefix := range domainPrefixes {
+			if strings.HasPrefix(domain, prefix) {
+				if len(domain) == len(prefix) {
+					return common.NewValidationError("empty domain matcher:", domain)
+				}
+				break
+			}
+		}
+		if strings.HasPrefix(domain, "ext:") && strings.Count(domain, ":") != 2 {
+			return common.NewValidationError("ext matcher should be ext:file:tag:", domain)
+		}
+	}
+	return nil
+}
+
+// validateIps checks ips, CIDRs and geoip:/ext: matchers
+func validateIps(ips []string) error {
+	for _, ip := range ips {
+		switch {
+		case strings.HasPrefix(ip, "geoip:"):
+			if len(ip) == len("geoip:") || ip == "geoip:!" {
+				return common.NewValidationError("empty geoip matcher:", ip)
+			}
+		case strings.HasPrefix(ip, "ext:"):
+			if strings.Count(ip, ":") != 2 {
+				return common.NewValidationError("ext matcher should be ext:file:tag:", ip)
+			}
+		case strings.Contains(ip, "/"):
+			_, _, err := net.ParseCIDR(ip)
+			if err != nil {
+				return common.NewValidationError("invalid CIDR:", ip)
+			}
+		default:
+			if net.ParseIP(ip) == nil {
+				return common.NewValidationError("invalid ip:", ip)
+			}
+		}
+	}
+	return nil
+}
+
+var hostnameRegex = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9\-]{0,61}[A-Za-z0-9])?\.)*[A-Za-z0-9]([A-Za-z0-9\-]{0,61}[A-Za-z0-9])?\.?$`)
+
+// validateHostname checks a plain domain name like dns.google
+func validateHostname(name string) error {
+	if len(name) > 253 || !hostnameRegex.MatchString(name) {
+		return common.NewValidationError("invalid domain name:", name)
+	}
+	return nil
+}
+
+// validateDnsAddress checks xray DNS server address forms
+func validateDnsAddress(address string) error {
+	switch address {
+	case "localhost", "fakedns":
+		return nil
+	case "":
+		return common.NewValidationError("empty dns server address")
+	}
+	if net.ParseIP(address) != nil {
+		return nil
+	}
+	if !strings.Contains(address, "://") {
+		if validateHostname(address) != nil {
+			return common.NewValidationError("invalid dns server address:", address)
+		}
+		return nil
+	}
+	dnsUrl, err := url.Parse(address)
+	if err != nil || dnsUrl.Host == "" {
+		return common.NewValidationError("invalid dns server address:", address)
+	}
+	switch dnsUrl.Scheme {
+	case "https", "https+local", "tcp", "tcp+local", "quic", "quic+local":
+	default:
+		return common.NewValidationError("unsupported dns server scheme:", dnsUrl.Scheme)
+	}
+	return nil
+}
+
+// validatePorts checks xray port lists like "53,443,1000-2000"
+func validatePorts(ports string) error {
+	for _, port := range strings.Split(ports, ",") {
+		port = strings.TrimSpace(port)
+		bounds := strings.SplitN(port, "-", 2)
+		from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil || from < 1 || from > 65535 {
+			return common.NewValidationError("invalid port:", port)
+		}
+		if len(bounds) == 2 {
+			to, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+			if err != nil || to < from || to > 65535 {
+				return common.NewValidationError("invalid port range:", port)
+			}
+		}
+	}
+	return nil
+}
+
+// validateEnum checks comma separated values against allowed ones
+func validateEnum(values []string, allowed ...string) error {
+	for _, value := range values {
+		found := false
+		for _, item := range allowed {
+			if strings.TrimSpace(value) == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return common.NewValidationErrorf("%q should be one of %s", value, strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
@@ -2,7 +2,7 @@ package services
 
 import (
 	"encoding/json"
-	"errors"
+	"raha-xray/config"
 	"raha-xray/database/model"
 	"raha-xray/logger"
 	"raha-xray/util/common"
@@ -19,6 +19,10 @@ type XrayService struct {
 	OutboundService
 	SettingService
 	RuleService
+	BalancerService
+	ObservatoryService
+	DnsService
+	ReverseService
 	xray.XrayAPI
 }
 
@@ -55,9 +59,86 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 	xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, inboundConfigs...)
 	xrayConfig.OutboundConfigs = append(xrayConfig.OutboundConfigs, outboundConfigs...)
 	xrayConfig.RoutingConfig = routingConfigs
+	err = s.getObservatories(xrayConfig)
+	if err != nil {
+		return nil, err
+	}
+	xrayConfig.DNSConfig, err = s.DnsService.GetDnsConfig(xrayConfig.DNSConfig)
+	if err != nil {
+		return nil, err
+	}
+	xrayConfig.FakeDNS, err = s.DnsService.GetFakeDnsConfig(xrayConfig.FakeDNS)
+	if err != nil {
+		return nil, err
+	}
+	xrayConfig.Reverse, err = s.ReverseService.GetReverseConfig(xrayConfig.Reverse)
+	if err != nil {
+		return nil, err
+	}
 	return xrayConfig, nil
 }
 
+func (s *XrayService) getObservatories(xrayConfig *xray.Config) error {
+	observatories, err := s.ObservatoryService.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, observatory := range observatories {
+		observatoryConfig, err := s.ObservatoryService.GetObservatoryConfig(observatory)
+		if err != nil {
+			return err
+		}
+		if observatory.Type == model.ObservatoryBurst {
+			xrayConfig.BurstObservatory = observatoryConfig
+		} else {
+			xrayConfig.Observatory = observatoryConfig
+		}
+	}
+	if isEmptyJSON(xrayConfig.Observatory) && isEmptyJSON(xrayConfig.BurstObservatory) {
+		return nil
+	}
+	// the observatory service of xray api fails to start without an observatory
+	api, err := addApiService(xrayConfig.API, "ObservatoryService")
+	if err != nil {
+		return err
+	}
+	xrayConfig.API = api
+	return nil
+}
+
+func isEmptyJSON(data json_util.RawMessage) bool {
+	return len(data) == 0 || string(data) == "null"
+}
+
+// addApiService adds a service to the api config of xray when it is missing
+func addApiService(api json_util.RawMessage, service string) (json_util.RawMessage, error) {
+	apiConfig := make(map[string]json_util.RawMessage)
+	if !isEmptyJSON(api) {
+		err := json.Unmarshal(api, &apiConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var services []string
+	if !isEmptyJSON(apiConfig["services"]) {
+		err := json.Unmarshal(apiConfig["services"], &services)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range services {
+		if name == service {
+			return api, nil
+		}
+	}
+	servicesJSON, err := json.Marshal(append(services, service))
+	if err != nil {
+		return nil, err
+	}
+	apiConfig["services"] = servicesJSON
+	return json.Marshal(apiConfig)
+}
+
 func (s *XrayService) getInbounds() ([]xray.InboundConfig, error) {
 	inboundConfigs, err := s.InboundService.GetXrayInboundConfigs()
 	if err != nil {
@@ -73,6 +154,9 @@ func (s *XrayService) getOutbounds() ([]json_util.RawMessage, error) {
 		return nil, err
 	}
 	for _, outbound := range outbounds {
+		if !outbound.Enable {
+			continue
+		}
 		outboundJSON, err := s.OutboundService.GetOutboundConfig(outbound)
 		if err != nil {
 			return nil, err
@@ -96,11 +180,45 @@ func (s *XrayService) getRoutes(routes json_util.RawMessage) (json_util.RawMessa
 	if common.NonEmptyValue(string(routeConfig["domainMatcher"])) {
 		newRouteConfig["domainMatcher"] = routeConfig["domainMatcher"]
 	}
+	var balancersConfig []interface{}
 	if common.NonEmptyValue(string(routeConfig["balancers"])) {
-		newRouteConfig["balancers"] = routeConfig["balancers"]
+		err = json.Unmarshal(routeConfig["balancers"], &balancersConfig)
+		if err != nil {
+			return nil, err
+		}
 	}
-	var rulesConfig []map[string]interface{}
-	err = json.Unmarshal([]byte(routeConfig["rules"]), &rulesConfig)
+	// Disabled outbounds are redirected to the fallback tag, or dropped if it is not set.
+	// Disabling an outbound of enabled rules is rejected without a fallback tag, so only
+	// rules which are enabled later for a disabled outbound are dropped here.
+	disabledTags, err := s.OutboundService.GetDisabledTags()
+	if err != nil {
+		return nil, err
+	}
+	fallbackTag := config.GetSettings().OutboundFallbackTag
+	balancers, err := s.BalancerService.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, balancer := range balancers {
+		balancerConfig := s.BalancerService.GetBalancerConfig(balancer)
+		if disabledTags[balancer.FallbackTag] {
+			if fallbackTag != "" {
+				balancerConfig["fallbackTag"] = fallbackTag
+			} else {
+				delete(balancerConfig, "fallbackTag")
+			}
+		}
+		balancersConfig = append(balancersConfig, balancerConfig)
+	}
+	if len(balancersConfig) > 0 {
+		balancersJSON, err := json.Marshal(balancersConfig)
+		if err != nil {
+			return nil, err
+		}
+		newRouteConfig["balancers"] = balancersJSON
+	}
+	var defaultRules []map[string]interface{}
+	err = json.Unmarshal([]byte(routeConfig["rules"]), &defaultRules)
 	if err != nil {
 		return nil, err
 	}
@@ -108,7 +226,25 @@ func (s *XrayService) getRoutes(routes json_util.RawMessage) (json_util.RawMessa
 	if err != nil {
 		return nil, err
 	}
+	clientEmails, err := s.RuleService.GetClientEmails()
+	if err != nil {
+		return nil, err
+	}
+	var beforeRules, afterRules []map[string]interface{}
 	for _, rule := range rules {
+		if !rule.Enable {
+			continue
+		}
+		users := append([]string{}, rule.User...)
+		for _, ruleClient := range rule.RuleClients {
+			if email, ok := clientEmails[ruleClient.ClientId]; ok {
+				users = append(users, email)
+			}
+		}
+		if len(rule.RuleClients) > 0 && len(users) == 0 {
+			// Never let a client rule match everyone
+			continue
+		}
 		ruleConfig := make(map[string]interface{})
 		if common.NonEmptyValue(rule.DomainMatcher) {
 			ruleConfig["domainMatcher"] = rule.DomainMatcher
@@ -116,15 +252,11 @@ func (s *XrayService) getRoutes(routes json_util.RawMessage) (json_util.RawMessa
 		if common.NonEmptyValue(rule.Type) {
 			ruleConfig["type"] = rule.Type
 		}
-		if common.NonEmptyValue(rule.Domain) {
-			var domain []string
-			json.Unmarshal([]byte(rule.Domain), &domain)
-			ruleConfig["domain"] = domain
+		if len(rule.Domain) > 0 {
+			ruleConfig["domain"] = rule.Domain
 		}
-		if common.NonEmptyValue(rule.Ip) {
-			var ip []string
-			json.Unmarshal([]byte(rule.Ip), &ip)
-			ruleConfig["ip"] = ip
+		if len(rule.Ip) > 0 {
+			ruleConfig["ip"] = rule.Ip
 		}
 		if common.NonEmptyValue(rule.Port) {
 			ruleConfig["port"] = rule.Port
@@ -135,25 +267,17 @@ func (s *XrayService) getRoutes(routes json_util.RawMessage) (json_util.RawMessa
 		if common.NonEmptyValue(rule.Network) {
 			ruleConfig["network"] = rule.Network
 		}
-		if common.NonEmptyValue(rule.Source) {
-			var source []string
-			json.Unmarshal([]byte(rule.Source), &source)
-			ruleConfig["source"] = source
+		if len(rule.Source) > 0 {
+			ruleConfig["source"] = rule.Source
 		}
-		if common.NonEmptyValue(rule.User) {
-			var user []string
-			json.Unmarshal([]byte(rule.User), &user)
-			ruleConfig["user"] = user
+		if len(users) > 0 {
+			ruleConfig["user"] = users
 		}
-		if common.NonEmptyValue(rule.InboundTag) {
-			var inboundTag []string
-			json.Unmarshal([]byte(rule.InboundTag), &inboundTag)
-			ruleConfig["inboundTag"] = inboundTag
+		if len(rule.InboundTag) > 0 {
+			ruleConfig["inboundTag"] = rule.InboundTag
 		}
-		if common.NonEmptyValue(rule.Protocol) {
-			var protocol []string
-			json.Unmarshal([]byte(rule.Protocol), &protocol)
-			ruleConfig["protocol"] = protocol
+		if len(rule.Protocol) > 0 {
+			ruleConfig["protocol"] = rule.Protocol
 		}
 		if common.NonEmptyValue(string(rule.Attrs)) {
 			var attrs interface{}
@@ -162,12 +286,41 @@ func (s *XrayService) getRoutes(routes json_util.RawMessage) (json_util.RawMessa
 		}
 		if common.NonEmptyValue(rule.OutboundTag) {
 			ruleConfig["outboundTag"] = rule.OutboundTag
+			if disabledTags[rule.OutboundTag] {
+				if fallbackTag == "" {
+					continue
+				}
+				ruleConfig["outboundTag"] = fallbackTag
+			}
 		}
 		if common.NonEmptyValue(rule.BalancerTag) {
 			ruleConfig["balancerTag"] = rule.BalancerTag
 		}
-		rulesConfig = append(rulesConfig, ruleConfig)
+		if rule.Position == model.RuleBefore {
+			beforeRules = append(beforeRules, ruleConfig)
+		} else {
+			afterRules = append(afterRules, ruleConfig)
+		}
+	}
+	// Keep xray api routing on top of rules placed before defaults
+	var rulesConfig []map[string]interface{}
+	for _, defaultRule := range defaultRules {
+		if defaultRule["outboundTag"] == "api" {
+			rulesConfig = append(rulesConfig, defaultRule)
+		}
+	}
+	reverseRules, err := s.ReverseService.GetRules()
+	if err != nil {
+		return nil, err
+	}
+	rulesConfig = append(rulesConfig, reverseRules...)
+	rulesConfig = append(rulesConfig, beforeRules...)
+	for _, defaultRule := range defaultRules {
+		if defaultRule["outboundTag"] != "api" {
+			rulesConfig = append(rulesConfig, defaultRule)
+		}
 	}
+	rulesConfig = append(rulesConfig, afterRules...)
 
 	rulesJSON, err := json.Marshal(rulesConfig)
 	if err != nil {
@@ -184,7 +337,7 @@ func (s *XrayService) getRoutes(routes json_util.RawMessage) (json_util.RawMessa
 
 func (s *XrayService) GetXrayTraffic() ([]*model.Traffic, error) {
 	if !s.IsXrayRunning() {
-		return nil, errors.New("xray is not running")
+		return nil, xray.ErrNotRunning
 	}
 	s.XrayAPI.Init(p.GetAPIServer())
 	defer s.XrayAPI.Close()
@@ -227,10 +380,52 @@ func (s *XrayService) WriteConfigFile(needRestart bool) {
 	if err != nil {
 		logger.Error("Error in writing config file: ", err)
 	}
-	if !skipRestart && needRestart {
+	restarted := !skipRestart && needRestart
+	if restarted {
 		logger.Debug("Config file saved.")
 		s.RestartXray()
 	} else {
 		logger.Debug("Config saved! No need to restart xray.")
 	}
+	Publish(StreamConfig, map[string]interface{}{
+		"changed":   !skipRestart,
+		"restarted": restarted,
+	})
+}
+
+// UpdateRouting applies rules and balancers through xray api and restarts xray only if it fails
+func (s *XrayService) UpdateRouting() {
+	config, err := s.GetXrayConfig()
+	if err != nil {
+		logger.Error("Error in getting all configs: ", err)
+		return
+	}
+	err, skipRestart := p.WriteConfigFile(config)
+	if err != nil {
+		logger.Error("Error in writing config file: ", err)
+	}
+	restarted := false
+	if !skipRestart && s.IsXrayRunning() {
+		err = s.applyRouting(config.RoutingConfig)
+		if err != nil {
+			logger.Warning("Live routing update failed, restarting xray: ", err)
+			s.RestartXray()
+			restarted = true
+		} else {
+			logger.Debug("Routing updated without restart.")
+		}
+	}
+	Publish(StreamConfig, map[string]interface{}{
+		"changed":   !skipRestart,
+		"restarted": restarted,
+	})
+}
+
+func (s *XrayService) applyRouting(routing json_util.RawMessage) error {
+	err := s.XrayAPI.Init(p.GetAPIServer())
+	if err != nil {
+		return err
+	}
+	defer s.XrayAPI.Close()
+	return s.XrayAPI.AddRules(routing, false)
 }
@@ -6,15 +6,20 @@ import (
 	"fmt"
 	"io/fs"
 	"net"
+	"net/url"
 	"os"
 	"raha-xray/logger"
 	"raha-xray/util/common"
 	"strings"
 	"time"
+
+	"github.com/robfig/cron/v3"
 )
 
 var settings *Setting
 
+var cronParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
 type Setting struct {
 	Listen       string `json:"listen" form:"listen"`
 	Domain       string `json:"domain" form:"domain"`
@@ -25,7 +30,44 @@ type Setting struct {
 	TimeLocation string `json:"timeLocation" form:"timeLocation"`
 	DbType       string `json:"dbType" form:"dbType"`
 	DbAddr       string `json:"dbAddr" form:"dbAddr"`
+	DbDsn        string `json:"dbDsn" form:"dbDsn"`
 	TrafficDays  int    `json:"trafficDays" form:"trafficDays"`
+
+	DbJournalMode  string `json:"dbJournalMode" form:"dbJournalMode"`
+	DbBusyTimeout  int    `json:"dbBusyTimeout" form:"dbBusyTimeout"`
+	DbMaxOpenConns int    `json:"dbMaxOpenConns" form:"dbMaxOpenConns"`
+	DbMaxIdleConns int    `json:"dbMaxIdleConns" form:"dbMaxIdleConns"`
+
+	Webhooks       []string `json:"webhooks" form:"webhooks"`
+	WebhookSecret  string   `json:"webhookSecret" form:"webhookSecret"`
+	WebhookRetries int      `json:"webhookRetries" form:"webhookRetries"`
+	QuotaAlerts    []int    `json:"quotaAlerts" form:"quotaAlerts"`
+	ExpiryAlerts   []int    `json:"expiryAlerts" form:"expiryAlerts"`
+
+	TgToken  string  `json:"tgToken" form:"tgToken"`
+	TgApiUrl string  `json:"tgApiUrl" form:"tgApiUrl"`
+	TgAdmins []int64 `json:"tgAdmins" form:"tgAdmins"`
+	SubUrl   string  `json:"subUrl" form:"subUrl"`
+
+	GeoIpUrl   string `json:"geoIpUrl" form:"geoIpUrl"`
+	GeoSiteUrl string `json:"geoSiteUrl" form:"geoSiteUrl"`
+	GeoUpdate  string `json:"geoUpdate" form:"geoUpdate"`
+
+	HealthCheckUrl      string `json:"healthCheckUrl" form:"healthCheckUrl"`
+	HealthCheckInterval int    `json:"healthCheckInterval" form:"healthCheckInterval"`
+	HealthCheckTimeout  int    `json:"healthCheckTimeout" form:"healthCheckTimeout"`
+	HealthCheckDays     int    `json:"healthCheckDays" form:"healthCheckDays"`
+
+	OutboundSubUpdate   string `json:"outboundSubUpdate" form:"outboundSubUpdate"`
+	OutboundFallbackTag string `json:"outboundFallbackTag" form:"outboundFallbackTag"`
+
+	AgentMode   bool `json:"agentMode" form:"agentMode"`
+	NodeTimeout int  `json:"nodeTimeout" form:"nodeTimeout"`
+
+	QuotaMode   string `json:"quotaMode" form:"quotaMode"`
+	QuotaUrl    string `json:"quotaUrl" form:"quotaUrl"`
+	QuotaToken  string `json:"quotaToken" form:"quotaToken"`
+	QuotaDbAddr string `json:"quotaDbAddr" form:"quotaDbAddr"`
 }
 
 var defaultSettings = Setting{
@@ -38,7 +80,44 @@ var defaultSettings = Setting{
 	TimeLocation: "Asia/Tehran",
 	DbType:       "sqlite",
 	DbAddr:       "db",
+	DbDsn:        "",
 	TrafficDays:  0,
+
+	DbJournalMode:  "wal",
+	DbBusyTimeout:  5000,
+	DbMaxOpenConns: 0,
+	DbMaxIdleConns: 0,
+
+	Webhooks:       []string{},
+	WebhookSecret:  "",
+	WebhookRetries: 3,
+	QuotaAlerts:    []int{80, 95},
+	ExpiryAlerts:   []int{3},
+
+	TgToken:  "",
+	TgApiUrl: "https://api.telegram.org",
+	TgAdmins: []int64{},
+	SubUrl:   "",
+
+	GeoIpUrl:   "https://github.com/v2fly/geoip/releases/latest/download/geoip.dat",
+	GeoSiteUrl: "https://github.com/v2fly/domain-list-community/releases/latest/download/dlc.dat",
+	GeoUpdate:  "",
+
+	HealthCheckUrl:      "https://www.gstatic.com/generate_204",
+	HealthCheckInterval: 0,
+	HealthCheckTimeout:  10,
+	HealthCheckDays:     1,
+
+	OutboundSubUpdate:   "",
+	OutboundFallbackTag: "",
+
+	AgentMode:   false,
+	NodeTimeout: 10,
+
+	QuotaMode:   "",
+	QuotaUrl:    "",
+	QuotaToken:  "",
+	QuotaDbAddr: "",
 }
 
 func GetDefaultSettings() *Setting {
@@ -49,18 +128,18 @@ func (s *Setting) CheckValid() error {
 	if s.Listen != "" {
 		ip := net.ParseIP(s.Listen)
 		if ip == nil {
-			return common.NewError("Listen is not valid ip:", s.Listen)
+			return common.NewValidationError("Listen is not valid ip:", s.Listen)
 		}
 	}
 
 	if s.Port <= 0 || s.Port > 65535 {
-		return common.NewError("Port is not a valid port:", s.Port)
+		return common.NewValidationError("Port is not a valid port:", s.Port)
 	}
 
 	if s.CertFile != "" || s.KeyFile != "" {
 		_, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
 		if err != nil {
-			return common.NewErrorf("cert file <%v> or key file <%v> invalid: %v", s.CertFile, s.KeyFile, err)
+			return common.NewValidationErrorf("cert file <%v> or key file <%v> invalid: %v", s.CertFile, s.KeyFile, err)
 		}
 	}
 
@@ -71,7 +150,108 @@ func (s *Setting) CheckValid() error {
 
 	_, err := time.LoadLocation(s.TimeLocation)
 	if err != nil {
-		return common.NewError("time location not exist:", s.TimeLocation)
+		return common.NewValidationError("time location not exist:", s.TimeLocation)
+	}
+
+	switch s.DbType {
+	case "", "sqlite", "mysql":
+	case "postgres":
+		if s.DbDsn == "" {
+			return common.NewValidationError("Postgres database needs a dsn")
+		}
+	default:
+		return common.NewValidationError("Database type should be sqlite, mysql or postgres:", s.DbType)
+	}
+
+	switch strings.ToLower(s.DbJournalMode) {
+	case "", "wal", "delete", "truncate", "persist", "memory", "off":
+	default:
+		return common.NewValidationError("Database journal mode is not valid:", s.DbJournalMode)
+	}
+
+	if s.DbBusyTimeout < 0 || s.DbMaxOpenConns < 0 || s.DbMaxIdleConns < 0 {
+		return common.NewValidationError("Database busy timeout and connection limits can not be negative")
+	}
+
+	for _, webhook := range s.Webhooks {
+		webhookUrl, err := url.Parse(webhook)
+		if err != nil || (webhookUrl.Scheme != "http" && webhookUrl.Scheme != "https") {
+			return common.NewValidationError("Webhook is not a valid url:", webhook)
+		}
+	}
+
+	if s.WebhookRetries < 0 {
+		return common.NewValidationError("Webhook retries can not be negative:", s.WebhookRetries)
+	}
+
+	for _, level := range s.QuotaAlerts {
+		if level <= 0 || level > 100 {
+			return common.NewValidationError("Quota alert is not a valid percent:", level)
+		}
+	}
+
+	for _, days := range s.ExpiryAlerts {
+		if days <= 0 {
+			return common.NewValidationError("Expiry alert is not a valid day count:", days)
+		}
+	}
+
+	for _, geoUrl := range []string{s.GeoIpUrl, s.GeoSiteUrl} {
+		parsedUrl, err := url.Parse(geoUrl)
+		if geoUrl != "" && (err != nil || (parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https")) {
+			return common.NewValidationError("Geo asset url is not valid:", geoUrl)
+		}
+	}
+
+	if s.GeoUpdate != "" {
+		_, err = cronParser.Parse(s.GeoUpdate)
+		if err != nil {
+			return common.NewValidationError("Geo update schedule is not valid:", err)
+		}
+	}
+
+	if s.OutboundSubUpdate != "" {
+		_, err = cronParser.Parse(s.OutboundSubUpdate)
+		if err != nil {
+			return common.NewValidationError("Outbound subscription update schedule is not valid:", err)
+		}
+	}
+
+	if s.HealthCheckUrl != "" {
+		healthCheckUrl, err := url.Parse(s.HealthCheckUrl)
+		if err != nil || (healthCheckUrl.Scheme != "http" && healthCheckUrl.Scheme != "https") {
+			return common.NewValidationError("Health check url is not valid:", s.HealthCheckUrl)
+		}
+	}
+
+	if s.HealthCheckInterval < 0 || s.HealthCheckTimeout < 0 || s.HealthCheckDays < 0 {
+		return common.NewValidationError("Health check interval, timeout and days can not be negative")
+	}
+
+	if s.NodeTimeout < 0 {
+		return common.NewValidationError("Node timeout can not be negative:", s.NodeTimeout)
+	}
+
+	switch s.QuotaMode {
+	case "":
+	case "central":
+		quotaUrl, err := url.Parse(s.QuotaUrl)
+		if err != nil || (quotaUrl.Scheme != "http" && quotaUrl.Scheme != "https") || s.QuotaToken == "" {
+			return common.NewValidationError("Central quota needs a valid url and token:", s.QuotaUrl)
+		}
+	case "mysql":
+		if s.QuotaDbAddr == "" {
+			return common.NewValidationError("Shared quota database address is required")
+		}
+	default:
+		return common.NewValidationError("Quota mode should be empty, central or mysql:", s.QuotaMode)
+	}
+
+	if s.TgToken != "" {
+		tgApiUrl, err := url.Parse(s.TgApiUrl)
+		if err != nil || (tgApiUrl.Scheme != "http" && tgApiUrl.Scheme != "https") {
+			return common.NewValidationError("Telegram API url is not valid:", s.TgApiUrl)
+		}
 	}
 
 	return nil
@@ -99,6 +279,10 @@ func (s *Setting) GetMysqlDsn() string {
 	return fmt.Sprintf("%s/%s?charset=utf8mb4&loc=Local", mysqlServer, GetName())
 }
 
+func (s *Setting) GetQuotaDsn() string {
+	return fmt.Sprintf("%s/%s?charset=utf8mb4&loc=Local", s.QuotaDbAddr, GetName())
+}
+
 func LoadSettings() error {
 	if _, err := os.Stat("raha-xray.json"); err == nil {
 		data, err := os.ReadFile("raha-xray.json")
@@ -129,3 +313,8 @@ func SaveSettings(data *Setting) error {
 func GetSettings() *Setting {
 	return settings
 }
+
+// SetSettings replaces the loaded settings without saving them, tests use it to run on temporary databases
+func SetSettings(data *Setting) {
+	settings = data
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"raha-xray/util/common"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+const copyBatchSize = 500
+
+// CopyFromSqlite replaces all data of the current database by the data of a sqlite database.
+// It is used to move an existing installation to postgres or mysql.
+func CopyFromSqlite(dbPath string) error {
+	if db.Dialector.Name() == "sqlite" {
+		return common.NewError("Target database should not be sqlite")
+	}
+	source, err := openSqlite(dbPath, &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return err
+	}
+	sourceDB, err := source.DB()
+	if err != nil {
+		return err
+	}
+	defer sourceDB.Close()
+	err = syncSchema(source, models...)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for index := len(models) - 1; index >= 0; index-- {
+			err := tx.Where("1 = 1").Delete(models[index]).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, value := range models {
+			rows := reflect.New(reflect.SliceOf(reflect.TypeOf(value))).Interface()
+			result := source.Model(value).FindInBatches(rows, copyBatchSize, func(_ *gorm.DB, _ int) error {
+				// fields with a default value are skipped on create when zero, like false enable
+				defaults, err := findZeroDefaults(tx, value, rows)
+				if err != nil {
+					return err
+				}
+				err = tx.Omit(clause.Associations).Create(rows).Error
+				if err != nil {
+					return err
+				}
+				for _, zero := range defaults {
+					err = tx.Model(value).Where("id in ?", zero.ids).Update(zero.column, zero.value).Error
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return ResetSequences(tx, models...)
+	})
+}
+
+type zeroDefault struct {
+	column string
+	value  interface{}
+	ids    []interface{}
+}
+
+// findZeroDefaults finds rows with zero values in fields which have a default value
+func findZeroDefaults(tx *gorm.DB, value interface{}, rows interface{}) ([]*zeroDefault, error) {
+	stmt := &gorm.Statement{DB: tx}
+	err := stmt.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	rowsValue := reflect.Indirect(reflect.ValueOf(rows))
+	var defaults []*zeroDefault
+	for _, field := range stmt.Schema.Fields {
+		if field.DefaultValue == "" || field.PrimaryKey || field.DBName == "" {
+			continue
+		}
+		zero := &zeroDefault{column: field.DBName, value: reflect.Zero(field.FieldType).Interface()}
+		for index := 0; index < rowsValue.Len(); index++ {
+			row := rowsValue.Index(index)
+			if _, isZero := field.ValueOf(tx.Statement.Context, row); isZero {
+				id, _ := stmt.Schema.PrioritizedPrimaryField.ValueOf(tx.Statement.Context, row)
+				zero.ids = append(zero.ids, id)
+			}
+		}
+		if len(zero.ids) > 0 {
+			defaults = append(defaults, zero)
+		}
+	}
+	return defaults, nil
+}
+
+// ResetSequences moves postgres id sequences after the largest id of tables,
+// it is needed when rows are created with their ids.
+func ResetSequences(tx *gorm.DB, values ...interface{}) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	for _, value := range values {
+		stmt := &gorm.Statement{DB: tx}
+		err := stmt.Parse(value)
+		if err != nil {
+			return err
+		}
+		table := stmt.Schema.Table
+		err = tx.Exec("SELECT setval(pg_get_serial_sequence(?, 'id'), COALESCE(MAX(id), 0) + 1, false) FROM ?",
+			table, clause.Table{Name: table}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,21 +1,91 @@
 package database
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path"
 	"raha-xray/config"
 	"raha-xray/database/model"
 	"raha-xray/util/random"
+	"strings"
+	"sync"
 
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+// models are migrated and copied in this order, so referenced tables come first
+var models = []interface{}{
+	&model.Config{},
+	&model.Inbound{},
+	&model.Node{},
+	&model.NodeTraffic{},
+	&model.Client{},
+	&model.ClientInbound{},
+	&model.Traffic{},
+	&model.ClientHistory{},
+	&model.ClientAlert{},
+	&model.WebhookLog{},
+	&model.OutboundCheck{},
+	&model.Subscription{},
+	&model.Outbound{},
+	&model.Rule{},
+	&model.RuleClient{},
+	&model.Balancer{},
+	&model.Observatory{},
+	&model.DnsServer{},
+	&model.DnsHost{},
+	&model.FakeDnsPool{},
+	&model.ReverseBridge{},
+	&model.ReversePortal{},
+	&model.GeoList{},
+	&model.User{},
+}
+
 var db *gorm.DB
 
+var quotaDB *gorm.DB
+var quotaLock sync.Mutex
+
 func InitDB() error {
+	err := OpenDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = MigrateUp()
+	if err != nil {
+		return err
+	}
+
+	// new tables and columns of models are added without a migration
+	err = syncSchema(db, models...)
+	if err != nil {
+		return err
+	}
+
+	// Init user
+	var count int64
+	err = db.Model(&model.User{}).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		user := &model.User{
+			Key: random.Seq(32),
+		}
+		return db.Create(user).Error
+	}
+
+	return nil
+}
+
+// OpenDB connects to the configured database without migrating it
+func OpenDB() error {
 	var err error
 	var gormLogger logger.Interface
 
@@ -31,53 +101,92 @@ func InitDB() error {
 		Logger: gormLogger,
 	}
 
-	if settings.DbType == "mysql" {
+	switch settings.DbType {
+	case "mysql":
 		db, err = gorm.Open(mysql.Open(settings.GetMysqlDsn()), c)
-	} else {
-		dbPath := settings.GetDBPath()
-		err = os.MkdirAll(path.Dir(dbPath), 0750)
-		if err != nil {
-			return err
-		}
-		db, err = gorm.Open(sqlite.Open(dbPath), c)
+	case "postgres":
+		db, err = gorm.Open(postgres.Open(settings.DbDsn), c)
+	default:
+		db, err = openSqlite(settings.GetDBPath(), c)
 	}
 	if err != nil {
 		return err
 	}
 
-	err = db.AutoMigrate(
-		&model.Config{},
-		&model.Inbound{},
-		&model.Client{},
-		&model.ClientInbound{},
-		&model.Traffic{},
-		&model.Outbound{},
-		&model.Rule{},
-		&model.User{})
+	sqlDB, err := db.DB()
 	if err != nil {
 		return err
 	}
+	if settings.DbMaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(settings.DbMaxOpenConns)
+	}
+	if settings.DbMaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(settings.DbMaxIdleConns)
+	}
+	return nil
+}
 
-	// Init user
-	var count int64
-	err = db.Model(&model.User{}).Count(&count).Error
+// openSqlite opens a sqlite database with the journal mode and busy timeout of settings.
+// Transactions take the write lock at begin, so they wait for each other instead of failing as locked.
+func openSqlite(dbPath string, c *gorm.Config) (*gorm.DB, error) {
+	err := os.MkdirAll(path.Dir(dbPath), 0750)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if count == 0 {
-		user := &model.User{
-			Key: random.Seq(32),
-		}
-		return db.Create(user).Error
+	settings := config.GetSettings()
+	journalMode := settings.DbJournalMode
+	if journalMode == "" {
+		journalMode = config.GetDefaultSettings().DbJournalMode
 	}
-
-	return nil
+	busyTimeout := settings.DbBusyTimeout
+	if busyTimeout == 0 {
+		busyTimeout = config.GetDefaultSettings().DbBusyTimeout
+	}
+	dsn := fmt.Sprintf("file:%s?_journal_mode=%s&_busy_timeout=%d&_txlock=immediate",
+		dbPath, strings.ToUpper(journalMode), busyTimeout)
+	return gorm.Open(sqlite.Open(dsn), c)
 }
 
 func GetDB() *gorm.DB {
 	return db
 }
 
+// GetQuotaDB opens the shared mysql database which keeps combined client usage of servers
+func GetQuotaDB() (*gorm.DB, error) {
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+	if quotaDB != nil {
+		return quotaDB, nil
+	}
+	c := &gorm.Config{
+		Logger: logger.Discard,
+	}
+	if config.IsDebug() {
+		c.Logger = logger.Default
+	}
+	sharedDB, err := gorm.Open(mysql.Open(config.GetSettings().GetQuotaDsn()), c)
+	if err != nil {
+		return nil, err
+	}
+	err = sharedDB.AutoMigrate(&model.SharedUsage{})
+	if err != nil {
+		return nil, err
+	}
+	quotaDB = sharedDB
+	return quotaDB, nil
+}
+
 func IsNotFound(err error) bool {
 	return err == gorm.ErrRecordNotFound
 }
+
+// IsDuplicated reports unique constraint violations of any database type
+func IsDuplicated(err error) bool {
+	translator, ok := db.Dialector.(gorm.ErrorTranslator)
+	for ; err != nil; err = errors.Unwrap(err) {
+		if err == gorm.ErrDuplicatedKey || (ok && translator.Translate(err) == gorm.ErrDuplicatedKey) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,341 @@
+package database
+
+import (
+	"fmt"
+	"raha-xray/config"
+	"raha-xray/database/model"
+	"raha-xray/util/common"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a numbered schema change for what syncSchema does not do, like renaming or changing columns,
+// backfilling data or dropping tables. Released migrations should never be edited, add a new one instead.
+// Tables of a new database are created by syncSchema after all migrations, so migrations skip missing tables.
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// migrations are applied in order of their versions
+var migrations = []*Migration{
+	{
+		// baseline of the schema before versioned migrations, it changes nothing
+		Version: 1,
+		Name:    "initial_schema",
+		Up:      func(tx *gorm.DB) error { return nil },
+		Down:    func(tx *gorm.DB) error { return nil },
+	},
+	{
+		// node counters follow clients by id, so renaming a client does not count its whole traffic again
+		Version: 2,
+		Name:    "node_traffic_client_id",
+		Up: func(tx *gorm.DB) error {
+			migrator := tx.Migrator()
+			if !migrator.HasTable(&nodeTrafficV2{}) {
+				return nil
+			}
+			err := migrator.AddColumn(&nodeTrafficV2{}, "ClientId")
+			if err != nil {
+				return err
+			}
+			err = tx.Exec("UPDATE node_traffics SET client_id = (SELECT id FROM clients WHERE clients.name = node_traffics.client)").Error
+			if err != nil {
+				return err
+			}
+			err = tx.Where("client_id IS NULL").Delete(&nodeTrafficV2{}).Error
+			if err != nil {
+				return err
+			}
+			return migrator.DropColumn(&nodeTrafficV2{}, "Client")
+		},
+		Down: func(tx *gorm.DB) error {
+			migrator := tx.Migrator()
+			if !migrator.HasTable(&nodeTrafficV2{}) {
+				return nil
+			}
+			err := migrator.AddColumn(&nodeTrafficV2{}, "Client")
+			if err != nil {
+				return err
+			}
+			err = tx.Exec("UPDATE node_traffics SET client = (SELECT name FROM clients WHERE clients.id = node_traffics.client_id)").Error
+			if err != nil {
+				return err
+			}
+			err = tx.Where("client IS NULL").Delete(&nodeTrafficV2{}).Error
+			if err != nil {
+				return err
+			}
+			return migrator.DropColumn(&nodeTrafficV2{}, "ClientId")
+		},
+	},
+	{
+		// rules of previous versions have no enable column, they were all enabled
+		Version: 3,
+		Name:    "rule_enable",
+		Up:      func(tx *gorm.DB) error { return addEnableColumn(tx, &ruleV3{}) },
+		Down:    func(tx *gorm.DB) error { return dropEnableColumn(tx, &ruleV3{}) },
+	},
+	{
+		// outbounds of previous versions have no enable column, they were all enabled
+		Version: 4,
+		Name:    "outbound_enable",
+		Up:      func(tx *gorm.DB) error { return addEnableColumn(tx, &outboundV4{}) },
+		Down:    func(tx *gorm.DB) error { return dropEnableColumn(tx, &outboundV4{}) },
+	},
+}
+
+// nodeTrafficV2 has the columns of node_traffics which are changed by migration 2
+type nodeTrafficV2 struct {
+	Id       uint `gorm:"primaryKey;autoIncrement"`
+	Client   string
+	ClientId uint
+}
+
+func (nodeTrafficV2) TableName() string {
+	return "node_traffics"
+}
+
+// ruleV3 has the column of rules which is added by migration 3
+type ruleV3 struct {
+	Enable bool
+}
+
+func (ruleV3) TableName() string {
+	return "rules"
+}
+
+// outboundV4 has the column of outbounds which is added by migration 4
+type outboundV4 struct {
+	Enable bool
+}
+
+func (outboundV4) TableName() string {
+	return "outbounds"
+}
+
+// addEnableColumn adds the enable column of a table and enables its existing rows
+func addEnableColumn(tx *gorm.DB, value interface{}) error {
+	migrator := tx.Migrator()
+	if !migrator.HasTable(value) {
+		return nil
+	}
+	if !migrator.HasColumn(value, "Enable") {
+		err := migrator.AddColumn(value, "Enable")
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Model(value).Where("enable IS NULL").Update("enable", true).Error
+}
+
+func dropEnableColumn(tx *gorm.DB, value interface{}) error {
+	migrator := tx.Migrator()
+	if !migrator.HasTable(value) || !migrator.HasColumn(value, "Enable") {
+		return nil
+	}
+	return migrator.DropColumn(value, "Enable")
+}
+
+// syncSchema creates missing tables of models and adds their missing columns and indexes.
+// It never changes or drops what exists, so only additive changes of models may skip a migration.
+func syncSchema(tx *gorm.DB, values ...interface{}) error {
+	migrator := tx.Migrator()
+	for _, value := range values {
+		if !migrator.HasTable(value) {
+			err := migrator.CreateTable(value)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		stmt := &gorm.Statement{DB: tx}
+		err := stmt.Parse(value)
+		if err != nil {
+			return err
+		}
+		for _, dbName := range stmt.Schema.DBNames {
+			if !migrator.HasColumn(value, dbName) {
+				err = migrator.AddColumn(value, dbName)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, index := range stmt.Schema.ParseIndexes() {
+			if !migrator.HasIndex(value, index.Name) {
+				err = migrator.CreateIndex(value, index.Name)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type MigrationState struct {
+	Version   uint   `json:"version"`
+	Name      string `json:"name"`
+	AppliedAt int64  `json:"appliedAt"`
+	Known     bool   `json:"known"`
+}
+
+// MigrationStatus returns all known and applied migrations, pending ones have no applied time
+func MigrationStatus() ([]*MigrationState, error) {
+	applied, err := getAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[uint]*MigrationState)
+	for _, migration := range applied {
+		states[migration.Version] = &MigrationState{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: migration.AppliedAt,
+		}
+	}
+	for _, migration := range migrations {
+		state, ok := states[migration.Version]
+		if !ok {
+			state = &MigrationState{Version: migration.Version, Name: migration.Name}
+			states[migration.Version] = state
+		}
+		state.Known = true
+	}
+	result := make([]*MigrationState, 0, len(states))
+	for _, state := range states {
+		result = append(result, state)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// MigrateUp applies all pending migrations in one transaction and returns them.
+// Mysql commits schema changes implicitly, so only data changes are rolled back there.
+func MigrateUp() ([]*Migration, error) {
+	applied, err := getAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	appliedVersions := make(map[uint]bool, len(applied))
+	for _, migration := range applied {
+		if getMigration(migration.Version) == nil {
+			return nil, common.NewErrorf("database schema version %d is not known by this version, migrate it down by a newer version first", migration.Version)
+		}
+		appliedVersions[migration.Version] = true
+	}
+	var pending []*Migration
+	for _, migration := range migrations {
+		if !appliedVersions[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+
+	err = backupSqlite()
+	if err != nil {
+		return nil, err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, migration := range pending {
+			err := migration.Up(tx)
+			if err != nil {
+				return common.NewErrorf("migration %d %s failed: %v", migration.Version, migration.Name, err)
+			}
+			err = tx.Create(&model.SchemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now().UnixMilli(),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// MigrateDown reverts the last applied migrations in one transaction and returns them
+func MigrateDown(steps int) ([]*Migration, error) {
+	applied, err := getAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var reverted []*Migration
+	for index := len(applied) - 1; index >= 0 && len(reverted) < steps; index-- {
+		migration := getMigration(applied[index].Version)
+		if migration == nil {
+			return nil, common.NewErrorf("migration %d is not known by this version", applied[index].Version)
+		}
+		if migration.Down == nil {
+			return nil, common.NewErrorf("migration %d %s can not be reverted", migration.Version, migration.Name)
+		}
+		reverted = append(reverted, migration)
+	}
+	if len(reverted) == 0 {
+		return nil, nil
+	}
+
+	err = backupSqlite()
+	if err != nil {
+		return nil, err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, migration := range reverted {
+			err := migration.Down(tx)
+			if err != nil {
+				return common.NewErrorf("migration %d %s failed: %v", migration.Version, migration.Name, err)
+			}
+			err = tx.Delete(&model.SchemaMigration{}, migration.Version).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reverted, nil
+}
+
+func getAppliedMigrations() ([]*model.SchemaMigration, error) {
+	err := syncSchema(db, &model.SchemaMigration{})
+	if err != nil {
+		return nil, err
+	}
+	var applied []*model.SchemaMigration
+	err = db.Model(model.SchemaMigration{}).Order("version").Find(&applied).Error
+	return applied, err
+}
+
+func getMigration(version uint) *Migration {
+	for _, migration := range migrations {
+		if migration.Version == version {
+			return migration
+		}
+	}
+	return nil
+}
+
+// backupSqlite copies an existing sqlite database next to it before migrating
+func backupSqlite() error {
+	if db.Dialector.Name() != "sqlite" || !db.Migrator().HasTable(&model.User{}) {
+		return nil
+	}
+	dbPath := config.GetSettings().GetDBPath()
+	backupPath := fmt.Sprintf("%s.%s.bak", dbPath, time.Now().Format("20060102150405.000"))
+	return db.Exec("VACUUM INTO ?", backupPath).Error
+}
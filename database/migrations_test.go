@@ -0,0 +1,273 @@
+package database
+
+import (
+	"raha-xray/config"
+	"raha-xray/database/model"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// openTestDB opens a new sqlite database in a temporary directory
+func openTestDB(t *testing.T) {
+	t.Helper()
+	settings := *config.GetDefaultSettings()
+	settings.DbAddr = t.TempDir()
+	config.SetSettings(&settings)
+	err := OpenDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, err := db.DB()
+		if err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+func versionsOf(done []*Migration) []uint {
+	var versions []uint
+	for _, migration := range done {
+		versions = append(versions, migration.Version)
+	}
+	return versions
+}
+
+func equalVersions(a []uint, b ...uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index := range a {
+		if a[index] != b[index] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMigrations(t *testing.T) {
+	openTestDB(t)
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+
+	var calls []uint
+	migration := func(version uint, name string, up string, down string) *Migration {
+		return &Migration{
+			Version: version,
+			Name:    name,
+			Up: func(tx *gorm.DB) error {
+				calls = append(calls, version)
+				return tx.Exec(up).Error
+			},
+			Down: func(tx *gorm.DB) error {
+				calls = append(calls, version)
+				return tx.Exec(down).Error
+			},
+		}
+	}
+	// the list is not in order of versions
+	migrations = []*Migration{
+		migration(1, "create_notes", "CREATE TABLE notes (id integer primary key)", "DROP TABLE notes"),
+		migration(3, "add_color", "ALTER TABLE notes ADD COLUMN color text", "ALTER TABLE notes DROP COLUMN color"),
+		migration(2, "add_title", "ALTER TABLE notes ADD COLUMN title text", "ALTER TABLE notes DROP COLUMN title"),
+	}
+
+	done, err := MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalVersions(versionsOf(done), 1, 2, 3) || !equalVersions(calls, 1, 2, 3) {
+		t.Fatalf("migrated up %v by calls %v, want 1 2 3", versionsOf(done), calls)
+	}
+	if !db.Migrator().HasColumn("notes", "title") || !db.Migrator().HasColumn("notes", "color") {
+		t.Fatal("columns of migrations are missing")
+	}
+
+	done, err = MigrateUp()
+	if err != nil || len(done) != 0 {
+		t.Fatalf("second migrate up applied %v, %v", versionsOf(done), err)
+	}
+
+	calls = nil
+	done, err = MigrateDown(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalVersions(versionsOf(done), 3, 2) || !equalVersions(calls, 3, 2) {
+		t.Fatalf("migrated down %v by calls %v, want 3 2", versionsOf(done), calls)
+	}
+	if db.Migrator().HasColumn("notes", "title") {
+		t.Fatal("column of a reverted migration is not dropped")
+	}
+
+	states, err := MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 3 {
+		t.Fatalf("%d migration states, want 3", len(states))
+	}
+	for index, state := range states {
+		if state.Version != uint(index+1) || !state.Known {
+			t.Errorf("state %d is %+v", index, state)
+		}
+		if applied := state.AppliedAt > 0; applied != (state.Version == 1) {
+			t.Errorf("migration %d applied is %v", state.Version, applied)
+		}
+	}
+
+	// migrations without down can not be reverted
+	migrations[0].Down = nil
+	_, err = MigrateDown(1)
+	if err == nil {
+		t.Error("migration without down is reverted")
+	}
+
+	// applied versions which are not known refuse to migrate
+	migrations = migrations[1:]
+	_, err = MigrateUp()
+	if err == nil {
+		t.Error("unknown applied migration is accepted")
+	}
+	states, err = MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if states[0].Version != 1 || states[0].Known {
+		t.Errorf("unknown migration state is %+v", states[0])
+	}
+}
+
+func TestInitDB(t *testing.T) {
+	openTestDB(t)
+	for i := 0; i < 2; i++ {
+		err := InitDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, value := range models {
+		if !db.Migrator().HasTable(value) {
+			t.Errorf("table of %T is not created", value)
+		}
+	}
+	var count int64
+	db.Model(&model.User{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d users, want 1", count)
+	}
+
+	// all migrations can be reverted and applied again
+	done, err := MigrateDown(len(migrations))
+	if err != nil || !equalVersions(versionsOf(done), 4, 3, 2, 1) {
+		t.Fatalf("migrate down %v, %v", versionsOf(done), err)
+	}
+	done, err = MigrateUp()
+	if err != nil || !equalVersions(versionsOf(done), 1, 2, 3, 4) {
+		t.Fatalf("migrate up %v, %v", versionsOf(done), err)
+	}
+}
+
+// baselineRule has the columns of rules before versioned migrations
+type baselineRule struct {
+	Id          uint `gorm:"primaryKey;autoIncrement"`
+	OutboundTag string
+}
+
+func (baselineRule) TableName() string {
+	return "rules"
+}
+
+// baselineOutbound has the columns of outbounds before versioned migrations
+type baselineOutbound struct {
+	Id  uint `gorm:"primaryKey;autoIncrement"`
+	Tag string
+}
+
+func (baselineOutbound) TableName() string {
+	return "outbounds"
+}
+
+func TestEnableMigrations(t *testing.T) {
+	openTestDB(t)
+	for _, row := range []interface{}{&baselineRule{OutboundTag: "direct"}, &baselineOutbound{Tag: "direct"}} {
+		err := db.Migrator().CreateTable(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Create(row).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules []*model.Rule
+	err = db.Find(&rules).Error
+	if err != nil || len(rules) != 1 || !rules[0].Enable {
+		t.Fatalf("rules after upgrade %+v, %v", rules, err)
+	}
+	var outbounds []*model.Outbound
+	err = db.Find(&outbounds).Error
+	if err != nil || len(outbounds) != 1 || !outbounds[0].Enable {
+		t.Fatalf("outbounds after upgrade %+v, %v", outbounds, err)
+	}
+
+	// new rows keep their enable
+	err = db.Create(&model.Rule{OutboundTag: "block"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Create(&model.Outbound{Tag: "block"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []interface{}{&model.Rule{}, &model.Outbound{}} {
+		var count int64
+		db.Model(value).Where("enable = ?", false).Count(&count)
+		if count != 1 {
+			t.Errorf("%d disabled rows of %T, want 1", count, value)
+		}
+	}
+}
+
+func TestNodeTrafficClientIdMigration(t *testing.T) {
+	openTestDB(t)
+	err := InitDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range []*model.Client{{Id: 1, Name: "a"}, {Id: 2, Name: "b"}} {
+		err = db.Create(client).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// back to the schema before migration 2
+	_, err = MigrateDown(len(migrations) - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// counters of the previous schema are kept by client name
+	for _, counter := range []*nodeTrafficV2{{Client: "a"}, {Client: "b"}, {Client: "removed"}} {
+		err = db.Select("Client").Create(counter).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasColumn(&nodeTrafficV2{}, "Client") {
+		t.Error("client column is not dropped")
+	}
+	var clientIds []uint
+	err = db.Model(&model.NodeTraffic{}).Order("client_id").Pluck("client_id", &clientIds).Error
+	if err != nil || len(clientIds) != 2 || clientIds[0] != 1 || clientIds[1] != 2 {
+		t.Errorf("client ids of counters %v, %v", clientIds, err)
+	}
+}
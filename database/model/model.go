@@ -28,10 +28,46 @@ type Inbound struct {
 	Config   Config `gorm:"foreignKey:ConfigId;references:Id" json:"config"`
 	Tag      string `gorm:"unique" json:"tag" form:"tag"`
 
+	// node part, 0 is the local xray
+	NodeId uint `json:"nodeId" form:"nodeId" gorm:"default:0;index"`
+
 	// clients part
 	ClientInbounds []ClientInbound `gorm:"foreignKey:InboundId;references:Id" json:"clients"`
 }
 
+// Node is a remote raha-xray running in agent mode
+type Node struct {
+	Id        uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string `gorm:"unique" json:"name" form:"name"`
+	Enable    bool   `json:"enable" form:"enable"`
+	Address   string `json:"address" form:"address"`
+	Token     string `json:"token,omitempty" form:"token"`
+	SyncHash  string `json:"-" form:"-"`
+	LastSync  uint64 `json:"lastSync" form:"lastSync" gorm:"default:0"`
+	LastSeen  uint64 `json:"lastSeen" form:"lastSeen" gorm:"default:0"`
+	LastError string `json:"lastError" form:"lastError"`
+
+	// last reported server status, not stored
+	Status interface{} `gorm:"-" json:"status,omitempty"`
+}
+
+// NodeTraffic keeps the last client counters reported by a node to calculate deltas
+type NodeTraffic struct {
+	Id       uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	NodeId   uint   `json:"nodeId" form:"nodeId" gorm:"index"`
+	ClientId uint   `json:"clientId" form:"clientId" gorm:"index"`
+	Up       uint64 `json:"up" form:"up"`
+	Down     uint64 `json:"down" form:"down"`
+}
+
+// SharedUsage is the combined usage of a client on all servers, it is stored in the shared quota database
+type SharedUsage struct {
+	Id   uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name string `json:"name" form:"name" gorm:"size:191;unique"`
+	Up   uint64 `json:"up" form:"up" gorm:"default:0"`
+	Down uint64 `json:"down" form:"down" gorm:"default:0"`
+}
+
 type Config struct {
 	Id             uint     `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
 	Protocol       Protocol `json:"protocol" form:"protocol"`
@@ -47,11 +83,18 @@ type Client struct {
 	Enable bool   `json:"enable" form:"enable" gorm:"default:true"`
 	Quota  uint64 `json:"quota" form:"quota" gorm:"default:0"`
 	Expiry uint64 `json:"expiry" form:"expiry" gorm:"default:0"`
-	Reset  uint   `json:"reset" from:"reset" gorm:"default:0"`
-	Once   uint   `json:"once" from:"once" gorm:"default:0"`
+	Reset  uint   `json:"reset" form:"reset" gorm:"default:0"`
+	Once   uint   `json:"once" form:"once" gorm:"default:0"`
 	Up     uint64 `json:"up" form:"up" gorm:"default:0"`
 	Down   uint64 `json:"down" form:"down" gorm:"default:0"`
 	Remark string `json:"remark" form:"remark"`
+	TgId   int64  `json:"tgId" form:"tgId" gorm:"default:0"`
+
+	// activity part, recorded by traffic and ignored on input
+	FirstUsed  uint64 `json:"firstUsed" form:"-" gorm:"default:0"`
+	LastOnline uint64 `json:"lastOnline" form:"-" gorm:"default:0"`
+	OnlineTime uint64 `json:"onlineTime" form:"-" gorm:"default:0"`
+	CreatedAt  uint64 `json:"createdAt" form:"-" gorm:"autoCreateTime:milli"`
 
 	// inbounds part
 	ClientInbounds []ClientInbound `gorm:"foreignKey:ClientId;references:Id" json:"inbounds"`
@@ -66,6 +109,7 @@ type ClientInbound struct {
 
 type Outbound struct {
 	Id             uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Enable         bool   `json:"enable" form:"enable"`
 	SendThrough    string `json:"sendThrough" form:"sendThrough"`
 	Protocol       string `json:"protocol" form:"protocol"`
 	Settings       string `json:"settings" form:"settings"`
@@ -73,24 +117,135 @@ type Outbound struct {
 	StreamSettings string `json:"streamSettings" form:"streamSettings"`
 	ProxySettings  string `json:"proxySettings" form:"proxySettings"`
 	Mux            string `json:"mux" form:"mux"`
+
+	// subscription part, key is the link name inside the subscription
+	SubscriptionId  uint   `json:"subscriptionId" form:"subscriptionId" gorm:"default:0"`
+	SubscriptionKey string `json:"subscriptionKey" form:"subscriptionKey"`
+
+	// last health check, not stored
+	Health *OutboundCheck `gorm:"-" json:"health,omitempty"`
 }
 
+type Subscription struct {
+	Id         uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Url        string `gorm:"unique" json:"url" form:"url"`
+	Prefix     string `json:"prefix" form:"prefix"`
+	LastUpdate uint64 `json:"lastUpdate" form:"lastUpdate" gorm:"default:0"`
+}
+
+const (
+	RuleBefore = "before"
+	RuleAfter  = "after"
+)
+
 type Rule struct {
-	Id            uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
-	DomainMatcher string `json:"domainMatcher" form:"domainMatcher"`
-	Type          string `json:"type" form:"type"`
-	Domain        string `json:"domain" form:"domain"`
-	Ip            string `json:"ip" form:"ip"`
-	Port          string `json:"port" form:"port"`
-	SourcePort    string `json:"sourcePort" form:"sourcePort"`
-	Network       string `json:"network" form:"network"`
-	Source        string `json:"source" form:"source"`
-	User          string `json:"user" form:"user"`
-	InboundTag    string `json:"inboundTag" form:"inboundTag"`
-	Protocol      string `json:"protocol" form:"protocol"`
-	Attrs         string `json:"attrs" form:"attrs"`
-	OutboundTag   string `json:"outboundTag" form:"outboundTag"`
-	BalancerTag   string `json:"balancerTag" form:"balancerTag"`
+	Id            uint       `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Enable        bool       `json:"enable" form:"enable"`
+	Priority      int        `json:"priority" form:"priority" gorm:"default:0"`
+	Position      string     `json:"position" form:"position" gorm:"default:after"`
+	DomainMatcher string     `json:"domainMatcher" form:"domainMatcher"`
+	Type          string     `json:"type" form:"type"`
+	Domain        StringList `json:"domain" form:"domain"`
+	Ip            StringList `json:"ip" form:"ip"`
+	Port          string     `json:"port" form:"port"`
+	SourcePort    string     `json:"sourcePort" form:"sourcePort"`
+	Network       string     `json:"network" form:"network"`
+	Source        StringList `json:"source" form:"source"`
+	User          StringList `json:"user" form:"user"`
+	InboundTag    StringList `json:"inboundTag" form:"inboundTag"`
+	Protocol      StringList `json:"protocol" form:"protocol"`
+	Attrs         string     `json:"attrs" form:"attrs"`
+	OutboundTag   string     `json:"outboundTag" form:"outboundTag"`
+	BalancerTag   string     `json:"balancerTag" form:"balancerTag"`
+
+	// clients part, resolved to user emails in routing
+	RuleClients []RuleClient `gorm:"foreignKey:RuleId;references:Id" json:"clients"`
+
+	// problems like a disabled outbound, not stored
+	Warning string `gorm:"-" json:"warning,omitempty"`
+}
+
+type RuleClient struct {
+	Id       uint `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	RuleId   uint `json:"ruleId" form:"ruleId" gorm:"index"`
+	ClientId uint `json:"clientId" form:"clientId" gorm:"index"`
+}
+
+type Balancer struct {
+	Id          uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Tag         string `gorm:"unique" json:"tag" form:"tag"`
+	Selector    string `json:"selector" form:"selector"`
+	Strategy    string `json:"strategy" form:"strategy"`
+	FallbackTag string `json:"fallbackTag" form:"fallbackTag"`
+
+	// problems like a disabled outbound, not stored
+	Warning string `gorm:"-" json:"warning,omitempty"`
+}
+
+const (
+	ObservatoryDefault = "observatory"
+	ObservatoryBurst   = "burstObservatory"
+)
+
+type Observatory struct {
+	Id                uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Type              string `gorm:"unique" json:"type" form:"type"`
+	SubjectSelector   string `json:"subjectSelector" form:"subjectSelector"`
+	ProbeUrl          string `json:"probeUrl" form:"probeUrl"`
+	ProbeInterval     string `json:"probeInterval" form:"probeInterval"`
+	EnableConcurrency bool   `json:"enableConcurrency" form:"enableConcurrency"`
+	PingConfig        string `json:"pingConfig" form:"pingConfig"`
+}
+
+type DnsServer struct {
+	Id           uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Priority     int    `json:"priority" form:"priority" gorm:"default:0"`
+	Address      string `json:"address" form:"address"`
+	Port         uint   `json:"port" form:"port"`
+	Domains      string `json:"domains" form:"domains"`
+	ExpectIps    string `json:"expectIps" form:"expectIps"`
+	SkipFallback bool   `json:"skipFallback" form:"skipFallback"`
+	ClientIp     string `json:"clientIp" form:"clientIp"`
+}
+
+type DnsHost struct {
+	Id      uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Domain  string `gorm:"unique" json:"domain" form:"domain"`
+	Address string `json:"address" form:"address"`
+}
+
+type FakeDnsPool struct {
+	Id       uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	IpPool   string `gorm:"unique" json:"ipPool" form:"ipPool"`
+	PoolSize int    `json:"poolSize" form:"poolSize"`
+}
+
+type ReverseBridge struct {
+	Id                uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Tag               string `gorm:"unique" json:"tag" form:"tag"`
+	Domain            string `json:"domain" form:"domain"`
+	TunnelOutboundTag string `json:"tunnelOutboundTag" form:"tunnelOutboundTag"`
+	TargetOutboundTag string `json:"targetOutboundTag" form:"targetOutboundTag"`
+}
+
+type ReversePortal struct {
+	Id               uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Tag              string `gorm:"unique" json:"tag" form:"tag"`
+	Domain           string `json:"domain" form:"domain"`
+	TunnelInboundTag string `json:"tunnelInboundTag" form:"tunnelInboundTag"`
+	ClientInboundTag string `json:"clientInboundTag" form:"clientInboundTag"`
+}
+
+const (
+	GeoListDomain = "domain"
+	GeoListIp     = "ip"
+)
+
+type GeoList struct {
+	Id      uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name    string `gorm:"unique" json:"name" form:"name"`
+	Type    string `json:"type" form:"type"`
+	Entries string `json:"entries" form:"entries"`
 }
 
 type Traffic struct {
@@ -102,8 +257,58 @@ type Traffic struct {
 	Traffic   uint64 `json:"traffic" form:"traffic"`
 }
 
+const (
+	HistoryReset   = "reset"
+	HistoryManual  = "manual"
+	HistoryExpired = "expired"
+)
+
+type ClientHistory struct {
+	Id       uint64 `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	ClientId uint   `json:"clientId" form:"clientId" gorm:"index"`
+	StartAt  uint64 `json:"start" form:"start"`
+	EndAt    uint64 `json:"end" form:"end"`
+	Up       uint64 `json:"up" form:"up"`
+	Down     uint64 `json:"down" form:"down"`
+	Quota    uint64 `json:"quota" form:"quota"`
+	Reason   string `json:"reason" form:"reason"`
+}
+
+type ClientAlert struct {
+	Id       uint   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	ClientId uint   `json:"clientId" form:"clientId" gorm:"index"`
+	Event    string `json:"event" form:"event"`
+	Level    int    `json:"level" form:"level"`
+}
+
+type OutboundCheck struct {
+	Id       uint64 `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	DateTime uint64 `json:"dateTime" form:"dateTime" gorm:"index"`
+	Tag      string `json:"tag" form:"tag" gorm:"index"`
+	Success  bool   `json:"success" form:"success"`
+	Delay    int64  `json:"delay" form:"delay"`
+	Error    string `json:"error" form:"error"`
+}
+
+type WebhookLog struct {
+	Id       uint64 `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	DateTime uint64 `json:"dateTime" form:"dateTime"`
+	Event    string `json:"event" form:"event"`
+	Url      string `json:"url" form:"url"`
+	Payload  string `json:"payload" form:"payload"`
+	Status   int    `json:"status" form:"status"`
+	Attempts int    `json:"attempts" form:"attempts"`
+	Error    string `json:"error" form:"error"`
+}
+
 type Setting struct {
 	Id    int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
 	Key   string `json:"key" form:"key"`
 	Value string `json:"value" form:"value"`
 }
+
+type SchemaMigration struct {
+	Version   uint   `json:"version" gorm:"primaryKey;autoIncrement:false"`
+	Name      string `json:"name"`
+	AppliedAt int64  `json:"appliedAt"`
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"raha-xray/logger"
+	"raha-xray/util/common"
+	"strings"
+)
+
+// StringList is a list of strings which is kept as a JSON array in a text column
+type StringList []string
+
+// UnmarshalJSON accepts a JSON array and also the former string encoded array
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*l = list
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return common.NewValidationError("should be an array of strings")
+	}
+	return l.parse(str)
+}
+
+func (l *StringList) parse(str string) error {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		*l = nil
+		return nil
+	}
+	if strings.HasPrefix(str, "[") {
+		var list []string
+		if err := json.Unmarshal([]byte(str), &list); err != nil {
+			return common.NewValidationError("should be an array of strings")
+		}
+		*l = list
+		return nil
+	}
+	l.split(str)
+	return nil
+}
+
+// split reads str as comma separated values
+func (l *StringList) split(str string) {
+	*l = strings.Split(str, ",")
+	for i := range *l {
+		(*l)[i] = strings.TrimSpace((*l)[i])
+	}
+}
+
+// scan parses a stored value, a bad row is read as comma separated values instead of failing the whole query
+func (l *StringList) scan(str string) error {
+	err := l.parse(str)
+	if err != nil {
+		logger.Warning("Invalid string list in database, read as comma separated values:", str)
+		l.split(strings.TrimSpace(str))
+	}
+	return nil
+}
+
+func (l *StringList) Scan(value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		return l.scan(value)
+	case []byte:
+		return l.scan(string(value))
+	}
+	return errors.New("unsupported type for string list")
+}
+
+func (l StringList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal([]string(l))
+	return string(data), err
+}
+
+func (StringList) GormDataType() string {
+	return "string"
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStringListScan(t *testing.T) {
+	for value, want := range map[string][]string{
+		``:                nil,
+		`["a","b"]`:       {"a", "b"},
+		`a, b`:            {"a", "b"},
+		`["a","b"`:        {`["a"`, `"b"`},
+		`[geoip:private]`: {"[geoip:private]"},
+	} {
+		var list StringList
+		err := list.Scan([]byte(value))
+		if err != nil {
+			t.Errorf("scan %q: %v", value, err)
+		}
+		if strings.Join(list, "|") != strings.Join(want, "|") || len(list) != len(want) {
+			t.Errorf("scan %q is %q, want %q", value, list, want)
+		}
+	}
+
+	// input is still validated
+	var list StringList
+	err := list.UnmarshalJSON([]byte(`"[\"a\","`))
+	if err == nil {
+		t.Error("invalid array is accepted on input")
+	}
+}
@@ -11,7 +11,9 @@ import (
 	"raha-xray/database/model"
 	"raha-xray/logger"
 	"raha-xray/util/random"
+	"raha-xray/xray"
 	"syscall"
+	"time"
 	_ "unsafe"
 
 	"github.com/op/go-logging"
@@ -43,6 +45,7 @@ func runServer() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	xray.InitAssetLocation()
 
 	server := api.NewServer()
 	err = server.Start()
@@ -152,6 +155,78 @@ func delToken(id int) {
 	}
 }
 
+func importSqlite(dbPath string) {
+	err := config.LoadSettings()
+	if err != nil {
+		log.Println("Failed to load app settings", err)
+		return
+	}
+
+	err = database.InitDB()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if dbPath == "" {
+		dbPath = config.GetSettings().GetDBPath()
+	}
+	err = database.CopyFromSqlite(dbPath)
+	if err != nil {
+		log.Fatal(err)
+	} else {
+		println("Data of", dbPath, "is now imported.")
+	}
+}
+
+func migrate(action string, steps int) {
+	err := config.LoadSettings()
+	if err != nil {
+		log.Println("Failed to load app settings", err)
+		return
+	}
+
+	err = database.OpenDB()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var done []*database.Migration
+	switch action {
+	case "status":
+		states, err := database.MigrationStatus()
+		if err != nil {
+			log.Fatal(err)
+		}
+		println("VERSION\tNAME\t\tAPPLIED")
+		println("--------*----------")
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt > 0 {
+				applied = time.UnixMilli(state.AppliedAt).Format(time.DateTime)
+			}
+			if !state.Known {
+				applied += " (unknown)"
+			}
+			println(state.Version, "\t", state.Name, "\t", applied)
+		}
+		return
+	case "up":
+		done, err = database.MigrateUp()
+	case "down":
+		done, err = database.MigrateDown(steps)
+	default:
+		println("unknown migrate action:", action)
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(done) == 0 {
+		println("Nothing to migrate.")
+	}
+	for _, migration := range done {
+		println("Migrated", action, migration.Version, migration.Name)
+	}
+}
+
 func main() {
 	if len(os.Args) < 2 {
 		runServer()
@@ -179,11 +254,35 @@ func main() {
 		println("\ttoken -del <id>\t\tdelete token by ID")
 	}
 
+	importCmd := flag.NewFlagSet("import-sqlite", flag.ExitOnError)
+	var dbPath string
+	importCmd.StringVar(&dbPath, "path", "", "sqlite database path, default is the path of dbAddr")
+
+	importCmd.Usage = func() {
+		println("import-sqlite usage:")
+		println("\timport-sqlite [-path <file>]\tcopy all data of a sqlite database to the configured database")
+	}
+
+	migrateCmd := flag.NewFlagSet("migrate", flag.ExitOnError)
+	var steps int
+	migrateCmd.IntVar(&steps, "steps", 1, "count of migrations to revert by down")
+
+	migrateCmd.Usage = func() {
+		println("migrate usage:")
+		println("\tmigrate status\t\tshow applied and pending migrations")
+		println("\tmigrate up\t\tapply pending migrations")
+		println("\tmigrate down [-steps <n>]\trevert the last applied migrations")
+	}
+
 	oldUsage := flag.Usage
 	flag.Usage = func() {
 		oldUsage()
 		println("  token\ttoken subcommand\n")
 		tokenCmd.Usage()
+		println("  import-sqlite\timport-sqlite subcommand\n")
+		importCmd.Usage()
+		println("  migrate\tmigrate subcommand\n")
+		migrateCmd.Usage()
 	}
 
 	flag.Parse()
@@ -210,6 +309,24 @@ func main() {
 		if del > 0 {
 			delToken(del)
 		}
+	case "import-sqlite":
+		err := importCmd.Parse(os.Args[2:])
+		if err != nil {
+			println(err)
+			return
+		}
+		importSqlite(dbPath)
+	case "migrate":
+		if len(os.Args) < 3 {
+			migrateCmd.Usage()
+			return
+		}
+		err := migrateCmd.Parse(os.Args[3:])
+		if err != nil {
+			println(err)
+			return
+		}
+		migrate(os.Args[2], steps)
 	default:
 		flag.Usage()
 	}
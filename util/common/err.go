@@ -16,6 +16,23 @@ func NewError(a ...interface{}) error {
 	return errors.New(msg)
 }
 
+// ValidationError is an error of an invalid input, which is reported as a bad request
+type ValidationError struct {
+	msg string
+}
+
+func (e *ValidationError) Error() string {
+	return e.msg
+}
+
+func NewValidationErrorf(format string, a ...interface{}) error {
+	return &ValidationError{msg: fmt.Sprintf(format, a...)}
+}
+
+func NewValidationError(a ...interface{}) error {
+	return &ValidationError{msg: fmt.Sprintln(a...)}
+}
+
 func Recover(msg string) interface{} {
 	panicErr := recover()
 	if panicErr != nil {
@@ -9,7 +9,10 @@ import (
 	"regexp"
 	"time"
 
+	"github.com/xtls/xray-core/app/observatory"
+	observatoryService "github.com/xtls/xray-core/app/observatory/command"
 	"github.com/xtls/xray-core/app/proxyman/command"
+	routingService "github.com/xtls/xray-core/app/router/command"
 	statsService "github.com/xtls/xray-core/app/stats/command"
 	"github.com/xtls/xray-core/common/protocol"
 	"github.com/xtls/xray-core/common/serial"
@@ -23,10 +26,12 @@ import (
 )
 
 type XrayAPI struct {
-	HandlerServiceClient *command.HandlerServiceClient
-	StatsServiceClient   *statsService.StatsServiceClient
-	grpcClient           *grpc.ClientConn
-	isConnected          bool
+	HandlerServiceClient     *command.HandlerServiceClient
+	StatsServiceClient       *statsService.StatsServiceClient
+	ObservatoryServiceClient *observatoryService.ObservatoryServiceClient
+	RoutingServiceClient     *routingService.RoutingServiceClient
+	grpcClient               *grpc.ClientConn
+	isConnected              bool
 }
 
 func (x *XrayAPI) Init(apiServer string) (err error) {
@@ -41,17 +46,26 @@ func (x *XrayAPI) Init(apiServer string) (err error) {
 
 	hsClient := command.NewHandlerServiceClient(x.grpcClient)
 	ssClient := statsService.NewStatsServiceClient(x.grpcClient)
+	osClient := observatoryService.NewObservatoryServiceClient(x.grpcClient)
+	rsClient := routingService.NewRoutingServiceClient(x.grpcClient)
 
 	x.HandlerServiceClient = &hsClient
 	x.StatsServiceClient = &ssClient
+	x.ObservatoryServiceClient = &osClient
+	x.RoutingServiceClient = &rsClient
 
 	return
 }
 
 func (x *XrayAPI) Close() {
-	x.grpcClient.Close()
+	if x.grpcClient != nil {
+		x.grpcClient.Close()
+		x.grpcClient = nil
+	}
 	x.HandlerServiceClient = nil
 	x.StatsServiceClient = nil
+	x.ObservatoryServiceClient = nil
+	x.RoutingServiceClient = nil
 	x.isConnected = false
 }
 
@@ -113,6 +127,29 @@ func (x *XrayAPI) DelOutbound(tag string) error {
 	return err
 }
 
+// AddRules loads rules and balancers of a routing config, existing ones are replaced unless shouldAppend is set
+func (x *XrayAPI) AddRules(routing []byte, shouldAppend bool) error {
+	client := *x.RoutingServiceClient
+
+	routerConfig := new(conf.RouterConfig)
+	err := json.Unmarshal(routing, routerConfig)
+	if err != nil {
+		logger.Debug("Failed to unmarshal routing:", err)
+		return err
+	}
+	routingConfig, err := routerConfig.Build()
+	if err != nil {
+		logger.Debug("Failed to build routing:", err)
+		return err
+	}
+
+	_, err = client.AddRule(context.Background(), &routingService.AddRuleRequest{
+		Config:       serial.ToTypedMessage(routingConfig),
+		ShouldAppend: shouldAppend,
+	})
+	return err
+}
+
 func (x *XrayAPI) AddUser(Protocol string, inboundTag string, user map[string]interface{}) error {
 	var account *serial.TypedMessage
 	switch Protocol {
@@ -255,3 +292,17 @@ func (x *XrayAPI) GetXrayStats() (*statsService.SysStatsResponse, error) {
 	}
 	return resp, nil
 }
+
+func (x *XrayAPI) GetOutboundStatus() ([]*observatory.OutboundStatus, error) {
+	if x.grpcClient == nil {
+		return nil, common.NewError("xray api is not initialized")
+	}
+	client := *x.ObservatoryServiceClient
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	resp, err := client.GetOutboundStatus(ctx, &observatoryService.GetOutboundStatusRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetStatus().GetStatus(), nil
+}
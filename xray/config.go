@@ -6,17 +6,19 @@ import (
 )
 
 type Config struct {
-	LogConfig       json_util.RawMessage   `json:"log"`
-	DNSConfig       json_util.RawMessage   `json:"dns"`
-	Transport       json_util.RawMessage   `json:"transport"`
-	Policy          json_util.RawMessage   `json:"policy"`
-	API             json_util.RawMessage   `json:"api"`
-	Stats           json_util.RawMessage   `json:"stats"`
-	Reverse         json_util.RawMessage   `json:"reverse"`
-	FakeDNS         json_util.RawMessage   `json:"fakeDns"`
-	InboundConfigs  []InboundConfig        `json:"inbounds"`
-	OutboundConfigs []json_util.RawMessage `json:"outbounds"`
-	RoutingConfig   json_util.RawMessage   `json:"routing"`
+	LogConfig        json_util.RawMessage   `json:"log"`
+	DNSConfig        json_util.RawMessage   `json:"dns"`
+	Transport        json_util.RawMessage   `json:"transport"`
+	Policy           json_util.RawMessage   `json:"policy"`
+	API              json_util.RawMessage   `json:"api"`
+	Stats            json_util.RawMessage   `json:"stats"`
+	Reverse          json_util.RawMessage   `json:"reverse"`
+	FakeDNS          json_util.RawMessage   `json:"fakeDns"`
+	Observatory      json_util.RawMessage   `json:"observatory"`
+	BurstObservatory json_util.RawMessage   `json:"burstObservatory"`
+	InboundConfigs   []InboundConfig        `json:"inbounds"`
+	OutboundConfigs  []json_util.RawMessage `json:"outbounds"`
+	RoutingConfig    json_util.RawMessage   `json:"routing"`
 }
 
 type InboundConfig struct {
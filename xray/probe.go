@@ -0,0 +1,100 @@
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"raha-xray/database/model"
+	"raha-xray/util/json_util"
+	"sync"
+	"time"
+
+	_ "github.com/xtls/xray-core/app/dispatcher"
+	_ "github.com/xtls/xray-core/app/proxyman/inbound"
+	_ "github.com/xtls/xray-core/app/proxyman/outbound"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+const probeConcurrency = 8
+
+// ProbeOutbounds sends a request to probeUrl through each tagged outbound.
+// It runs a temporary xray instance with all outbounds, so chained outbounds work and the running xray is untouched.
+func ProbeOutbounds(outbounds []json_util.RawMessage, tags []string, probeUrl string, timeout time.Duration) ([]*model.OutboundCheck, error) {
+	xrayConfig := &conf.Config{
+		LogConfig: &conf.LogConfig{LogLevel: "none", AccessLog: "none"},
+	}
+	for _, outbound := range outbounds {
+		var outboundConfig conf.OutboundDetourConfig
+		err := json.Unmarshal(outbound, &outboundConfig)
+		if err != nil {
+			return nil, err
+		}
+		xrayConfig.OutboundConfigs = append(xrayConfig.OutboundConfigs, outboundConfig)
+	}
+	config, err := xrayConfig.Build()
+	if err != nil {
+		return nil, err
+	}
+	instance, err := core.New(config)
+	if err != nil {
+		return nil, err
+	}
+	err = instance.Start()
+	if err != nil {
+		return nil, err
+	}
+	defer instance.Close()
+
+	results := make([]*model.OutboundCheck, len(tags))
+	semaphore := make(chan struct{}, probeConcurrency)
+	var wg sync.WaitGroup
+	for index, tag := range tags {
+		wg.Add(1)
+		go func(index int, tag string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			results[index] = probe(instance, tag, probeUrl, timeout)
+		}(index, tag)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func probe(instance *core.Instance, tag string, probeUrl string, timeout time.Duration) *model.OutboundCheck {
+	result := &model.OutboundCheck{
+		DateTime: uint64(time.Now().UnixMilli()),
+		Tag:      tag,
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			DialContext: func(ctx context.Context, network string, addr string) (xnet.Conn, error) {
+				dest, err := xnet.ParseDestination(network + ":" + addr)
+				if err != nil {
+					return nil, err
+				}
+				ctx = session.SetForcedOutboundTagToContext(ctx, tag)
+				return core.Dial(ctx, instance, dest)
+			},
+		},
+	}
+	start := time.Now()
+	resp, err := client.Get(probeUrl)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+	result.Delay = time.Since(start).Milliseconds()
+	if resp.StatusCode >= http.StatusBadRequest {
+		result.Error = resp.Status
+		return result
+	}
+	result.Success = true
+	return result
+}
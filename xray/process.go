@@ -17,6 +17,8 @@ import (
 	"time"
 )
 
+var ErrNotRunning = errors.New("xray is not running")
+
 func GetBinaryName() string {
 	os := runtime.GOOS
 	if os == "darwin" {
@@ -33,6 +35,13 @@ func GetConfigPath() string {
 	return config.GetXrayFolderPath() + "/config.json"
 }
 
+// InitAssetLocation points geosite and geoip matchers to the assets of xray, they are loaded here by routing of the api
+func InitAssetLocation() {
+	if _, found := os.LookupEnv("XRAY_LOCATION_ASSET"); !found {
+		os.Setenv("XRAY_LOCATION_ASSET", config.GetXrayFolderPath())
+	}
+}
+
 type Process struct {
 	*process
 }
@@ -135,6 +144,7 @@ func (p *process) refreshVersion() {
 
 func (p *process) WriteConfigFile(config *Config) (error, bool) {
 	configPath := GetConfigPath()
+	p.config = config
 	p.refreshApiServer(config)
 	data, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
@@ -186,7 +196,7 @@ func (p *process) Restart() error {
 
 func (p *process) Stop() error {
 	if !p.IsRunning() {
-		return errors.New("xray is not running")
+		return ErrNotRunning
 	}
 
 	return p.signalXray("stop")